@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -10,6 +12,11 @@ import (
 	"marketplace-api/internal/config"
 	"marketplace-api/internal/logger"
 	"marketplace-api/pkg/database"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 // @title           Duken-API
@@ -54,12 +61,34 @@ func main() {
 
 	router := gin.Default() // Create a Gin router
 
-	api.NewServer(router, db, log, cfg) // Initialize API server
+	server := api.NewServer(router, db, log, cfg) // Initialize API server
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	err = router.Run(cfg.ServerAddress)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server.StartJobs(ctx)
+
+	srv := &http.Server{
+		Addr:    cfg.ServerAddress,
+		Handler: router,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to run server: %s", err.Error())
+		}
+	}()
+
+	<-ctx.Done()
+	log.Info("shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatalf("Failed to run server: %s", err.Error())
+		log.Errorf("Failed to shut down server: %s", err.Error())
 	}
+	server.StopJobs()
 }
@@ -18,11 +18,14 @@ type AdminHandler struct {
 	userService        *services.UserService
 	distributorService *services.DistributorService
 	storeService       *services.StoreService
+	messageService     *services.MessageService
+	productService     *services.ProductService
+	payoutService      *services.PayoutService
 	log                *logrus.Logger
 }
 
-func NewAdminHandler(userService *services.UserService, distributorService *services.DistributorService, storeService *services.StoreService, log *logrus.Logger) *AdminHandler {
-	return &AdminHandler{userService: userService, distributorService: distributorService, storeService: storeService, log: log}
+func NewAdminHandler(userService *services.UserService, distributorService *services.DistributorService, storeService *services.StoreService, messageService *services.MessageService, productService *services.ProductService, payoutService *services.PayoutService, log *logrus.Logger) *AdminHandler {
+	return &AdminHandler{userService: userService, distributorService: distributorService, storeService: storeService, messageService: messageService, productService: productService, payoutService: payoutService, log: log}
 }
 
 func (ah *AdminHandler) GetAllUsers(c *gin.Context) {
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+)
+
+// ListThreads returns all message threads, optionally of one store or
+// distributor.
+func (ah *AdminHandler) ListThreads(c *gin.Context) {
+	query, filters, ok := readThreadQuery(c)
+	if !ok {
+		return
+	}
+	v := validator.New()
+	qs := c.Request.URL.Query()
+	query.StoreID = int64(validator.ReadInt(qs, "store_id", 0, v))
+	query.DistributorID = int64(validator.ReadInt(qs, "distributor_id", 0, v))
+	if !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	threads, metadata, err := ah.messageService.GetThreads(query, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"threads": threads, "metadata": metadata})
+}
+
+// GetThread returns the thread with all its messages, including hidden ones.
+func (ah *AdminHandler) GetThread(c *gin.Context) {
+	thread, ok := ah.thread(c)
+	if !ok {
+		return
+	}
+	messages, err := ah.messageService.GetMessages(thread.ID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	thread.Messages = messages
+	c.JSON(http.StatusOK, gin.H{"thread": thread})
+}
+
+// LockThread stops the participants from posting to the thread.
+func (ah *AdminHandler) LockThread(c *gin.Context) {
+	ah.setThreadLocked(c, true)
+}
+
+func (ah *AdminHandler) UnlockThread(c *gin.Context) {
+	ah.setThreadLocked(c, false)
+}
+
+func (ah *AdminHandler) setThreadLocked(c *gin.Context, locked bool) {
+	thread, ok := ah.thread(c)
+	if !ok {
+		return
+	}
+	if err := ah.messageService.SetThreadLocked(thread.ID, locked); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	thread.Locked = locked
+	c.JSON(http.StatusOK, gin.H{"thread": thread})
+}
+
+// HideMessage hides the message from the participants of its thread.
+func (ah *AdminHandler) HideMessage(c *gin.Context) {
+	message, ok := ah.message(c)
+	if !ok {
+		return
+	}
+	var input models.ModerationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	v := validator.New()
+	if models.ValidateModeration(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if err := ah.messageService.HideMessage(message.ID, c.GetInt64("user_id"), input.Reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	message, err := ah.messageService.GetMessageByID(message.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// RestoreMessage shows a hidden message again.
+func (ah *AdminHandler) RestoreMessage(c *gin.Context) {
+	message, ok := ah.message(c)
+	if !ok {
+		return
+	}
+	if err := ah.messageService.RestoreMessage(message.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	message, err := ah.messageService.GetMessageByID(message.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (ah *AdminHandler) thread(c *gin.Context) (*models.MessageThread, bool) {
+	threadID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || threadID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return nil, false
+	}
+	thread, err := ah.messageService.GetThreadByID(threadID, 0)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return thread, true
+}
+
+func (ah *AdminHandler) message(c *gin.Context) (*models.Message, bool) {
+	messageID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || messageID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return nil, false
+	}
+	message, err := ah.messageService.GetMessageByID(messageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return message, true
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"marketplace-api/internal/models"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ListReviews returns the reviews of a moderation queue with their open
+// reports. The default queue holds the held and the reported reviews.
+func (ah *AdminHandler) ListReviews(c *gin.Context) {
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	queue := validator.ReadString(qs, "queue", "pending")
+	var filters models.Filters
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "created_at")
+	filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(validator.In(queue, models.ReviewQueues...), "queue", "must be one of "+strings.Join(models.ReviewQueues, ", "))
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	reviews, metadata, err := ah.productService.GetReviewQueue(queue, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reviews": reviews, "metadata": metadata})
+}
+
+// GetReview returns the review with all its reports.
+func (ah *AdminHandler) GetReview(c *gin.Context) {
+	reviewID, ok := reviewParam(c)
+	if !ok {
+		return
+	}
+	ah.respondReview(c, reviewID)
+}
+
+// HideReview hides the review from everyone but its store.
+func (ah *AdminHandler) HideReview(c *gin.Context) {
+	reviewID, ok := reviewParam(c)
+	if !ok {
+		return
+	}
+	var input models.ModerationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	v := validator.New()
+	if models.ValidateModeration(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if err := ah.productService.HideReview(reviewID, c.GetInt64("user_id"), input.Reason); err != nil {
+		reviewError(c, err)
+		return
+	}
+	ah.respondReview(c, reviewID)
+}
+
+// RestoreReview publishes a held or hidden review.
+func (ah *AdminHandler) RestoreReview(c *gin.Context) {
+	reviewID, ok := reviewParam(c)
+	if !ok {
+		return
+	}
+	if err := ah.productService.RestoreReview(reviewID, c.GetInt64("user_id")); err != nil {
+		reviewError(c, err)
+		return
+	}
+	ah.respondReview(c, reviewID)
+}
+
+func (ah *AdminHandler) respondReview(c *gin.Context, reviewID int64) {
+	review, err := ah.productService.GetReviewByID(reviewID)
+	if err != nil {
+		reviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
+
+func reviewParam(c *gin.Context) (int64, bool) {
+	reviewID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || reviewID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return 0, false
+	}
+	return reviewID, true
+}
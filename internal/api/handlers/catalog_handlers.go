@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/spreadsheet"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const maxImportFileSize = 20 << 20
+
+func (dh *DistributorHandler) ImportProducts(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "No file is received"})
+		return
+	}
+	dryRun, err := strconv.ParseBool(validator.ReadString(c.Request.URL.Query(), "dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"dry_run": "must be true or false"}})
+		return
+	}
+
+	v := validator.New()
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+	v.Check(validator.In(format, models.ImportFormatCSV, models.ImportFormatXLSX), "file", "must be a csv or xlsx file")
+	v.Check(file.Size <= maxImportFileSize, "file", "must not be larger than 20 MB")
+	if !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	var records [][]string
+	if format == models.ImportFormatXLSX {
+		records, err = spreadsheet.ReadXLSX(f, file.Size)
+	} else {
+		records, err = spreadsheet.ReadCSV(f)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"file": "could not be read: " + err.Error()}})
+		return
+	}
+	if len(records) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"file": "must not be empty"}})
+		return
+	}
+
+	records[0] = models.NormaliseHeader(records[0])
+	if models.ValidateImportHeader(v, records[0]); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	productImport := &models.ProductImport{
+		DistributorID: c.GetInt64("user_id"),
+		FileName:      file.Filename,
+		Format:        format,
+		DryRun:        dryRun,
+	}
+	err = dh.catalogService.StartImport(productImport, records)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"import": productImport})
+}
+
+func (dh *DistributorHandler) GetProductImport(c *gin.Context) {
+	importID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || importID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	productImport, err := dh.catalogService.GetImportByID(importID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if productImport.DistributorID != c.GetInt64("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"import": productImport})
+}
+
+func (dh *DistributorHandler) ListProductImports(c *gin.Context) {
+	var filters models.Filters
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	imports, metadata, err := dh.catalogService.GetImports(c.GetInt64("user_id"), filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"imports": imports, "metadata": metadata})
+}
+
+func (dh *DistributorHandler) ExportProducts(c *gin.Context) {
+	format := validator.ReadString(c.Request.URL.Query(), "format", models.ImportFormatCSV)
+	if !validator.In(format, models.ImportFormatCSV, models.ImportFormatXLSX) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"format": "must be csv or xlsx"}})
+		return
+	}
+
+	records, err := dh.catalogService.Export(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, format))
+	if format == models.ImportFormatXLSX {
+		var numeric []int
+		for i, column := range models.ProductColumns {
+			if validator.In(column, "price", "minimum_quantity", "stock") {
+				numeric = append(numeric, i)
+			}
+		}
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		err = spreadsheet.WriteXLSX(c.Writer, "Products", records, numeric...)
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		err = spreadsheet.WriteCSV(c.Writer, records)
+	}
+	if err != nil {
+		_ = c.Error(err)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ListCreditAccounts returns the stores the distributor grants credit to,
+// with their outstanding and available credit.
+func (dh *DistributorHandler) ListCreditAccounts(c *gin.Context) {
+	accounts, err := dh.creditService.GetCreditAccounts(c.GetInt64("user_id"), "distributor")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"credit_accounts": accounts})
+}
+
+// SaveCreditAccount godoc
+// @Summary      Grant a store trade credit
+// @Description  Sets the credit limit and payment terms of the store. Checkouts that would take the unpaid balance of the store's invoices over the limit are rejected, or accepted with their orders on credit hold when over_limit_action is flag.
+// @Tags         distributor
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        store_id path int true "Store ID"
+// @Param        account body models.CreditAccountInput true "Credit terms"
+// @Success      200  {object}  models.CreditAccount
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /distributor/credit/{store_id} [put]
+func (dh *DistributorHandler) SaveCreditAccount(c *gin.Context) {
+	storeID, ok := storeParam(c)
+	if !ok {
+		return
+	}
+	var input models.CreditAccountInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	account := &models.CreditAccount{
+		DistributorID:    c.GetInt64("user_id"),
+		StoreID:          storeID,
+		CreditLimit:      input.CreditLimit,
+		PaymentTermsDays: input.PaymentTermsDays,
+		OverLimitAction:  input.OverLimitAction,
+	}
+	if account.OverLimitAction == "" {
+		account.OverLimitAction = models.CreditActionBlock
+	}
+	v := validator.New()
+	if models.ValidateCreditAccount(v, account); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	account, err := dh.creditService.SaveCreditAccount(account)
+	if err != nil {
+		creditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"credit_account": account})
+}
+
+func (dh *DistributorHandler) GetCreditAccount(c *gin.Context) {
+	storeID, ok := storeParam(c)
+	if !ok {
+		return
+	}
+	account, err := dh.creditService.GetCreditAccount(c.GetInt64("user_id"), storeID)
+	if err != nil {
+		creditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"credit_account": account})
+}
+
+// DeleteCreditAccount withdraws the store's credit. Its invoices are kept;
+// later checkouts are not limited and use the default payment terms.
+func (dh *DistributorHandler) DeleteCreditAccount(c *gin.Context) {
+	storeID, ok := storeParam(c)
+	if !ok {
+		return
+	}
+	if err := dh.creditService.DeleteCreditAccount(c.GetInt64("user_id"), storeID); err != nil {
+		creditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "credit account deleted successfully"})
+}
+
+// GetAgingReport godoc
+// @Summary      Accounts receivable aging
+// @Description  Returns the unpaid balance of each store's invoices split by days past due: current, 1-30, 31-60, 61-90 and over 90 days.
+// @Tags         distributor
+// @Security     BearerToken
+// @Produce      json
+// @Success      200  {object}  models.AgingReport
+// @Router       /distributor/receivables/aging [get]
+func (dh *DistributorHandler) GetAgingReport(c *gin.Context) {
+	report, err := dh.creditService.GetAgingReport(c.GetInt64("user_id"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"aging": report})
+}
+
+// ListCreditAccounts returns the credit the store has with distributors.
+func (sh *StoreHandler) ListCreditAccounts(c *gin.Context) {
+	accounts, err := sh.creditService.GetCreditAccounts(c.GetInt64("user_id"), "store")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"credit_accounts": accounts})
+}
+
+func storeParam(c *gin.Context) (int64, bool) {
+	storeID, err := strconv.ParseInt(c.Param("store_id"), 10, 64)
+	if err != nil || storeID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid store_id parameter"})
+		return 0, false
+	}
+	return storeID, true
+}
+
+func creditError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
 	"marketplace-api/internal/services"
 	validator "marketplace-api/internal/util"
 	"math"
@@ -20,10 +21,19 @@ type DistributorHandler struct {
 	distributorService *services.DistributorService
 	productServices    *services.ProductService
 	orderService       *services.OrderService
+	deliveryService    *services.DeliveryService
+	inventoryService   *services.InventoryService
+	catalogService     *services.CatalogService
+	webhookService     *services.WebhookService
+	invoiceService     *services.InvoiceService
+	creditService      *services.CreditService
+	payoutService      *services.PayoutService
+	promotionService   *services.PromotionService
+	documentService    *services.DocumentService
 }
 
-func NewDistributorHandler(distributorService *services.DistributorService, productServices *services.ProductService, orderService *services.OrderService) *DistributorHandler {
-	return &DistributorHandler{distributorService: distributorService, productServices: productServices, orderService: orderService}
+func NewDistributorHandler(distributorService *services.DistributorService, productServices *services.ProductService, orderService *services.OrderService, deliveryService *services.DeliveryService, inventoryService *services.InventoryService, catalogService *services.CatalogService, webhookService *services.WebhookService, invoiceService *services.InvoiceService, creditService *services.CreditService, payoutService *services.PayoutService, promotionService *services.PromotionService, documentService *services.DocumentService) *DistributorHandler {
+	return &DistributorHandler{distributorService: distributorService, productServices: productServices, orderService: orderService, deliveryService: deliveryService, inventoryService: inventoryService, catalogService: catalogService, webhookService: webhookService, invoiceService: invoiceService, creditService: creditService, payoutService: payoutService, promotionService: promotionService, documentService: documentService}
 }
 
 // GetProfile godoc
@@ -67,32 +77,83 @@ func (dh *DistributorHandler) UpdateProfile(c *gin.Context) {
 
 }
 
+func (dh *DistributorHandler) GetTaxSettings(c *gin.Context) {
+	distributor, err := dh.distributorService.GetDistributorByUserID(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tax_settings": distributor.TaxSettings})
+}
+
+// UpdateTaxSettings godoc
+// @Summary      Update VAT settings
+// @Description  Sets whether the distributor is a VAT payer, its VAT rate in percent and whether product prices include VAT. Products of the zero or exempt tax category are not taxed.
+// @Tags         distributor
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        settings body models.TaxSettings true "Tax settings"
+// @Success      200  {object}  models.TaxSettings
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /distributor/tax-settings [put]
+func (dh *DistributorHandler) UpdateTaxSettings(c *gin.Context) {
+	var input models.TaxSettings
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidateTaxSettings(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if err := dh.distributorService.UpdateTaxSettings(c.GetInt64("user_id"), input); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tax_settings": input})
+}
+
 func (dh *DistributorHandler) CreateProduct(c *gin.Context) {
 	var input struct {
-		ProductName        string   `json:"product_name"`
-		ProductDescription string   `json:"product_description"`
-		Price              float64  `json:"price"`
-		ImgURLs            []string `json:"ImgURLs"`
-		MinimumQuantity    int64    `json:"minimum_quantity"`
-		Stock              int64    `json:"stock"`
-		City               string   `json:"city"`
-		Category           string   `json:"category"`
+		ProductName          string     `json:"product_name"`
+		SKU                  string     `json:"sku"`
+		ProductDescription   string     `json:"product_description"`
+		Price                float64    `json:"price"`
+		ImgURLs              []string   `json:"ImgURLs"`
+		MinimumQuantity      int64      `json:"minimum_quantity"`
+		Stock                int64      `json:"stock"`
+		City                 string     `json:"city"`
+		Category             string     `json:"category"`
+		TaxCategory          string     `json:"tax_category"`
+		AllowBackorder       bool       `json:"allow_backorder"`
+		BackorderAvailableAt *time.Time `json:"backorder_available_at"`
+		LowStockThreshold    int64      `json:"low_stock_threshold"`
 	}
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
+	if input.TaxCategory != "" && !validator.In(input.TaxCategory, models.TaxCategories...) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"tax_category": "must be standard, zero or exempt"}})
+		return
+	}
 
 	product := &models.Product{
-		ProductName:        input.ProductName,
-		ProductDescription: input.ProductDescription,
-		Price:              input.Price,
-		ImgURLs:            input.ImgURLs,
-		MinimumQuantity:    input.MinimumQuantity,
-		DistributorID:      c.GetInt64("user_id"),
-		Stock:              input.Stock,
-		City:               input.City,
-		Category:           input.Category,
+		ProductName:          input.ProductName,
+		SKU:                  input.SKU,
+		ProductDescription:   input.ProductDescription,
+		Price:                input.Price,
+		ImgURLs:              input.ImgURLs,
+		MinimumQuantity:      input.MinimumQuantity,
+		DistributorID:        c.GetInt64("user_id"),
+		City:                 input.City,
+		Category:             input.Category,
+		TaxCategory:          input.TaxCategory,
+		AllowBackorder:       input.AllowBackorder,
+		BackorderAvailableAt: input.BackorderAvailableAt,
+		LowStockThreshold:    input.LowStockThreshold,
 	}
 	fmt.Println(product.ImgURLs)
 	err := dh.productServices.CreateProduct(product)
@@ -100,6 +161,11 @@ func (dh *DistributorHandler) CreateProduct(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	err = dh.inventoryService.SetTotalStock(product, input.Stock, c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Product created successfully"})
 }
@@ -110,19 +176,28 @@ func (dh *DistributorHandler) UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
 	}
 	var input struct {
-		ProductName        string   `json:"product_name"`
-		ProductDescription string   `json:"product_description"`
-		Price              float64  `json:"price"`
-		ImgURLs            []string `json:"ImgURLs"`
-		MinimumQuantity    int64    `json:"minimum_quantity"`
-		Stock              int64    `json:"stock"`
-		City               string   `json:"city"`
-		Category           string   `json:"category"`
+		ProductName          string     `json:"product_name"`
+		SKU                  string     `json:"sku"`
+		ProductDescription   string     `json:"product_description"`
+		Price                float64    `json:"price"`
+		ImgURLs              []string   `json:"ImgURLs"`
+		MinimumQuantity      int64      `json:"minimum_quantity"`
+		Stock                int64      `json:"stock"`
+		City                 string     `json:"city"`
+		Category             string     `json:"category"`
+		TaxCategory          string     `json:"tax_category"`
+		AllowBackorder       bool       `json:"allow_backorder"`
+		BackorderAvailableAt *time.Time `json:"backorder_available_at"`
+		LowStockThreshold    int64      `json:"low_stock_threshold"`
 	}
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
+	if input.TaxCategory != "" && !validator.In(input.TaxCategory, models.TaxCategories...) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"tax_category": "must be standard, zero or exempt"}})
+		return
+	}
 
 	product, err := dh.productServices.GetProductByID(productId)
 	if err != nil {
@@ -138,17 +213,22 @@ func (dh *DistributorHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	current := product
 	product = &models.Product{
-		ID:                 productId,
-		ProductName:        input.ProductName,
-		ProductDescription: input.ProductDescription,
-		Price:              input.Price,
-		ImgURLs:            input.ImgURLs,
-		MinimumQuantity:    input.MinimumQuantity,
-		DistributorID:      c.GetInt64("user_id"),
-		Stock:              input.Stock,
-		City:               input.City,
-		Category:           input.Category,
+		ID:                   productId,
+		ProductName:          input.ProductName,
+		SKU:                  input.SKU,
+		ProductDescription:   input.ProductDescription,
+		Price:                input.Price,
+		ImgURLs:              input.ImgURLs,
+		MinimumQuantity:      input.MinimumQuantity,
+		DistributorID:        c.GetInt64("user_id"),
+		City:                 input.City,
+		Category:             input.Category,
+		TaxCategory:          input.TaxCategory,
+		AllowBackorder:       input.AllowBackorder,
+		BackorderAvailableAt: input.BackorderAvailableAt,
+		LowStockThreshold:    input.LowStockThreshold,
 	}
 	fmt.Println(product.ImgURLs)
 
@@ -157,6 +237,20 @@ func (dh *DistributorHandler) UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	err = dh.inventoryService.SetTotalStock(current, input.Stock, c.GetInt64("user_id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientStock) {
+			c.JSON(http.StatusConflict, gin.H{"error": "stock cannot be lower than the quantity reserved by orders"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = dh.orderService.ReleaseBackorders(productId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusCreated, gin.H{"message": "Product updated successfully"})
 }
 
@@ -209,7 +303,7 @@ func (dh *DistributorHandler) ListProducts(c *gin.Context) {
 	input.Filters.PageSize = pageSize
 	input.Filters.Sort = c.DefaultQuery("sort", "id")
 
-	input.Filters.SortSafelist = []string{"id", "product_name", "price", "created_at", "-id", "-product_name", "-price", "-created_at"}
+	input.Filters.SortSafelist = []string{"id", "product_name", "price", "created_at", "rating", "-id", "-product_name", "-price", "-created_at", "-rating"}
 
 	if models.ValidateFilters(v, input.Filters); !v.Valid() {
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
@@ -309,12 +403,11 @@ func (dh *DistributorHandler) GetOrder(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	product, err := dh.productServices.GetProductByID(order.ProductID)
+	err = dh.productServices.AttachOrderProduct(order)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	order.Product = *product
 	order.TotalPrice = math.Round(order.TotalPrice*100) / 100
 	c.JSON(http.StatusOK, gin.H{"order": order})
 }
@@ -322,7 +415,15 @@ func (dh *DistributorHandler) GetOrder(c *gin.Context) {
 func (dh *DistributorHandler) ListOrders(c *gin.Context) {
 	fmt.Println("here")
 	distributorID := c.GetInt64("user_id")
-	orders, err := dh.orderService.GetOrders(distributorID, "distributor")
+	paymentStatus, ok := paymentStatusQuery(c)
+	if !ok {
+		return
+	}
+	number, ok := orderNumberQuery(c)
+	if !ok {
+		return
+	}
+	orders, err := dh.orderService.GetOrders(distributorID, "distributor", paymentStatus, number)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, "order not found")
@@ -332,12 +433,11 @@ func (dh *DistributorHandler) ListOrders(c *gin.Context) {
 		return
 	}
 	for i, order := range orders {
-		product, err := dh.productServices.GetProductByID(order.ProductID)
+		err := dh.productServices.AttachOrderProduct(&orders[i])
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, err.Error())
 			return
 		}
-		orders[i].Product = *product
 		orders[i].TotalPrice = math.Round(order.TotalPrice*100) / 100
 	}
 	c.JSON(http.StatusOK, gin.H{"orders": orders})
@@ -354,22 +454,27 @@ func (dh *DistributorHandler) GetStatistics(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	var soldOverall float64
-	var soldInMonth float64
+	var soldOverall, soldOverallNet, taxOverall float64
+	var soldInMonth, soldInMonthNet, taxInMonth float64
 	for i, order := range orders {
 		soldOverall = soldOverall + order.TotalPrice
+		soldOverallNet = soldOverallNet + order.NetAmount
+		taxOverall = taxOverall + order.TaxAmount
 		if order.Timestamp.Year() == time.Now().Year() && time.Now().Month() == order.Timestamp.Month() {
 			soldInMonth = soldInMonth + order.TotalPrice
+			soldInMonthNet = soldInMonthNet + order.NetAmount
+			taxInMonth = taxInMonth + order.TaxAmount
 		}
-		product, err := dh.productServices.GetProductByID(order.ProductID)
+		err := dh.productServices.AttachOrderProduct(&orders[i])
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, err.Error())
 			return
 		}
-		orders[i].Product = *product
 	}
 
-	c.JSON(http.StatusOK, gin.H{"orders": orders, "sold_overall": soldOverall, "sold_in_month": soldInMonth})
+	c.JSON(http.StatusOK, gin.H{"orders": orders, "sold_overall": soldOverall, "sold_in_month": soldInMonth,
+		"sold_overall_net": models.RoundMoney(soldOverallNet), "tax_overall": models.RoundMoney(taxOverall),
+		"sold_in_month_net": models.RoundMoney(soldInMonthNet), "tax_in_month": models.RoundMoney(taxInMonth)})
 }
 
 func (dh *DistributorHandler) GetReviews(c *gin.Context) {
@@ -398,3 +503,176 @@ func (dh *DistributorHandler) GetReviewByProductId(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"reviews": reviews})
 }
+
+// ReplyToReview sets the public reply to a review of the distributor's
+// products; a reply replaces the earlier one.
+func (dh *DistributorHandler) ReplyToReview(c *gin.Context) {
+	reviewId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || reviewId < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	var input models.ReviewReplyInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidateReviewReply(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	review, err := dh.productServices.ReplyToReview(c.GetInt64("user_id"), reviewId, input.Text)
+	if err != nil {
+		reviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
+
+func (dh *DistributorHandler) DeleteReviewReply(c *gin.Context) {
+	reviewId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || reviewId < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	review, err := dh.productServices.ReplyToReview(c.GetInt64("user_id"), reviewId, "")
+	if err != nil {
+		reviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
+
+func (dh *DistributorHandler) ReportReview(c *gin.Context) {
+	reportReview(c, dh.productServices)
+}
+
+type deliverySlotInput struct {
+	City        string       `json:"city"`
+	Weekday     time.Weekday `json:"weekday"`
+	StartTime   string       `json:"start_time"`
+	EndTime     string       `json:"end_time"`
+	Capacity    int64        `json:"capacity"`
+	CutoffHours int64        `json:"cutoff_hours"`
+	Active      *bool        `json:"active"`
+}
+
+func (in deliverySlotInput) slot(distributorID int64) *models.DeliverySlot {
+	slot := &models.DeliverySlot{
+		DistributorID: distributorID,
+		City:          in.City,
+		Weekday:       in.Weekday,
+		StartTime:     in.StartTime,
+		EndTime:       in.EndTime,
+		Capacity:      in.Capacity,
+		CutoffHours:   in.CutoffHours,
+		Active:        true,
+	}
+	if in.Active != nil {
+		slot.Active = *in.Active
+	}
+	return slot
+}
+
+func (dh *DistributorHandler) CreateDeliverySlot(c *gin.Context) {
+	var input deliverySlotInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	slot := input.slot(c.GetInt64("user_id"))
+
+	v := validator.New()
+	if models.ValidateDeliverySlot(v, slot); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err := dh.deliveryService.CreateSlot(slot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"delivery_slot": slot})
+}
+
+func (dh *DistributorHandler) UpdateDeliverySlot(c *gin.Context) {
+	slotID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || slotID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	var input deliverySlotInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	existing, err := dh.deliveryService.GetSlotByID(slotID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing.DistributorID != c.GetInt64("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+
+	slot := input.slot(existing.DistributorID)
+	slot.ID = slotID
+	v := validator.New()
+	if models.ValidateDeliverySlot(v, slot); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err = dh.deliveryService.UpdateSlot(slot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivery_slot": slot})
+}
+
+func (dh *DistributorHandler) DeleteDeliverySlot(c *gin.Context) {
+	slotID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || slotID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	slot, err := dh.deliveryService.GetSlotByID(slotID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if slot.DistributorID != c.GetInt64("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+
+	err = dh.deliveryService.DeleteSlot(slotID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "delivery slot deleted successfully"})
+}
+
+func (dh *DistributorHandler) ListDeliverySlots(c *gin.Context) {
+	slots, err := dh.deliveryService.GetSlots(c.GetInt64("user_id"), c.DefaultQuery("city", ""))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivery_slots": slots})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+)
+
+// GetOrderDocument godoc
+// @Summary      Download the invoice or the waybill of an order
+// @Description  Returns the PDF of the invoice or the waybill covering the order. Documents are numbered and stored when first downloaded and do not change afterwards.
+// @Tags         distributor
+// @Security     BearerToken
+// @Produce      application/pdf
+// @Param        id path int true "Order ID"
+// @Param        kind path string true "invoice or waybill"
+// @Success      200  {file}    file
+// @Failure      404  {string}  Not found
+// @Failure      409  {string}  Conflict
+// @Router       /distributor/orders/{id}/documents/{kind} [get]
+func (dh *DistributorHandler) GetOrderDocument(c *gin.Context) {
+	kind := c.Param("kind")
+	if !validator.In(kind, models.DocumentKinds...) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+	sendOrderDocument(c, dh.documentService, "distributor", kind)
+}
+
+// GetOrderInvoice returns the PDF invoice covering the store's order.
+func (sh *StoreHandler) GetOrderInvoice(c *gin.Context) {
+	sendOrderDocument(c, sh.documentService, "store", models.DocumentInvoice)
+}
+
+func sendOrderDocument(c *gin.Context, documentService *services.DocumentService, role, kind string) {
+	orderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || orderID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	document, content, err := documentService.GetOrderDocument(c.GetInt64("user_id"), orderID, role, kind)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		case errors.Is(err, services.ErrOrderNotInvoiced), errors.Is(err, services.ErrNothingToDocument):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	defer content.Close()
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, document.FileName))
+	c.DataFromReader(http.StatusOK, document.Size, "application/pdf", content, nil)
+}
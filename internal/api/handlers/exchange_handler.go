@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	"net/http"
+	"strings"
+)
+
+// exchangeCookie is the session cookie 1C receives from checkauth and sends
+// with every following request.
+const exchangeCookie = "exchange_token"
+
+// ExchangeHandler serves the CommerceML 2 exchange protocol used by 1C. Every
+// answer is plain text starting with "success", "progress" or "failure".
+type ExchangeHandler struct {
+	userService     *services.UserService
+	exchangeService *services.ExchangeService
+	jwtSecret       string
+	fileLimit       int64
+	log             *logrus.Logger
+}
+
+func NewExchangeHandler(userService *services.UserService, exchangeService *services.ExchangeService, jwtSecret string, fileLimit int64, log *logrus.Logger) *ExchangeHandler {
+	return &ExchangeHandler{userService: userService, exchangeService: exchangeService, jwtSecret: jwtSecret, fileLimit: fileLimit, log: log}
+}
+
+// Exchange godoc
+// @Summary      1C exchange
+// @Description  CommerceML 2 catalog (type=catalog) and order (type=sale) exchange with modes checkauth, init, file, import, query and success
+// @Tags         exchange
+// @Produce      plain
+// @Param        type      query  string  true   "catalog or sale"
+// @Param        mode      query  string  true   "checkauth, init, file, import, query or success"
+// @Param        filename  query  string  false  "file name for file and import modes"
+// @Success      200  {string}  string
+// @Router       /1c_exchange [get]
+// @Router       /1c_exchange [post]
+func (eh *ExchangeHandler) Exchange(c *gin.Context) {
+	exchangeType := c.Query("type")
+	mode := c.Query("mode")
+	if exchangeType != "catalog" && exchangeType != "sale" {
+		eh.failure(c, http.StatusBadRequest, "unknown exchange type")
+		return
+	}
+	if mode == "checkauth" {
+		eh.checkAuth(c)
+		return
+	}
+
+	distributorID, ok := eh.authorize(c)
+	if !ok {
+		eh.failure(c, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	switch {
+	case mode == "init":
+		if err := eh.exchangeService.Init(distributorID); err != nil {
+			eh.exchangeError(c, err)
+			return
+		}
+		c.String(http.StatusOK, "zip=no\nfile_limit=%d\n", eh.fileLimit)
+	case mode == "file":
+		fileName := c.Query("filename")
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, eh.fileLimit)
+		if err := eh.exchangeService.SaveFile(distributorID, fileName, body); err != nil {
+			eh.exchangeError(c, err)
+			return
+		}
+		if exchangeType == "sale" && strings.HasSuffix(strings.ToLower(fileName), ".xml") {
+			result, err := eh.exchangeService.ApplyOrderChanges(distributorID, fileName)
+			if err != nil {
+				eh.exchangeError(c, err)
+				return
+			}
+			eh.success(c, fmt.Sprintf("orders updated: %d, skipped: %d", result.Updated, result.Skipped), result.Problems)
+			return
+		}
+		eh.success(c, "", nil)
+	case mode == "import" && exchangeType == "catalog":
+		result, err := eh.exchangeService.ImportFile(distributorID, c.Query("filename"))
+		if err != nil {
+			eh.exchangeError(c, err)
+			return
+		}
+		eh.success(c, fmt.Sprintf("created: %d, updated: %d, skipped: %d", result.Created, result.Updated, result.Skipped), result.Problems)
+	case mode == "query" && exchangeType == "sale":
+		content, err := eh.exchangeService.QueryOrders(distributorID)
+		if err != nil {
+			eh.exchangeError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/xml; charset=utf-8", content)
+	case mode == "success" && exchangeType == "sale":
+		if err := eh.exchangeService.ConfirmOrders(distributorID); err != nil {
+			eh.exchangeError(c, err)
+			return
+		}
+		eh.success(c, "", nil)
+	default:
+		eh.failure(c, http.StatusBadRequest, "unknown mode "+mode)
+	}
+}
+
+// checkAuth logs the distributor in with HTTP basic auth and hands out the
+// session cookie.
+func (eh *ExchangeHandler) checkAuth(c *gin.Context) {
+	user, ok := eh.basicAuthUser(c)
+	if !ok {
+		eh.failure(c, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	token, err := generateJWTToken(user, eh.jwtSecret)
+	if err != nil {
+		eh.exchangeError(c, err)
+		return
+	}
+	c.String(http.StatusOK, "success\n%s\n%s\n", exchangeCookie, token)
+}
+
+// authorize accepts the session cookie from checkauth, or basic auth for
+// clients that resend their credentials with every request. Either way the
+// distributor must still be active.
+func (eh *ExchangeHandler) authorize(c *gin.Context) (int64, bool) {
+	if tokenString, err := c.Cookie(exchangeCookie); err == nil && tokenString != "" {
+		claims := &CustomClaims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(eh.jwtSecret), nil
+		})
+		if err == nil && token.Valid && claims.Role == models.RoleDistributor && eh.userService.GetStatusById(claims.ID) {
+			return claims.ID, true
+		}
+	}
+	user, ok := eh.basicAuthUser(c)
+	if !ok {
+		return 0, false
+	}
+	return user.ID, true
+}
+
+func (eh *ExchangeHandler) basicAuthUser(c *gin.Context) (*models.User, bool) {
+	email, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	user, err := eh.userService.ValidateCredentials(models.LoginCredentials{
+		Email:    email,
+		Password: password,
+		Role:     models.RoleDistributor,
+	})
+	if err != nil || !eh.userService.GetStatusById(user.ID) {
+		return nil, false
+	}
+	return user, true
+}
+
+func (eh *ExchangeHandler) success(c *gin.Context, message string, problems []string) {
+	for _, problem := range problems {
+		eh.log.WithField("exchange", c.Query("type")).Warn(problem)
+	}
+	lines := append([]string{"success"}, problems...)
+	if message != "" {
+		lines = append(lines, message)
+	}
+	c.String(http.StatusOK, strings.Join(lines, "\n")+"\n")
+}
+
+func (eh *ExchangeHandler) failure(c *gin.Context, status int, message string) {
+	c.String(status, "failure\n%s\n", message)
+}
+
+func (eh *ExchangeHandler) exchangeError(c *gin.Context, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrExchangeFileName), errors.Is(err, services.ErrExchangeFileType):
+		eh.failure(c, http.StatusBadRequest, err.Error())
+	case errors.As(err, &maxBytesError):
+		eh.failure(c, http.StatusRequestEntityTooLarge, "file is larger than file_limit")
+	default:
+		eh.log.Error(err)
+		eh.failure(c, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -1,25 +1,45 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	"marketplace-api/internal/storage"
+	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"strings"
 )
 
 type Handlers struct {
-	AuthHandler        *AuthHandler
-	DistributorHandler *DistributorHandler
-	StoreHandler       *StoreHandler
-	AdminHandler       *AdminHandler
+	AuthHandler         *AuthHandler
+	DistributorHandler  *DistributorHandler
+	StoreHandler        *StoreHandler
+	AdminHandler        *AdminHandler
+	ExchangeHandler     *ExchangeHandler
+	StreamHandler       *StreamHandler
+	NotificationHandler *NotificationHandler
+	MessageHandler      *MessageHandler
+	imageService        *services.ImageService
+	blobStore           storage.BlobStore
 }
 
-func NewHandlers(authHandler *AuthHandler, distributorHandler *DistributorHandler, storeHandler *StoreHandler, adminHandler *AdminHandler) *Handlers {
-	return &Handlers{AuthHandler: authHandler, DistributorHandler: distributorHandler, StoreHandler: storeHandler, AdminHandler: adminHandler}
+func NewHandlers(authHandler *AuthHandler, distributorHandler *DistributorHandler, storeHandler *StoreHandler, adminHandler *AdminHandler, exchangeHandler *ExchangeHandler, streamHandler *StreamHandler, notificationHandler *NotificationHandler, messageHandler *MessageHandler, imageService *services.ImageService, blobStore storage.BlobStore) *Handlers {
+	return &Handlers{AuthHandler: authHandler, DistributorHandler: distributorHandler, StoreHandler: storeHandler, AdminHandler: adminHandler, ExchangeHandler: exchangeHandler, StreamHandler: streamHandler, NotificationHandler: notificationHandler, MessageHandler: messageHandler, imageService: imageService, blobStore: blobStore}
 }
 
+// UploadImage godoc
+// @Summary      Upload an image
+// @Description  Accepts JPEG, PNG, GIF and WebP images up to IMAGE_MAX_SIZE bytes and stores thumbnail, medium and large variants in WebP and JPEG. image_url is the large JPEG.
+// @Tags         upload
+// @Security     BearerToken
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        image  formData  file  true  "Image"
+// @Success      200  {string}  OK
+// @Failure      413  {string}  Request Entity Too Large
+// @Failure      415  {string}  Unsupported Media Type
+// @Router       /upload/image [post]
 func (h *Handlers) UploadImage(c *gin.Context) {
 	file, err := c.FormFile("image")
 	if err != nil {
@@ -29,29 +49,28 @@ func (h *Handlers) UploadImage(c *gin.Context) {
 		return
 	}
 
-	uniqueId := uuid.New()
-
-	filename := strings.Replace(uniqueId.String(), "-", "", -1)
-	fileExt := filepath.Ext(file.Filename)
-	image := fmt.Sprintf("%s%s", filename, fileExt)
-
-	err = c.SaveUploadedFile(file, fmt.Sprintf("./images/%s", image))
-
+	upload, err := h.saveUpload(c, file)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"message": "Unable to save the file",
-		})
+		uploadError(c, err)
 		return
 	}
-
-	imageUrl := fmt.Sprintf("http://localhost:4000/api/images/%s", image)
-	c.JSON(http.StatusOK, gin.H{"image_url": imageUrl})
+	c.JSON(http.StatusOK, gin.H{"image_url": upload.URL, "image": models.NewImageVariants(upload.URL)})
 }
 
+// UploadImages godoc
+// @Summary      Upload images
+// @Tags         upload
+// @Security     BearerToken
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        images[]  formData  file  true  "Images"
+// @Success      200  {string}  OK
+// @Failure      413  {string}  Request Entity Too Large
+// @Failure      415  {string}  Unsupported Media Type
+// @Router       /upload/images [post]
 func (h *Handlers) UploadImages(c *gin.Context) {
 	form, _ := c.MultipartForm()
-	files := form.File["images[]"]
-	if len(files) == 0 {
+	if form == nil || len(form.File["images[]"]) == 0 {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 			"message": "No file is received",
 		})
@@ -59,24 +78,57 @@ func (h *Handlers) UploadImages(c *gin.Context) {
 	}
 
 	var ImageUrls []string
-	for _, file := range files {
-		uniqueId := uuid.New()
-
-		filename := strings.Replace(uniqueId.String(), "-", "", -1)
-		fileExt := filepath.Ext(file.Filename)
-		image := fmt.Sprintf("%s%s", filename, fileExt)
-
-		err := c.SaveUploadedFile(file, fmt.Sprintf("./images/%s", image))
-
+	var images []models.ImageVariants
+	for _, file := range form.File["images[]"] {
+		upload, err := h.saveUpload(c, file)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"message": "Unable to save the file",
-			})
+			uploadError(c, err)
 			return
 		}
-		imageUrl := fmt.Sprintf("http://localhost:4000/api/images/%s", image)
-		ImageUrls = append(ImageUrls, imageUrl)
+		ImageUrls = append(ImageUrls, upload.URL)
+		images = append(images, models.NewImageVariants(upload.URL))
 	}
 
-	c.JSON(http.StatusOK, gin.H{"image_urls": ImageUrls})
+	c.JSON(http.StatusOK, gin.H{"image_urls": ImageUrls, "images": images})
+}
+
+// ServeImage redirects requests for private images to a signed URL of the
+// blob store.
+func (h *Handlers) ServeImage(c *gin.Context) {
+	signer, ok := h.blobStore.(storage.Signer)
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !ok || key == "" {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+	signedURL, err := signer.SignedURL(key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=60")
+	c.Redirect(http.StatusFound, signedURL)
+}
+
+// saveUpload stores the variants of the uploaded image.
+func (h *Handlers) saveUpload(c *gin.Context, file *multipart.FileHeader) (*models.Upload, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return h.imageService.Upload(c.Request.Context(), c.GetInt64("user_id"), src)
+}
+
+func uploadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrImageTooLarge):
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"message": err.Error()})
+	case errors.Is(err, services.ErrImageUnsupported):
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"message": err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "Unable to save the file",
+		})
+	}
 }
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ListInvoices returns the distributor's invoices, optionally of one store
+// or payment status.
+func (dh *DistributorHandler) ListInvoices(c *gin.Context) {
+	listInvoices(c, dh.invoiceService, "distributor", "store_id")
+}
+
+func (dh *DistributorHandler) GetInvoice(c *gin.Context) {
+	getInvoice(c, dh.invoiceService, "distributor")
+}
+
+// CreateInvoice godoc
+// @Summary      Invoice orders together
+// @Description  Bills orders of one store that have no invoice yet on a new invoice. Orders placed at checkout are invoiced automatically, one invoice per distributor.
+// @Tags         distributor
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        invoice body models.InvoiceInput true "Orders"
+// @Success      201  {object}  models.Invoice
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /distributor/invoices [post]
+func (dh *DistributorHandler) CreateInvoice(c *gin.Context) {
+	var input models.InvoiceInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidateInvoice(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	invoice, err := dh.invoiceService.IssueInvoice(c.GetInt64("user_id"), &input)
+	if err != nil {
+		invoiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"invoice": invoice})
+}
+
+// RecordPayment godoc
+// @Summary      Record a payment or a refund
+// @Description  Adds a payment or a refund to the invoice. Payments may exceed the balance, which then turns negative; refunds may not exceed the amount paid.
+// @Tags         distributor
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "Invoice ID"
+// @Param        payment body models.PaymentInput true "Payment"
+// @Success      201  {object}  models.Invoice
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /distributor/invoices/{id}/payments [post]
+func (dh *DistributorHandler) RecordPayment(c *gin.Context) {
+	invoiceID, ok := invoiceParam(c)
+	if !ok {
+		return
+	}
+	var input models.PaymentInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidatePayment(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	distributorID := c.GetInt64("user_id")
+	invoice, err := dh.invoiceService.RecordPayment(distributorID, invoiceID, distributorID, &input)
+	if err != nil {
+		invoiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"invoice": invoice})
+}
+
+// ListInvoices returns the store's invoices, optionally of one distributor
+// or payment status.
+func (sh *StoreHandler) ListInvoices(c *gin.Context) {
+	listInvoices(c, sh.invoiceService, "store", "distributor_id")
+}
+
+func (sh *StoreHandler) GetInvoice(c *gin.Context) {
+	getInvoice(c, sh.invoiceService, "store")
+}
+
+func listInvoices(c *gin.Context, invoiceService *services.InvoiceService, role, counterparty string) {
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	counterpartyID := validator.ReadInt(qs, counterparty, 0, v)
+	paymentStatus := validator.ReadString(qs, "payment_status", "")
+	var filters models.Filters
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-issued_at")
+	filters.SortSafelist = []string{"issued_at", "due_at", "amount", "-issued_at", "-due_at", "-amount"}
+
+	v.Check(paymentStatus == "" || validator.In(paymentStatus, models.PaymentStatuses...), "payment_status", "must be one of "+strings.Join(models.PaymentStatuses, ", "))
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	invoices, metadata, err := invoiceService.GetInvoices(c.GetInt64("user_id"), role, int64(counterpartyID), paymentStatus, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices, "metadata": metadata})
+}
+
+func getInvoice(c *gin.Context, invoiceService *services.InvoiceService, role string) {
+	invoiceID, ok := invoiceParam(c)
+	if !ok {
+		return
+	}
+	invoice, err := invoiceService.GetInvoice(c.GetInt64("user_id"), invoiceID, role)
+	if err != nil {
+		invoiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invoice": invoice})
+}
+
+// paymentStatusQuery reads the payment_status filter of order listings.
+func paymentStatusQuery(c *gin.Context) (string, bool) {
+	paymentStatus := c.Query("payment_status")
+	v := validator.New()
+	v.Check(paymentStatus == "" || validator.In(paymentStatus, models.PaymentStatuses...), "payment_status", "must be one of "+strings.Join(models.PaymentStatuses, ", "))
+	if !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return "", false
+	}
+	return paymentStatus, true
+}
+
+// orderNumberQuery reads the number filter of order listings, which matches
+// orders whose number contains it.
+func orderNumberQuery(c *gin.Context) (string, bool) {
+	number := strings.TrimSpace(c.Query("number"))
+	v := validator.New()
+	v.Check(number == "" || validator.Matches(number, models.OrderNumberRX), "number", "must contain only letters, digits and dashes")
+	if !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return "", false
+	}
+	return number, true
+}
+
+func invoiceParam(c *gin.Context) (int64, bool) {
+	invoiceID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || invoiceID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return 0, false
+	}
+	return invoiceID, true
+}
+
+func invoiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+	case errors.Is(err, services.ErrInvoiceOrders), errors.Is(err, services.ErrInvoiceCancelled), errors.Is(err, services.ErrRefundTooLarge):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"marketplace-api/internal/services"
+	"marketplace-api/internal/storage"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+)
+
+// MessageHandler serves the message threads between stores and distributors.
+type MessageHandler struct {
+	messageService *services.MessageService
+	blobStore      storage.BlobStore
+}
+
+func NewMessageHandler(messageService *services.MessageService, blobStore storage.BlobStore) *MessageHandler {
+	return &MessageHandler{messageService: messageService, blobStore: blobStore}
+}
+
+// ListThreads godoc
+// @Summary      List message threads
+// @Description  Returns the threads of the store or distributor, most recently active first, with their unread counts and the total number of unread messages
+// @Tags         messages
+// @Security     BearerToken
+// @Produce      json
+// @Param        order_id    query  int  false  "Order ID"
+// @Param        product_id  query  int  false  "Product ID"
+// @Param        page        query  int  false  "Page"
+// @Param        page_size   query  int  false  "Page size"
+// @Success      200  {array}   models.MessageThread
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /threads [get]
+func (mh *MessageHandler) ListThreads(c *gin.Context) {
+	user, ok := messagingUser(c)
+	if !ok {
+		return
+	}
+	query, filters, ok := readThreadQuery(c)
+	if !ok {
+		return
+	}
+	query.ReaderID = user.ID
+	if user.Role == models.RoleStore {
+		query.StoreID = user.ID
+	} else {
+		query.DistributorID = user.ID
+	}
+	threads, metadata, err := mh.messageService.GetThreads(query, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	unread, err := mh.messageService.CountUnread(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"threads": threads, "unread": unread, "metadata": metadata})
+}
+
+// CreateThread godoc
+// @Summary      Start a message thread
+// @Description  Starts a thread with its first message. Stores give distributor_id and distributors store_id; with order_id both come from the order. Attachments are URLs returned by the upload endpoints.
+// @Tags         messages
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        thread body models.ThreadInput true "Thread"
+// @Success      201  {object}  models.MessageThread
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /threads [post]
+func (mh *MessageHandler) CreateThread(c *gin.Context) {
+	user, ok := messagingUser(c)
+	if !ok {
+		return
+	}
+	var input models.ThreadInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	message := &models.Message{SenderID: user.ID, SenderRole: user.Role, Body: input.Body, Attachments: input.Attachments}
+	v := validator.New()
+	models.ValidateThread(v, &input)
+	if models.ValidateMessage(v, message, mh.uploaded); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	thread, err := mh.messageService.NewThread(user.Role, user.ID, &input)
+	if err != nil {
+		if errors.Is(err, services.ErrThreadOrder) || errors.Is(err, services.ErrThreadProduct) || errors.Is(err, services.ErrThreadParticipant) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := mh.messageService.StartThread(thread, message); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	thread.Messages = []models.Message{*message}
+	c.JSON(http.StatusCreated, gin.H{"thread": thread})
+}
+
+// GetThread godoc
+// @Summary      Get a message thread
+// @Description  Returns the thread and its messages, oldest first. Reading does not set read receipts; use the read endpoint.
+// @Tags         messages
+// @Security     BearerToken
+// @Produce      json
+// @Param        id   path  int  true  "Thread ID"
+// @Success      200  {object}  models.MessageThread
+// @Failure      404  {string}  Not found
+// @Router       /threads/{id} [get]
+func (mh *MessageHandler) GetThread(c *gin.Context) {
+	thread, ok := mh.ownThread(c)
+	if !ok {
+		return
+	}
+	messages, err := mh.messageService.GetMessages(thread.ID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	thread.Messages = messages
+	c.JSON(http.StatusOK, gin.H{"thread": thread})
+}
+
+// PostMessage godoc
+// @Summary      Send a message
+// @Tags         messages
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        id       path  int                  true  "Thread ID"
+// @Param        message  body  models.MessageInput  true  "Message"
+// @Success      201  {object}  models.Message
+// @Failure      409  {string}  Locked
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /threads/{id}/messages [post]
+func (mh *MessageHandler) PostMessage(c *gin.Context) {
+	thread, ok := mh.ownThread(c)
+	if !ok {
+		return
+	}
+	var input models.MessageInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	message := &models.Message{SenderID: user.ID, SenderRole: user.Role, Body: input.Body, Attachments: input.Attachments}
+	v := validator.New()
+	if models.ValidateMessage(v, message, mh.uploaded); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if err := mh.messageService.PostMessage(thread, message); err != nil {
+		if errors.Is(err, services.ErrThreadLocked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": message})
+}
+
+// MarkThreadRead godoc
+// @Summary      Mark a thread as read
+// @Description  Sets the read receipts of the messages received in the thread
+// @Tags         messages
+// @Security     BearerToken
+// @Produce      json
+// @Param        id   path  int  true  "Thread ID"
+// @Success      200  {string}  OK
+// @Failure      404  {string}  Not found
+// @Router       /threads/{id}/read [put]
+func (mh *MessageHandler) MarkThreadRead(c *gin.Context) {
+	thread, ok := mh.ownThread(c)
+	if !ok {
+		return
+	}
+	userID := c.GetInt64("user_id")
+	marked, err := mh.messageService.MarkRead(thread.ID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	unread, err := mh.messageService.CountUnread(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"marked": marked, "unread": unread})
+}
+
+// ownThread loads the thread from the id parameter and writes the error
+// response if the current user does not take part in it.
+func (mh *MessageHandler) ownThread(c *gin.Context) (*models.MessageThread, bool) {
+	user, ok := messagingUser(c)
+	if !ok {
+		return nil, false
+	}
+	threadID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || threadID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return nil, false
+	}
+	thread, err := mh.messageService.GetThreadByID(threadID, user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !thread.Participant(user.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return nil, false
+	}
+	return thread, true
+}
+
+// uploaded reports whether the URL is a file of the blob store.
+func (mh *MessageHandler) uploaded(url string) bool {
+	_, ok := mh.blobStore.Key(url)
+	return ok
+}
+
+// messagingUser returns the current user if they are a store or distributor.
+func messagingUser(c *gin.Context) (models.User, bool) {
+	user := c.MustGet("user").(models.User)
+	if user.Role != models.RoleStore && user.Role != models.RoleDistributor {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not Permitted"})
+		return user, false
+	}
+	return user, true
+}
+
+func readThreadQuery(c *gin.Context) (repository.ThreadQuery, models.Filters, bool) {
+	var query repository.ThreadQuery
+	var filters models.Filters
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	query.OrderID = int64(validator.ReadInt(qs, "order_id", 0, v))
+	query.ProductID = int64(validator.ReadInt(qs, "product_id", 0, v))
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-last_message_at")
+	filters.SortSafelist = []string{"last_message_at", "created_at", "-last_message_at", "-created_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return query, filters, false
+	}
+	return query, filters, true
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+)
+
+// NotificationHandler serves the notification center of stores and
+// distributors.
+type NotificationHandler struct {
+	notificationService *services.NotificationService
+}
+
+func NewNotificationHandler(notificationService *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{notificationService: notificationService}
+}
+
+// ListNotifications godoc
+// @Summary      List notifications
+// @Description  Returns the in-app notifications of the user, newest first, and the number of unread ones
+// @Tags         notifications
+// @Security     BearerToken
+// @Produce      json
+// @Param        unread     query  bool    false  "Only unread notifications"
+// @Param        page       query  int     false  "Page"
+// @Param        page_size  query  int     false  "Page size"
+// @Param        sort       query  string  false  "created_at or -created_at"
+// @Success      200  {array}   models.Notification
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /notifications [get]
+func (nh *NotificationHandler) ListNotifications(c *gin.Context) {
+	var filters models.Filters
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	unread := validator.ReadString(qs, "unread", "false")
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	v.Check(validator.In(unread, "true", "false"), "unread", "must be true or false")
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	userID := c.GetInt64("user_id")
+	notifications, metadata, err := nh.notificationService.GetNotifications(userID, unread == "true", filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	unreadCount, err := nh.notificationService.CountUnread(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unreadCount, "metadata": metadata})
+}
+
+// MarkNotificationRead godoc
+// @Summary      Mark a notification as read
+// @Tags         notifications
+// @Security     BearerToken
+// @Produce      json
+// @Param        id   path  int  true  "Notification ID"
+// @Success      200  {string}  OK
+// @Failure      404  {string}  Not found
+// @Router       /notifications/{id}/read [put]
+func (nh *NotificationHandler) MarkNotificationRead(c *gin.Context) {
+	notificationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || notificationID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	userID := c.GetInt64("user_id")
+	err = nh.notificationService.MarkRead(userID, notificationID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	unreadCount, err := nh.notificationService.CountUnread(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": unreadCount})
+}
+
+// MarkAllNotificationsRead godoc
+// @Summary      Mark all notifications as read
+// @Tags         notifications
+// @Security     BearerToken
+// @Produce      json
+// @Success      200  {string}  OK
+// @Router       /notifications/read [put]
+func (nh *NotificationHandler) MarkAllNotificationsRead(c *gin.Context) {
+	marked, err := nh.notificationService.MarkAllRead(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"marked": marked, "unread": 0})
+}
+
+// GetNotificationPreferences godoc
+// @Summary      Get notification preferences
+// @Description  Returns the in-app, email and SMS choice of the user for every event of their role
+// @Tags         notifications
+// @Security     BearerToken
+// @Produce      json
+// @Success      200  {array}  models.NotificationPreference
+// @Router       /notifications/preferences [get]
+func (nh *NotificationHandler) GetNotificationPreferences(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	preferences, err := nh.notificationService.GetPreferences(user.ID, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
+
+// UpdateNotificationPreferences godoc
+// @Summary      Update notification preferences
+// @Description  Sets the channels of the given events; other events keep their preference
+// @Tags         notifications
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        preferences body models.NotificationPreferencesInput true "Preferences"
+// @Success      200  {array}   models.NotificationPreference
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /notifications/preferences [put]
+func (nh *NotificationHandler) UpdateNotificationPreferences(c *gin.Context) {
+	var input models.NotificationPreferencesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	v := validator.New()
+	if models.ValidateNotificationPreferences(v, user.Role, input.Preferences); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if err := nh.notificationService.SavePreferences(user.ID, input.Preferences); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	preferences, err := nh.notificationService.GetPreferences(user.ID, user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	"marketplace-api/internal/spreadsheet"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+)
+
+func (ah *AdminHandler) ListCommissionRules(c *gin.Context) {
+	rules, err := ah.payoutService.GetCommissionRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"commission_rules": rules})
+}
+
+// CreateCommissionRule godoc
+// @Summary      Create a commission rule
+// @Description  Sets the commission, a percentage of the order line plus a fixed fee, on orders completed from now on. Leave distributor_id and category empty for the global rule; the most specific active rule applies.
+// @Tags         admin
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        rule body models.CommissionRuleInput true "Rule"
+// @Success      201  {object}  models.CommissionRule
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /admin/commission-rules [post]
+func (ah *AdminHandler) CreateCommissionRule(c *gin.Context) {
+	ah.saveCommissionRule(c, &models.CommissionRule{}, http.StatusCreated)
+}
+
+func (ah *AdminHandler) UpdateCommissionRule(c *gin.Context) {
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || ruleID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	rule, err := ah.payoutService.GetCommissionRuleByID(ruleID)
+	if err != nil {
+		payoutError(c, err)
+		return
+	}
+	ah.saveCommissionRule(c, rule, http.StatusOK)
+}
+
+func (ah *AdminHandler) saveCommissionRule(c *gin.Context, rule *models.CommissionRule, status int) {
+	var input models.CommissionRuleInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	rule.DistributorID = input.DistributorID
+	rule.Category = input.Category
+	rule.Percent = input.Percent
+	rule.FixedFee = input.FixedFee
+	rule.Active = input.Active == nil || *input.Active
+
+	v := validator.New()
+	if models.ValidateCommissionRule(v, rule); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if rule.DistributorID != nil && !ah.distributorExists(c, *rule.DistributorID) {
+		return
+	}
+	if err := ah.payoutService.SaveCommissionRule(rule); err != nil {
+		payoutError(c, err)
+		return
+	}
+	c.JSON(status, gin.H{"commission_rule": rule})
+}
+
+func (ah *AdminHandler) DeleteCommissionRule(c *gin.Context) {
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || ruleID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	if err := ah.payoutService.DeleteCommissionRule(ruleID); err != nil {
+		payoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "commission rule deleted successfully"})
+}
+
+// CreatePayoutAdjustment adds a credit, or a debit with a negative amount, to
+// the distributor's next payout statement.
+func (ah *AdminHandler) CreatePayoutAdjustment(c *gin.Context) {
+	var input models.PayoutAdjustmentInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidatePayoutAdjustment(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if !ah.distributorExists(c, input.DistributorID) {
+		return
+	}
+	line, err := ah.payoutService.AddAdjustment(&input, c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"payout_line": line})
+}
+
+func (ah *AdminHandler) ListStatements(c *gin.Context) {
+	listStatements(c, ah.payoutService, -1)
+}
+
+func (ah *AdminHandler) GetStatement(c *gin.Context) {
+	getStatement(c, ah.payoutService, 0)
+}
+
+func (ah *AdminHandler) ExportStatement(c *gin.Context) {
+	exportStatement(c, ah.payoutService, 0)
+}
+
+// MarkStatementPaid records the transfer of the statement's net payout.
+func (ah *AdminHandler) MarkStatementPaid(c *gin.Context) {
+	statementID, ok := statementParam(c)
+	if !ok {
+		return
+	}
+	var input models.StatementPaidInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidateStatementPaid(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	statement, err := ah.payoutService.MarkStatementPaid(statementID, c.GetInt64("user_id"), input.Reference)
+	if err != nil {
+		payoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"statement": statement})
+}
+
+func (ah *AdminHandler) distributorExists(c *gin.Context, distributorID int64) bool {
+	_, err := ah.distributorService.GetDistributorByID(distributorID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"distributor_id": "distributor not found"}})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// GetPendingPayout returns the payout lines not on a statement yet with their
+// totals so far.
+func (dh *DistributorHandler) GetPendingPayout(c *gin.Context) {
+	lines, totals, err := dh.payoutService.GetPendingLines(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"lines": lines, "totals": totals})
+}
+
+func (dh *DistributorHandler) ListStatements(c *gin.Context) {
+	listStatements(c, dh.payoutService, c.GetInt64("user_id"))
+}
+
+func (dh *DistributorHandler) GetStatement(c *gin.Context) {
+	getStatement(c, dh.payoutService, c.GetInt64("user_id"))
+}
+
+func (dh *DistributorHandler) ExportStatement(c *gin.Context) {
+	exportStatement(c, dh.payoutService, c.GetInt64("user_id"))
+}
+
+// listStatements lists the statements of the distributor. Admins pass -1 to
+// list every distributor's, optionally filtered by distributor_id.
+func listStatements(c *gin.Context, payoutService *services.PayoutService, distributorID int64) {
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	if distributorID < 0 {
+		distributorID = int64(validator.ReadInt(qs, "distributor_id", 0, v))
+	}
+	status := validator.ReadString(qs, "status", "")
+	var filters models.Filters
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-period_end")
+	filters.SortSafelist = []string{"period_end", "net_payout", "-period_end", "-net_payout"}
+
+	v.Check(status == "" || validator.In(status, models.StatementStatusIssued, models.StatementStatusPaid), "status", "must be issued or paid")
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	statements, metadata, err := payoutService.GetStatements(distributorID, status, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"statements": statements, "metadata": metadata})
+}
+
+func getStatement(c *gin.Context, payoutService *services.PayoutService, distributorID int64) {
+	statementID, ok := statementParam(c)
+	if !ok {
+		return
+	}
+	statement, err := payoutService.GetStatement(distributorID, statementID)
+	if err != nil {
+		payoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"statement": statement})
+}
+
+func exportStatement(c *gin.Context, payoutService *services.PayoutService, distributorID int64) {
+	statementID, ok := statementParam(c)
+	if !ok {
+		return
+	}
+	format := validator.ReadString(c.Request.URL.Query(), "format", models.ImportFormatCSV)
+	if !validator.In(format, models.ImportFormatCSV, models.ImportFormatXLSX) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"format": "must be csv or xlsx"}})
+		return
+	}
+	statement, err := payoutService.GetStatement(distributorID, statementID)
+	if err != nil {
+		payoutError(c, err)
+		return
+	}
+	records := payoutService.ExportStatement(statement)
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%d.%s"`, statement.ID, format))
+	if format == models.ImportFormatXLSX {
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		err = spreadsheet.WriteXLSX(c.Writer, "Statement", records, 5, 6, 7, 8, 9, 10)
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		err = spreadsheet.WriteCSV(c.Writer, records)
+	}
+	if err != nil {
+		_ = c.Error(err)
+	}
+}
+
+func statementParam(c *gin.Context) (int64, bool) {
+	statementID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || statementID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return 0, false
+	}
+	return statementID, true
+}
+
+func payoutError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+	case errors.Is(err, services.ErrCommissionRuleExists), errors.Is(err, services.ErrStatementPaid):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (dh *DistributorHandler) ListPromotions(c *gin.Context) {
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	var filters models.Filters
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "starts_at", "ends_at", "name", "-created_at", "-starts_at", "-ends_at", "-name"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	promotions, metadata, err := dh.promotionService.GetPromotions(c.GetInt64("user_id"), filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promotions": promotions, "metadata": metadata})
+}
+
+// CreatePromotion godoc
+// @Summary      Create a promotion
+// @Description  Creates a percentage, fixed amount or buy-X-get-Y promotion on the distributor's products, optionally limited to products, categories, stores and cities, a date window, a number of uses and a coupon code. Each cart line gets the promotion that takes the most off it.
+// @Tags         distributor
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        promotion body models.PromotionInput true "Promotion"
+// @Success      201  {object}  models.Promotion
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /distributor/promotions [post]
+func (dh *DistributorHandler) CreatePromotion(c *gin.Context) {
+	dh.savePromotion(c, &models.Promotion{DistributorID: c.GetInt64("user_id")}, http.StatusCreated)
+}
+
+// GetPromotion returns the promotion with a report of the orders it was
+// applied to.
+func (dh *DistributorHandler) GetPromotion(c *gin.Context) {
+	promotionID, ok := promotionParam(c)
+	if !ok {
+		return
+	}
+	promotion, report, err := dh.promotionService.GetPromotion(c.GetInt64("user_id"), promotionID)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promotion": promotion, "report": report})
+}
+
+func (dh *DistributorHandler) UpdatePromotion(c *gin.Context) {
+	promotionID, ok := promotionParam(c)
+	if !ok {
+		return
+	}
+	promotion, _, err := dh.promotionService.GetPromotion(c.GetInt64("user_id"), promotionID)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+	dh.savePromotion(c, promotion, http.StatusOK)
+}
+
+func (dh *DistributorHandler) savePromotion(c *gin.Context, promotion *models.Promotion, status int) {
+	var input models.PromotionInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	promotion.Name = strings.TrimSpace(input.Name)
+	promotion.Kind = input.Kind
+	promotion.Value = input.Value
+	promotion.BuyQuantity = input.BuyQuantity
+	promotion.FreeQuantity = input.FreeQuantity
+	promotion.ProductIDs = input.ProductIDs
+	promotion.Categories = input.Categories
+	promotion.StoreIDs = input.StoreIDs
+	promotion.Cities = input.Cities
+	promotion.StartsAt = input.StartsAt
+	promotion.EndsAt = input.EndsAt
+	promotion.UsageLimit = input.UsageLimit
+	promotion.PerStoreLimit = input.PerStoreLimit
+	promotion.CouponCode = models.NormaliseCouponCode(input.CouponCode)
+	promotion.Active = input.Active == nil || *input.Active
+
+	v := validator.New()
+	if models.ValidatePromotion(v, promotion); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if err := dh.promotionService.SavePromotion(promotion); err != nil {
+		promotionError(c, err)
+		return
+	}
+	c.JSON(status, gin.H{"promotion": promotion})
+}
+
+// DeletePromotion ends the promotion. Orders it was applied to keep their
+// discount and the promotion's name.
+func (dh *DistributorHandler) DeletePromotion(c *gin.Context) {
+	promotionID, ok := promotionParam(c)
+	if !ok {
+		return
+	}
+	if err := dh.promotionService.DeletePromotion(c.GetInt64("user_id"), promotionID); err != nil {
+		promotionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "promotion deleted successfully"})
+}
+
+// ApplyCoupon adds a coupon code to the store's cart, unlocking the
+// promotions that require it.
+func (sh *StoreHandler) ApplyCoupon(c *gin.Context) {
+	var input struct {
+		CouponCode string `json:"coupon_code"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	code := models.NormaliseCouponCode(input.CouponCode)
+	if code == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"coupon_code": "must be provided"}})
+		return
+	}
+	sh.setCouponCode(c, code)
+}
+
+func (sh *StoreHandler) RemoveCoupon(c *gin.Context) {
+	sh.setCouponCode(c, "")
+}
+
+func (sh *StoreHandler) setCouponCode(c *gin.Context, code string) {
+	err := sh.cartService.SetCouponCode(c.GetInt64("user_id"), code)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cart is empty"})
+		case errors.Is(err, services.ErrCouponNotFound):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"coupon_code": err.Error()}})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	sh.GetCart(c)
+}
+
+func promotionParam(c *gin.Context) (int64, bool) {
+	promotionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || promotionID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return 0, false
+	}
+	return promotionID, true
+}
+
+func promotionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+	case errors.Is(err, services.ErrPromotionProducts):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"product_ids": err.Error()}})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
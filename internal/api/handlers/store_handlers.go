@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
 	"marketplace-api/internal/services"
 	validator "marketplace-api/internal/util"
 	"math"
@@ -22,10 +23,14 @@ type StoreHandler struct {
 	distributorService *services.DistributorService
 	cartService        *services.CartService
 	orderService       *services.OrderService
+	deliveryService    *services.DeliveryService
+	invoiceService     *services.InvoiceService
+	creditService      *services.CreditService
+	documentService    *services.DocumentService
 }
 
-func NewStoreHandler(storeService *services.StoreService, productServices *services.ProductService, distributorService *services.DistributorService, cartService *services.CartService, orderService *services.OrderService) *StoreHandler {
-	return &StoreHandler{storeService: storeService, productServices: productServices, distributorService: distributorService, cartService: cartService, orderService: orderService}
+func NewStoreHandler(storeService *services.StoreService, productServices *services.ProductService, distributorService *services.DistributorService, cartService *services.CartService, orderService *services.OrderService, deliveryService *services.DeliveryService, invoiceService *services.InvoiceService, creditService *services.CreditService, documentService *services.DocumentService) *StoreHandler {
+	return &StoreHandler{storeService: storeService, productServices: productServices, distributorService: distributorService, cartService: cartService, orderService: orderService, deliveryService: deliveryService, invoiceService: invoiceService, creditService: creditService, documentService: documentService}
 }
 
 func (sh *StoreHandler) GetProfile(c *gin.Context) {
@@ -100,7 +105,7 @@ func (sh *StoreHandler) ListProducts(c *gin.Context) {
 	input.Filters.PageSize = pageSize
 	input.Filters.Sort = c.DefaultQuery("sort", "id")
 
-	input.Filters.SortSafelist = []string{"id", "product_name", "price", "created_at", "-id", "-product_name", "-price", "-created_at"}
+	input.Filters.SortSafelist = []string{"id", "product_name", "price", "created_at", "rating", "-id", "-product_name", "-price", "-created_at", "-rating"}
 
 	if models.ValidateFilters(v, input.Filters); !v.Valid() {
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
@@ -148,7 +153,7 @@ func (sh *StoreHandler) AddToCart(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if product.Stock < input.Quantity {
+	if product.Stock < input.Quantity && !product.AllowBackorder {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "not enough quantity in stock"})
 		return
 	}
@@ -242,14 +247,24 @@ func (sh *StoreHandler) CreateOrder(c *gin.Context) {
 	storeID := c.GetInt64("user_id")
 
 	var input struct {
-		City    string `json:"city"`
-		Address string `json:"address"`
+		AddressID     *int64                      `json:"address_id"`
+		DeliverySlots []models.DeliverySlotChoice `json:"delivery_slots"`
 	}
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
+	address, err := sh.storeService.GetCheckoutAddress(storeID, input.AddressID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delivery address not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	cart, err := sh.cartService.GetCart(storeID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -259,8 +274,12 @@ func (sh *StoreHandler) CreateOrder(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	err = sh.orderService.CreatOrder(cart, input.City, input.Address)
+	err = sh.orderService.CreatOrder(cart, address, input.DeliverySlots)
 	if err != nil {
+		if errors.Is(err, repository.ErrDeliverySlotFull) || errors.Is(err, services.ErrCreditLimitExceeded) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -272,6 +291,30 @@ func (sh *StoreHandler) CreateOrder(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "order created successfully"})
 }
 
+func (sh *StoreHandler) ListDeliveryWindows(c *gin.Context) {
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	distributorID := int64(validator.ReadInt(qs, "distributor_id", 0, v))
+	city := validator.ReadString(qs, "city", "")
+	days := validator.ReadInt(qs, "days", 14, v)
+
+	v.Check(distributorID > 0, "distributor_id", "must be provided")
+	v.Check(city != "", "city", "must be provided")
+	v.Check(days > 0 && days <= 60, "days", "must be between 1 and 60")
+	if !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	windows, err := sh.deliveryService.GetAvailableWindows(distributorID, city, time.Now(), days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivery_windows": windows})
+}
+
 func (sh *StoreHandler) CancelOrder(c *gin.Context) {
 	orderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || orderID < 0 {
@@ -292,21 +335,7 @@ func (sh *StoreHandler) CancelOrder(c *gin.Context) {
 		return
 	}
 
-	err = sh.orderService.ChangeOrderStatus(*order, models.StageStatusError)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err.Error())
-		return
-	}
-
-	product, err := sh.productServices.GetProductByID(order.ProductID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err.Error())
-		return
-	}
-
-	product.Stock += order.Quantity
-
-	err = sh.productServices.UpdateProduct(product)
+	err = sh.orderService.CancelOrder(*order)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, err.Error())
 		return
@@ -330,19 +359,26 @@ func (sh *StoreHandler) GetOrder(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	product, err := sh.productServices.GetProductByID(order.ProductID)
+	err = sh.productServices.AttachOrderProduct(order)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	order.Product = *product
 	order.TotalPrice = math.Round(order.TotalPrice*100) / 100
 	c.JSON(http.StatusOK, gin.H{"order": order})
 }
 
 func (sh *StoreHandler) ListOrders(c *gin.Context) {
 	storeID := c.GetInt64("user_id")
-	orders, err := sh.orderService.GetOrders(storeID, "store")
+	paymentStatus, ok := paymentStatusQuery(c)
+	if !ok {
+		return
+	}
+	number, ok := orderNumberQuery(c)
+	if !ok {
+		return
+	}
+	orders, err := sh.orderService.GetOrders(storeID, "store", paymentStatus, number)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, "order not found")
@@ -352,13 +388,11 @@ func (sh *StoreHandler) ListOrders(c *gin.Context) {
 		return
 	}
 	for i, order := range orders {
-		product, err := sh.productServices.GetProductByID(order.ProductID)
+		err := sh.productServices.AttachOrderProduct(&orders[i])
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, err.Error())
 			return
 		}
-		orders[i].Product = *product
-		order.Product = *product
 		orders[i].TotalPrice = math.Round(order.TotalPrice*100) / 100
 	}
 	c.JSON(http.StatusOK, gin.H{"orders": orders})
@@ -375,22 +409,27 @@ func (sh *StoreHandler) GetStatistics(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	var spentOverall float64
-	var spentInMonth float64
+	var spentOverall, spentOverallNet, taxOverall float64
+	var spentInMonth, spentInMonthNet, taxInMonth float64
 	for i, order := range orders {
 		spentOverall = spentOverall + order.TotalPrice
+		spentOverallNet = spentOverallNet + order.NetAmount
+		taxOverall = taxOverall + order.TaxAmount
 		if order.Timestamp.Year() == time.Now().Year() && time.Now().Month() == order.Timestamp.Month() {
 			spentInMonth = spentInMonth + order.TotalPrice
+			spentInMonthNet = spentInMonthNet + order.NetAmount
+			taxInMonth = taxInMonth + order.TaxAmount
 		}
-		product, err := sh.productServices.GetProductByID(order.ProductID)
+		err := sh.productServices.AttachOrderProduct(&orders[i])
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, err.Error())
 			return
 		}
-		orders[i].Product = *product
 	}
 
-	c.JSON(http.StatusOK, gin.H{"orders": orders, "spent_overall": spentOverall, "spent_in_month": spentInMonth})
+	c.JSON(http.StatusOK, gin.H{"orders": orders, "spent_overall": spentOverall, "spent_in_month": spentInMonth,
+		"spent_overall_net": models.RoundMoney(spentOverallNet), "tax_overall": models.RoundMoney(taxOverall),
+		"spent_in_month_net": models.RoundMoney(spentInMonthNet), "tax_in_month": models.RoundMoney(taxInMonth)})
 }
 
 func (sh *StoreHandler) CreateReview(c *gin.Context) {
@@ -401,21 +440,90 @@ func (sh *StoreHandler) CreateReview(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
+	v := validator.New()
+	if models.ValidateReview(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	review, err := sh.productServices.NewReview(storeID, &input)
+	if err == nil {
+		err = sh.productServices.CreatReview(review)
+	}
+	if err != nil {
+		reviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "review created successfully", "review": review})
+}
 
-	review := &models.Review{
-		DistributorId: input.DistributorId,
-		ProductId:     input.ProductId,
-		StoreId:       storeID,
-		Rating:        input.Rating,
-		Text:          input.Text,
+func (sh *StoreHandler) UpdateReview(c *gin.Context) {
+	storeID := c.GetInt64("user_id")
+	reviewId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || reviewId < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	var input models.ReviewUpdateInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidateReviewUpdate(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
 	}
 
-	err := sh.productServices.CreatReview(review)
+	review, err := sh.productServices.UpdateReview(storeID, reviewId, &input)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		reviewError(c, err)
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"message": "review created successfully"})
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
+
+func (sh *StoreHandler) ReportReview(c *gin.Context) {
+	reportReview(c, sh.productServices)
+}
+
+// reportReview reports a review for moderation on behalf of the current user.
+func reportReview(c *gin.Context, productService *services.ProductService) {
+	reviewId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || reviewId < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	var input models.ReviewFlagInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	v := validator.New()
+	if models.ValidateReviewFlag(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+	if err := productService.ReportReview(c.MustGet("user").(models.User), reviewId, &input); err != nil {
+		reviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "review reported"})
+}
+
+func reviewError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, services.ErrReviewOrder):
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+	case errors.Is(err, services.ErrReviewExists), errors.Is(err, services.ErrReviewReported):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrReviewNotReceived), errors.Is(err, services.ErrReviewProduct),
+		errors.Is(err, services.ErrReviewDistributor), errors.Is(err, services.ErrReviewLocked),
+		errors.Is(err, services.ErrReviewUnpublished), errors.Is(err, services.ErrReplyRejected):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
 }
 
 func (sh *StoreHandler) GetReview(c *gin.Context) {
@@ -443,15 +551,158 @@ func (sh *StoreHandler) GetReviewByProductId(c *gin.Context) {
 }
 
 func (sh *StoreHandler) DeleteReview(c *gin.Context) {
+	storeID := c.GetInt64("user_id")
 	reviewId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || reviewId < 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
 	}
 
-	err = sh.productServices.DeleteByReviewId(reviewId)
+	err = sh.productServices.DeleteByReviewId(storeID, reviewId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		reviewError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"message": "order successfully deleted."})
 }
+
+func (sh *StoreHandler) ListCities(c *gin.Context) {
+	cities, err := sh.storeService.GetServedCities()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cities": cities})
+}
+
+func (sh *StoreHandler) ListAddresses(c *gin.Context) {
+	addresses, err := sh.storeService.GetAddresses(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"addresses": addresses})
+}
+
+func (sh *StoreHandler) CreateAddress(c *gin.Context) {
+	var input models.StoreAddressInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	address := &models.StoreAddress{
+		StoreID:      c.GetInt64("user_id"),
+		Label:        input.Label,
+		City:         input.City,
+		Address:      input.Address,
+		ContactName:  input.ContactName,
+		ContactPhone: input.ContactPhone,
+		IsDefault:    input.IsDefault,
+	}
+
+	v := validator.New()
+	if models.ValidateStoreAddress(v, address); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err := sh.storeService.CreateAddress(address)
+	if err != nil {
+		if errors.Is(err, services.ErrCityNotServed) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"city": err.Error()}})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"address": address})
+}
+
+func (sh *StoreHandler) UpdateAddress(c *gin.Context) {
+	addressID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || addressID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+	var input models.StoreAddressInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	storeID := c.GetInt64("user_id")
+
+	_, err = sh.storeService.GetAddressByID(storeID, addressID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	address := &models.StoreAddress{
+		ID:           addressID,
+		StoreID:      storeID,
+		Label:        input.Label,
+		City:         input.City,
+		Address:      input.Address,
+		ContactName:  input.ContactName,
+		ContactPhone: input.ContactPhone,
+		IsDefault:    input.IsDefault,
+	}
+
+	v := validator.New()
+	if models.ValidateStoreAddress(v, address); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err = sh.storeService.UpdateAddress(address)
+	if err != nil {
+		if errors.Is(err, services.ErrCityNotServed) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"city": err.Error()}})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "address updated successfully"})
+}
+
+func (sh *StoreHandler) SetDefaultAddress(c *gin.Context) {
+	addressID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || addressID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	err = sh.storeService.SetDefaultAddress(c.GetInt64("user_id"), addressID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "default address changed successfully"})
+}
+
+func (sh *StoreHandler) DeleteAddress(c *gin.Context) {
+	addressID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || addressID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	err = sh.storeService.DeleteAddress(c.GetInt64("user_id"), addressID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "address deleted successfully"})
+}
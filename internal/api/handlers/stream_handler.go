@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/stream"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// streamHeartbeat keeps proxies from closing idle streams.
+	streamHeartbeat = 25 * time.Second
+	// streamReplayBatch is how many missed events are read at a time on resume.
+	streamReplayBatch = 500
+)
+
+// StreamHandler pushes order and review events to connected stores and
+// distributors as Server-Sent Events.
+type StreamHandler struct {
+	hub    *stream.Hub
+	outbox *events.Outbox
+	log    *logrus.Logger
+}
+
+func NewStreamHandler(hub *stream.Hub, outbox *events.Outbox, log *logrus.Logger) *StreamHandler {
+	return &StreamHandler{hub: hub, outbox: outbox, log: log}
+}
+
+// Stream godoc
+// @Summary      Event stream
+// @Description  Server-Sent Events stream of new orders, stage changes and cancellations for stores and distributors, and new reviews for distributors. Each message has the sequence number the event was dispatched with as its id and the event name as its type. Send Last-Event-ID (or last_event_id) to receive the events missed since then. Clients that cannot set headers may pass the JWT as access_token.
+// @Tags         stream
+// @Security     BearerToken
+// @Produce      text/event-stream
+// @Param        Last-Event-ID  header  int  false  "Id of the last received message"
+// @Param        last_event_id  query   int  false  "Id of the last received message"
+// @Success      200  {object}  events.Event
+// @Failure      403  {string}  Forbidden
+// @Router       /stream [get]
+func (sh *StreamHandler) Stream(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	if _, ok := stream.Events[user.Role]; !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not Permitted"})
+		return
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var afterSequence int64
+	if lastEventID != "" {
+		var err error
+		afterSequence, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || afterSequence < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	client := sh.hub.Subscribe(user.Role, user.ID)
+	defer sh.hub.Unsubscribe(client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	if lastEventID != "" {
+		var distributorID, storeID int64
+		if user.Role == models.RoleDistributor {
+			distributorID = user.ID
+		} else {
+			storeID = user.ID
+		}
+		for {
+			missed, err := sh.outbox.GetDispatchedEventsAfter(afterSequence, stream.Events[user.Role], distributorID, storeID, streamReplayBatch)
+			if err != nil {
+				sh.log.Errorf("failed to replay events: %s", err.Error())
+				return
+			}
+			for _, event := range missed {
+				afterSequence = event.Sequence
+				if !stream.Visible(event, user.Role, user.ID) {
+					continue
+				}
+				if err := writeStreamEvent(c, event); err != nil {
+					return
+				}
+			}
+			if len(missed) < streamReplayBatch {
+				break
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-client.Events:
+			if !ok {
+				return
+			}
+			// Events dispatched while replaying were sent already.
+			if event.Sequence <= afterSequence {
+				continue
+			}
+			if err := writeStreamEvent(c, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+func writeStreamEvent(c *gin.Context, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Name, data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"marketplace-api/internal/services"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+)
+
+type warehouseInput struct {
+	Name    string   `json:"name"`
+	City    string   `json:"city"`
+	Address string   `json:"address"`
+	Cities  []string `json:"cities"`
+	Active  *bool    `json:"active"`
+}
+
+func (in warehouseInput) warehouse(distributorID int64) *models.Warehouse {
+	warehouse := &models.Warehouse{
+		DistributorID: distributorID,
+		Name:          in.Name,
+		City:          in.City,
+		Address:       in.Address,
+		Cities:        in.Cities,
+		Active:        true,
+	}
+	if in.Active != nil {
+		warehouse.Active = *in.Active
+	}
+	return warehouse
+}
+
+func (dh *DistributorHandler) ListWarehouses(c *gin.Context) {
+	warehouses, err := dh.inventoryService.GetWarehouses(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"warehouses": warehouses})
+}
+
+func (dh *DistributorHandler) CreateWarehouse(c *gin.Context) {
+	var input warehouseInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	warehouse := input.warehouse(c.GetInt64("user_id"))
+
+	v := validator.New()
+	if models.ValidateWarehouse(v, warehouse); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err := dh.inventoryService.CreateWarehouse(warehouse)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"warehouse": warehouse})
+}
+
+func (dh *DistributorHandler) UpdateWarehouse(c *gin.Context) {
+	warehouse, ok := dh.ownWarehouse(c)
+	if !ok {
+		return
+	}
+	var input warehouseInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	updated := input.warehouse(warehouse.DistributorID)
+	updated.ID = warehouse.ID
+
+	v := validator.New()
+	if models.ValidateWarehouse(v, updated); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err := dh.inventoryService.UpdateWarehouse(updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"warehouse": updated})
+}
+
+func (dh *DistributorHandler) DeleteWarehouse(c *gin.Context) {
+	warehouse, ok := dh.ownWarehouse(c)
+	if !ok {
+		return
+	}
+
+	err := dh.inventoryService.DeleteWarehouse(warehouse.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrWarehouseInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "warehouse deleted successfully"})
+}
+
+func (dh *DistributorHandler) GetWarehouseStock(c *gin.Context) {
+	warehouse, ok := dh.ownWarehouse(c)
+	if !ok {
+		return
+	}
+
+	levels, err := dh.inventoryService.GetWarehouseStockLevels(warehouse.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"warehouse": warehouse, "stock": levels})
+}
+
+func (dh *DistributorHandler) GetProductStock(c *gin.Context) {
+	productId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productId < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	product, err := dh.productServices.GetProductByID(productId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if product.DistributorID != c.GetInt64("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return
+	}
+
+	levels, err := dh.inventoryService.GetStockLevels(productId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stock": levels, "available": product.Stock})
+}
+
+func (dh *DistributorHandler) CreateStockMovement(c *gin.Context) {
+	var input models.StockMovementInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateStockMovementInput(v, &input); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	distributorID := c.GetInt64("user_id")
+	warehouse, err := dh.inventoryService.GetWarehouseByID(input.WarehouseID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if warehouse == nil || warehouse.DistributorID != distributorID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"warehouse_id": "warehouse not found"}})
+		return
+	}
+	product, err := dh.productServices.GetProductByID(input.ProductID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if product == nil || product.DistributorID != distributorID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"product_id": "product not found"}})
+		return
+	}
+
+	movement, err := dh.inventoryService.RecordMovement(&input, distributorID)
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientStock) {
+			c.JSON(http.StatusConflict, gin.H{"error": "stock cannot be lower than the quantity reserved by orders"})
+			return
+		}
+		if errors.Is(err, services.ErrReturnOrder) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"order_id": err.Error()}})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if movement.OnHandDelta > 0 {
+		err = dh.orderService.ReleaseBackorders(product.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusCreated, gin.H{"movement": movement})
+}
+
+func (dh *DistributorHandler) ListStockMovements(c *gin.Context) {
+	var filters models.Filters
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	productID := int64(validator.ReadInt(qs, "product_id", 0, v))
+	warehouseID := int64(validator.ReadInt(qs, "warehouse_id", 0, v))
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	movements, metadata, err := dh.inventoryService.GetMovements(c.GetInt64("user_id"), productID, warehouseID, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"movements": movements, "metadata": metadata})
+}
+
+// ownWarehouse loads the warehouse from the id parameter and writes the error
+// response if it does not belong to the current distributor.
+func (dh *DistributorHandler) ownWarehouse(c *gin.Context) (*models.Warehouse, bool) {
+	warehouseID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || warehouseID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return nil, false
+	}
+	warehouse, err := dh.inventoryService.GetWarehouseByID(warehouseID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if warehouse.DistributorID != c.GetInt64("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return nil, false
+	}
+	return warehouse, true
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/services"
+	validator "marketplace-api/internal/util"
+	"net/http"
+	"strconv"
+)
+
+func (dh *DistributorHandler) ListWebhooks(c *gin.Context) {
+	webhooks, err := dh.webhookService.GetWebhooks(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks, "events": models.WebhookEvents})
+}
+
+// CreateWebhook godoc
+// @Summary      Register a webhook
+// @Description  Registers an endpoint for the given events. The secret is returned only once; every call is signed with the header X-Webhook-Signature: t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">
+// @Tags         distributor
+// @Security     BearerToken
+// @Accept       json
+// @Produce      json
+// @Param        webhook body models.WebhookInput true "Webhook"
+// @Success      201  {object}  models.Webhook
+// @Failure      422  {string}  Unprocessable entity
+// @Router       /distributor/webhooks [post]
+func (dh *DistributorHandler) CreateWebhook(c *gin.Context) {
+	var input models.WebhookInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	webhook := &models.Webhook{
+		DistributorID: c.GetInt64("user_id"),
+		URL:           input.URL,
+		Events:        input.Events,
+		Active:        input.Active == nil || *input.Active,
+	}
+
+	v := validator.New()
+	if models.ValidateWebhook(v, webhook); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err := dh.webhookService.CreateWebhook(webhook)
+	if err != nil {
+		if errors.Is(err, services.ErrWebhookURL) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"url": err.Error()}})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"webhook": webhook, "secret": webhook.Secret})
+}
+
+func (dh *DistributorHandler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := dh.ownWebhook(c)
+	if !ok {
+		return
+	}
+	var input models.WebhookInput
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	webhook.URL = input.URL
+	webhook.Events = input.Events
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	v := validator.New()
+	if models.ValidateWebhook(v, webhook); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	err := dh.webhookService.UpdateWebhook(webhook)
+	if err != nil {
+		if errors.Is(err, services.ErrWebhookURL) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": gin.H{"url": err.Error()}})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook": webhook})
+}
+
+func (dh *DistributorHandler) RotateWebhookSecret(c *gin.Context) {
+	webhook, ok := dh.ownWebhook(c)
+	if !ok {
+		return
+	}
+	err := dh.webhookService.RotateSecret(webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook": webhook, "secret": webhook.Secret})
+}
+
+func (dh *DistributorHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := dh.ownWebhook(c)
+	if !ok {
+		return
+	}
+	err := dh.webhookService.DeleteWebhook(webhook.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook deleted successfully"})
+}
+
+func (dh *DistributorHandler) ListWebhookDeliveries(c *gin.Context) {
+	webhook, ok := dh.ownWebhook(c)
+	if !ok {
+		return
+	}
+	var filters models.Filters
+	v := validator.New()
+	qs := c.Request.URL.Query()
+
+	status := validator.ReadString(qs, "status", "")
+	filters.Page = validator.ReadInt(qs, "page", 1, v)
+	filters.PageSize = validator.ReadInt(qs, "page_size", 20, v)
+	filters.Sort = validator.ReadString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(status == "" || validator.In(status, models.DeliveryStatusPending, models.DeliveryStatusDelivered, models.DeliveryStatusFailed), "status", "invalid status")
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": v.Errors})
+		return
+	}
+
+	deliveries, metadata, err := dh.webhookService.GetDeliveries(webhook.ID, status, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "metadata": metadata})
+}
+
+func (dh *DistributorHandler) GetWebhookDelivery(c *gin.Context) {
+	delivery, ok := dh.ownWebhookDelivery(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivery": delivery})
+}
+
+func (dh *DistributorHandler) ReplayWebhookDelivery(c *gin.Context) {
+	delivery, ok := dh.ownWebhookDelivery(c)
+	if !ok {
+		return
+	}
+	replay, err := dh.webhookService.Replay(delivery)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"delivery": replay})
+}
+
+// ownWebhook loads the webhook from the id parameter and writes the error
+// response if it does not belong to the current distributor.
+func (dh *DistributorHandler) ownWebhook(c *gin.Context) (*models.Webhook, bool) {
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || webhookID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return nil, false
+	}
+	webhook, err := dh.webhookService.GetWebhookByID(webhookID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if webhook.DistributorID != c.GetInt64("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return nil, false
+	}
+	return webhook, true
+}
+
+func (dh *DistributorHandler) ownWebhookDelivery(c *gin.Context) (*models.WebhookDelivery, bool) {
+	webhook, ok := dh.ownWebhook(c)
+	if !ok {
+		return nil, false
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil || deliveryID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery_id parameter"})
+		return nil, false
+	}
+	delivery, err := dh.webhookService.GetDeliveryByID(deliveryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if delivery.WebhookID != webhook.ID {
+		c.JSON(http.StatusNotFound, gin.H{"message": "the requested resource could not be found"})
+		return nil, false
+	}
+	return delivery, true
+}
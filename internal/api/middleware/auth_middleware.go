@@ -66,6 +66,18 @@ func AuthMiddleware(jwtSecret string, role string) gin.HandlerFunc {
 	}
 }
 
+// QueryTokenMiddleware accepts the JWT in the access_token query parameter for
+// clients that cannot set headers, such as the browser EventSource. It must run
+// before AuthMiddleware.
+func QueryTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
+
 func AuthorizeRoleMiddleware(role string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
@@ -88,7 +100,7 @@ func CorsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -19,6 +19,33 @@ func RegisterRoutes(router *gin.RouterGroup, handlers handlers.Handlers, cfg *co
 	router.GET("/store/user/:id", handlers.AuthHandler.GetStoreByID)
 	router.GET("/distributor/user/:id", handlers.AuthHandler.GetDistributorByID)
 
+	//1C CommerceML exchange, authenticated by the handler itself
+	router.GET("/1c_exchange", handlers.ExchangeHandler.Exchange)
+	router.POST("/1c_exchange", handlers.ExchangeHandler.Exchange)
+
+	//Real-time event stream
+	streamRouters := router.Group("/stream")
+	streamRouters.Use(middleware.QueryTokenMiddleware(), middleware.AuthMiddleware(cfg.JWTSecret, ""))
+	streamRouters.GET("", handlers.StreamHandler.Stream)
+
+	//Notification routes
+	notificationRouters := router.Group("/notifications")
+	notificationRouters.Use(middleware.AuthMiddleware(cfg.JWTSecret, ""))
+	notificationRouters.GET("", handlers.NotificationHandler.ListNotifications)
+	notificationRouters.PUT("/read", handlers.NotificationHandler.MarkAllNotificationsRead)
+	notificationRouters.PUT("/:id/read", handlers.NotificationHandler.MarkNotificationRead)
+	notificationRouters.GET("/preferences", handlers.NotificationHandler.GetNotificationPreferences)
+	notificationRouters.PUT("/preferences", handlers.NotificationHandler.UpdateNotificationPreferences)
+
+	//Message thread routes
+	threadRouters := router.Group("/threads")
+	threadRouters.Use(middleware.AuthMiddleware(cfg.JWTSecret, ""))
+	threadRouters.GET("", handlers.MessageHandler.ListThreads)
+	threadRouters.POST("", handlers.MessageHandler.CreateThread)
+	threadRouters.GET("/:id", handlers.MessageHandler.GetThread)
+	threadRouters.POST("/:id/messages", handlers.MessageHandler.PostMessage)
+	threadRouters.PUT("/:id/read", handlers.MessageHandler.MarkThreadRead)
+
 	uploadRouters := router.Group("/upload")
 	uploadRouters.Use(middleware.AuthMiddleware(cfg.JWTSecret, ""))
 	uploadRouters.POST("/image", handlers.UploadImage)
@@ -41,6 +68,26 @@ func RegisterRoutes(router *gin.RouterGroup, handlers handlers.Handlers, cfg *co
 	adminRouters.DELETE("/delete/user/:id", handlers.AdminHandler.DeleteUser)
 	adminRouters.POST("/activate/user/:id", handlers.AdminHandler.ActivateUser)
 	adminRouters.POST("/deactivate/user/:id", handlers.AdminHandler.DeactivateUser)
+	adminRouters.GET("/threads", handlers.AdminHandler.ListThreads)
+	adminRouters.GET("/threads/:id", handlers.AdminHandler.GetThread)
+	adminRouters.PUT("/threads/:id/lock", handlers.AdminHandler.LockThread)
+	adminRouters.PUT("/threads/:id/unlock", handlers.AdminHandler.UnlockThread)
+	adminRouters.PUT("/messages/:id/hide", handlers.AdminHandler.HideMessage)
+	adminRouters.PUT("/messages/:id/restore", handlers.AdminHandler.RestoreMessage)
+	adminRouters.GET("/reviews", handlers.AdminHandler.ListReviews)
+	adminRouters.GET("/reviews/:id", handlers.AdminHandler.GetReview)
+	adminRouters.PUT("/reviews/:id/hide", handlers.AdminHandler.HideReview)
+	adminRouters.PUT("/reviews/:id/restore", handlers.AdminHandler.RestoreReview)
+	//commission and payout routes
+	adminRouters.GET("/commission-rules", handlers.AdminHandler.ListCommissionRules)
+	adminRouters.POST("/commission-rules", handlers.AdminHandler.CreateCommissionRule)
+	adminRouters.PUT("/commission-rules/:id", handlers.AdminHandler.UpdateCommissionRule)
+	adminRouters.DELETE("/commission-rules/:id", handlers.AdminHandler.DeleteCommissionRule)
+	adminRouters.POST("/payouts/adjustments", handlers.AdminHandler.CreatePayoutAdjustment)
+	adminRouters.GET("/payouts/statements", handlers.AdminHandler.ListStatements)
+	adminRouters.GET("/payouts/statements/:id", handlers.AdminHandler.GetStatement)
+	adminRouters.GET("/payouts/statements/:id/export", handlers.AdminHandler.ExportStatement)
+	adminRouters.PUT("/payouts/statements/:id/paid", handlers.AdminHandler.MarkStatementPaid)
 
 	//Distributors routes
 	distributorRouters := router.Group("/distributor")
@@ -50,20 +97,75 @@ func RegisterRoutes(router *gin.RouterGroup, handlers handlers.Handlers, cfg *co
 	//Profile routes
 	distributorRouters.GET("/profile", handlers.DistributorHandler.GetProfile)
 	distributorRouters.PUT("/profile", handlers.DistributorHandler.UpdateProfile)
+	distributorRouters.GET("/tax-settings", handlers.DistributorHandler.GetTaxSettings)
+	distributorRouters.PUT("/tax-settings", handlers.DistributorHandler.UpdateTaxSettings)
 	//products routes
 	distributorRouters.POST("/products", handlers.DistributorHandler.CreateProduct)
 	distributorRouters.PUT("/products/:id", handlers.DistributorHandler.UpdateProduct)
 	distributorRouters.GET("/products/:id", handlers.DistributorHandler.GetProduct)
 	distributorRouters.GET("/products", handlers.DistributorHandler.ListProducts)
 	distributorRouters.DELETE("/products/:id", handlers.DistributorHandler.DeleteProduct)
+	distributorRouters.GET("/products/:id/stock", handlers.DistributorHandler.GetProductStock)
+	distributorRouters.POST("/products/import", handlers.DistributorHandler.ImportProducts)
+	distributorRouters.GET("/products/import", handlers.DistributorHandler.ListProductImports)
+	distributorRouters.GET("/products/import/:id", handlers.DistributorHandler.GetProductImport)
+	distributorRouters.GET("/products/export", handlers.DistributorHandler.ExportProducts)
+	//warehouses routes
+	distributorRouters.GET("/warehouses", handlers.DistributorHandler.ListWarehouses)
+	distributorRouters.POST("/warehouses", handlers.DistributorHandler.CreateWarehouse)
+	distributorRouters.PUT("/warehouses/:id", handlers.DistributorHandler.UpdateWarehouse)
+	distributorRouters.DELETE("/warehouses/:id", handlers.DistributorHandler.DeleteWarehouse)
+	distributorRouters.GET("/warehouses/:id/stock", handlers.DistributorHandler.GetWarehouseStock)
+	distributorRouters.GET("/stock-movements", handlers.DistributorHandler.ListStockMovements)
+	distributorRouters.POST("/stock-movements", handlers.DistributorHandler.CreateStockMovement)
 	//orders routes
 	distributorRouters.PUT("/orders/:id", handlers.DistributorHandler.UpdateOrder)
 	distributorRouters.GET("/orders/:id", handlers.DistributorHandler.GetOrder)
+	distributorRouters.GET("/orders/:id/documents/:kind", handlers.DistributorHandler.GetOrderDocument)
 	distributorRouters.GET("/orders", handlers.DistributorHandler.ListOrders)
 	distributorRouters.GET("/orders/sold", handlers.DistributorHandler.GetStatistics)
+	//delivery slots routes
+	distributorRouters.POST("/delivery-slots", handlers.DistributorHandler.CreateDeliverySlot)
+	distributorRouters.PUT("/delivery-slots/:id", handlers.DistributorHandler.UpdateDeliverySlot)
+	distributorRouters.DELETE("/delivery-slots/:id", handlers.DistributorHandler.DeleteDeliverySlot)
+	distributorRouters.GET("/delivery-slots", handlers.DistributorHandler.ListDeliverySlots)
+	//invoices routes
+	distributorRouters.GET("/invoices", handlers.DistributorHandler.ListInvoices)
+	distributorRouters.POST("/invoices", handlers.DistributorHandler.CreateInvoice)
+	distributorRouters.GET("/invoices/:id", handlers.DistributorHandler.GetInvoice)
+	distributorRouters.POST("/invoices/:id/payments", handlers.DistributorHandler.RecordPayment)
+	//credit routes
+	distributorRouters.GET("/credit", handlers.DistributorHandler.ListCreditAccounts)
+	distributorRouters.GET("/credit/:store_id", handlers.DistributorHandler.GetCreditAccount)
+	distributorRouters.PUT("/credit/:store_id", handlers.DistributorHandler.SaveCreditAccount)
+	distributorRouters.DELETE("/credit/:store_id", handlers.DistributorHandler.DeleteCreditAccount)
+	distributorRouters.GET("/receivables/aging", handlers.DistributorHandler.GetAgingReport)
+	//payouts routes
+	distributorRouters.GET("/payouts/pending", handlers.DistributorHandler.GetPendingPayout)
+	distributorRouters.GET("/payouts/statements", handlers.DistributorHandler.ListStatements)
+	distributorRouters.GET("/payouts/statements/:id", handlers.DistributorHandler.GetStatement)
+	distributorRouters.GET("/payouts/statements/:id/export", handlers.DistributorHandler.ExportStatement)
+	//promotions routes
+	distributorRouters.GET("/promotions", handlers.DistributorHandler.ListPromotions)
+	distributorRouters.POST("/promotions", handlers.DistributorHandler.CreatePromotion)
+	distributorRouters.GET("/promotions/:id", handlers.DistributorHandler.GetPromotion)
+	distributorRouters.PUT("/promotions/:id", handlers.DistributorHandler.UpdatePromotion)
+	distributorRouters.DELETE("/promotions/:id", handlers.DistributorHandler.DeletePromotion)
+	//webhooks routes
+	distributorRouters.GET("/webhooks", handlers.DistributorHandler.ListWebhooks)
+	distributorRouters.POST("/webhooks", handlers.DistributorHandler.CreateWebhook)
+	distributorRouters.PUT("/webhooks/:id", handlers.DistributorHandler.UpdateWebhook)
+	distributorRouters.DELETE("/webhooks/:id", handlers.DistributorHandler.DeleteWebhook)
+	distributorRouters.POST("/webhooks/:id/secret", handlers.DistributorHandler.RotateWebhookSecret)
+	distributorRouters.GET("/webhooks/:id/deliveries", handlers.DistributorHandler.ListWebhookDeliveries)
+	distributorRouters.GET("/webhooks/:id/deliveries/:delivery_id", handlers.DistributorHandler.GetWebhookDelivery)
+	distributorRouters.POST("/webhooks/:id/deliveries/:delivery_id/replay", handlers.DistributorHandler.ReplayWebhookDelivery)
 	//review routes
 	distributorRouters.GET("/reviews", handlers.DistributorHandler.GetReviews)
 	distributorRouters.GET("/reviews/product/:id", handlers.DistributorHandler.GetReviewByProductId)
+	distributorRouters.PUT("/reviews/:id/reply", handlers.DistributorHandler.ReplyToReview)
+	distributorRouters.DELETE("/reviews/:id/reply", handlers.DistributorHandler.DeleteReviewReply)
+	distributorRouters.POST("/reviews/:id/report", handlers.DistributorHandler.ReportReview)
 
 	//Stores routes
 	storeRouters := router.Group("/store")
@@ -72,6 +174,13 @@ func RegisterRoutes(router *gin.RouterGroup, handlers handlers.Handlers, cfg *co
 	//Profile routes
 	storeRouters.GET("/profile", handlers.StoreHandler.GetProfile)
 	storeRouters.PUT("/profile", handlers.StoreHandler.UpdateProfile)
+	//addresses routes
+	storeRouters.GET("/cities", handlers.StoreHandler.ListCities)
+	storeRouters.GET("/addresses", handlers.StoreHandler.ListAddresses)
+	storeRouters.POST("/addresses", handlers.StoreHandler.CreateAddress)
+	storeRouters.PUT("/addresses/:id", handlers.StoreHandler.UpdateAddress)
+	storeRouters.PUT("/addresses/:id/default", handlers.StoreHandler.SetDefaultAddress)
+	storeRouters.DELETE("/addresses/:id", handlers.StoreHandler.DeleteAddress)
 	//products routes
 	storeRouters.GET("/products/:id", handlers.StoreHandler.GetProduct)
 	storeRouters.GET("/products", handlers.StoreHandler.ListProducts)
@@ -80,15 +189,26 @@ func RegisterRoutes(router *gin.RouterGroup, handlers handlers.Handlers, cfg *co
 	storeRouters.PUT("/carts/products/:id", handlers.StoreHandler.UpdateCartItem)
 	storeRouters.DELETE("/carts/products/:id", handlers.StoreHandler.RemoveFromCart)
 	storeRouters.GET("/carts", handlers.StoreHandler.GetCart)
+	storeRouters.PUT("/carts/coupon", handlers.StoreHandler.ApplyCoupon)
+	storeRouters.DELETE("/carts/coupon", handlers.StoreHandler.RemoveCoupon)
 	//orders routes
 	storeRouters.POST("/orders", handlers.StoreHandler.CreateOrder)
 	storeRouters.PUT("/orders/:id", handlers.StoreHandler.CancelOrder)
 	storeRouters.GET("/orders/:id", handlers.StoreHandler.GetOrder)
+	storeRouters.GET("/orders/:id/invoice", handlers.StoreHandler.GetOrderInvoice)
 	storeRouters.GET("/orders", handlers.StoreHandler.ListOrders)
 	storeRouters.GET("/orders/purchased", handlers.StoreHandler.GetStatistics)
+	//invoices routes
+	storeRouters.GET("/invoices", handlers.StoreHandler.ListInvoices)
+	storeRouters.GET("/invoices/:id", handlers.StoreHandler.GetInvoice)
+	storeRouters.GET("/credit", handlers.StoreHandler.ListCreditAccounts)
+	//delivery routes
+	storeRouters.GET("/delivery-slots", handlers.StoreHandler.ListDeliveryWindows)
 	//review
 	storeRouters.POST("/reviews", handlers.StoreHandler.CreateReview)
 	storeRouters.GET("/reviews", handlers.StoreHandler.GetReview)
 	storeRouters.GET("/reviews/product/:id", handlers.StoreHandler.GetReviewByProductId)
+	storeRouters.PUT("/reviews/:id", handlers.StoreHandler.UpdateReview)
 	storeRouters.DELETE("/reviews/:id", handlers.StoreHandler.DeleteReview)
+	storeRouters.POST("/reviews/:id/report", handlers.StoreHandler.ReportReview)
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -8,15 +9,28 @@ import (
 	"marketplace-api/internal/api/middleware"
 	"marketplace-api/internal/api/routes"
 	"marketplace-api/internal/config"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/jobs"
+	"marketplace-api/internal/moderation"
+	"marketplace-api/internal/notify"
 	"marketplace-api/internal/repository"
 	"marketplace-api/internal/services"
+	"marketplace-api/internal/storage"
+	"marketplace-api/internal/stream"
+	"marketplace-api/pkg/database"
+	"time"
 )
 
 type Server struct {
-	config *config.Config
-	router *gin.Engine
-	db     *gorm.DB
-	logger *logrus.Logger
+	config    *config.Config
+	router    *gin.Engine
+	db        *gorm.DB
+	logger    *logrus.Logger
+	bus       *events.Bus
+	outbox    *events.Outbox
+	hub       *stream.Hub
+	scheduler *jobs.Scheduler
+	catalog   *services.CatalogService
 }
 
 func NewServer(router *gin.Engine, db *gorm.DB, logger *logrus.Logger, config *config.Config) *Server {
@@ -25,6 +39,9 @@ func NewServer(router *gin.Engine, db *gorm.DB, logger *logrus.Logger, config *c
 		db:     db,
 		config: config,
 		logger: logger,
+		bus:    events.NewBus(),
+		outbox: events.NewOutbox(db),
+		hub:    stream.NewHub(),
 	}
 	// Initialize repository layer
 	userRepository := repository.NewUserRepository(db)
@@ -33,24 +50,113 @@ func NewServer(router *gin.Engine, db *gorm.DB, logger *logrus.Logger, config *c
 	productRepository := repository.NewProductRepository(db)
 	cartRepository := repository.NewCartRepository(db)
 	orderRepository := repository.NewOrderRepository(db)
+	deliveryRepository := repository.NewDeliveryRepository(db)
+	inventoryRepository := repository.NewInventoryRepository(db)
+	importRepository := repository.NewImportRepository(db)
+	webhookRepository := repository.NewWebhookRepository(db)
+	notificationRepository := repository.NewNotificationRepository(db)
+	messageRepository := repository.NewMessageRepository(db)
+	uploadRepository := repository.NewUploadRepository(db)
+	ratingRepository := repository.NewRatingRepository(db)
+	invoiceRepository := repository.NewInvoiceRepository(db)
+	creditRepository := repository.NewCreditRepository(db)
+	payoutRepository := repository.NewPayoutRepository(db)
+	promotionRepository := repository.NewPromotionRepository(db)
+	documentRepository := repository.NewDocumentRepository(db)
+	// Initialize file storage
+	blobStore, err := storage.NewBlobStore(config)
+	if err != nil {
+		logger.Fatalf("Failed to configure storage: %s", err.Error())
+	}
+	// Initialize notification senders
+	emailSender, err := notify.NewEmailSender(config.EmailSender, logger)
+	if err != nil {
+		logger.Fatalf("Failed to configure email: %s", err.Error())
+	}
+	smsSender, err := notify.NewSMSSender(config.SMSSender, logger)
+	if err != nil {
+		logger.Fatalf("Failed to configure sms: %s", err.Error())
+	}
 	// Initialize service layer
 	userService := services.NewUserService(userRepository, distributorRepository, storeRepository)
-	distributorService := services.NewDistributorService(distributorRepository, userRepository)
-	productService := services.NewProductService(productRepository, distributorRepository)
-	storeService := services.NewStoreService(storeRepository, userRepository)
-	cartService := services.NewCartService(cartRepository, productRepository, distributorRepository)
-	orderService := services.NewOrderService(orderRepository, productRepository)
+	distributorService := services.NewDistributorService(distributorRepository, userRepository, ratingRepository)
+	imageService := services.NewImageService(uploadRepository, blobStore, config.ImageMaxSize)
+	productService := services.NewProductService(productRepository, distributorRepository, orderRepository, ratingRepository, server.outbox, imageService, config.ReviewEditWindow, moderation.NewFilter(config.ReviewBlockedWords))
+	storeService := services.NewStoreService(storeRepository, userRepository, distributorRepository)
+	promotionService := services.NewPromotionService(promotionRepository, productRepository)
+	cartService := services.NewCartService(cartRepository, productRepository, distributorRepository, promotionService)
+	invoiceService := services.NewInvoiceService(invoiceRepository, creditRepository, server.outbox, config.PaymentTermsDays)
+	creditService := services.NewCreditService(creditRepository, storeRepository)
+	payoutService := services.NewPayoutService(payoutRepository, orderRepository, server.outbox, config.PayoutPeriod)
+	orderService := services.NewOrderService(orderRepository, productRepository, distributorRepository, deliveryRepository, inventoryRepository, invoiceService, creditService, payoutService, promotionService, server.outbox)
+	inventoryService := services.NewInventoryService(inventoryRepository, distributorRepository, payoutService, server.outbox)
+	deliveryService := services.NewDeliveryService(deliveryRepository)
+	catalogService := services.NewCatalogService(productRepository, productService, importRepository, inventoryService, orderService)
+	webhookService := services.NewWebhookService(webhookRepository)
+	documentService := services.NewDocumentService(documentRepository, invoiceRepository, orderRepository, distributorRepository, blobStore, server.outbox)
+	notificationService := services.NewNotificationService(notificationRepository, userRepository, distributorRepository, emailSender, smsSender, logger)
+	messageService := services.NewMessageService(messageRepository, orderRepository, productRepository, distributorRepository, server.outbox)
+	exchangeService := services.NewExchangeService(productRepository, productService, orderRepository, distributorRepository, inventoryService, orderService, config.ExchangeDir)
 	// Initialize handler layer
 	authHandler := handlers.NewAuthHandler(userService, distributorService, nil, config.JWTSecret, logger)
-	distributorHandler := handlers.NewDistributorHandler(distributorService, productService, orderService)
-	storeHandler := handlers.NewStoreHandler(storeService, productService, distributorService, cartService, orderService)
-	adminHandler := handlers.NewAdminHandler(userService, distributorService, storeService, logger)
+	distributorHandler := handlers.NewDistributorHandler(distributorService, productService, orderService, deliveryService, inventoryService, catalogService, webhookService, invoiceService, creditService, payoutService, promotionService, documentService)
+	storeHandler := handlers.NewStoreHandler(storeService, productService, distributorService, cartService, orderService, deliveryService, invoiceService, creditService, documentService)
+	adminHandler := handlers.NewAdminHandler(userService, distributorService, storeService, messageService, productService, payoutService, logger)
+	exchangeHandler := handlers.NewExchangeHandler(userService, exchangeService, config.JWTSecret, config.ExchangeFileLimit, logger)
+	streamHandler := handlers.NewStreamHandler(server.hub, server.outbox, logger)
+	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	messageHandler := handlers.NewMessageHandler(messageService, blobStore)
 	//productHandler := handlers.ProductHandler{}
 	// Register routes
-	handler := handlers.NewHandlers(authHandler, distributorHandler, storeHandler, adminHandler)
+	handler := handlers.NewHandlers(authHandler, distributorHandler, storeHandler, adminHandler, exchangeHandler, streamHandler, notificationHandler, messageHandler, imageService, blobStore)
 	router.Use(middleware.CorsMiddleware())
 	APIRouter := router.Group("/api")
-	APIRouter.Static("images/", "./images/")
+	if local, ok := blobStore.(*storage.LocalStore); ok {
+		APIRouter.Static("images/", local.Dir())
+	} else if signer, ok := blobStore.(storage.Signer); ok && signer.Private() {
+		APIRouter.GET("/images/*key", handler.ServeImage)
+	}
 	routes.RegisterRoutes(APIRouter, *handler, config)
+	// Initialize background jobs
+	server.bus.Subscribe(events.All, func(event events.Event) error {
+		logger.WithFields(logrus.Fields{"event": event.Name, "id": event.ID}).Info("event published")
+		return nil
+	})
+	server.bus.Subscribe(events.All, func(event events.Event) error {
+		if err := webhookService.HandleEvent(event); err != nil {
+			logger.WithField("event", event.Name).Errorf("failed to queue webhooks: %s", err.Error())
+			return err
+		}
+		return nil
+	})
+	server.bus.Subscribe(events.All, func(event events.Event) error {
+		if err := notificationService.HandleEvent(event); err != nil {
+			logger.WithField("event", event.Name).Errorf("failed to notify: %s", err.Error())
+			return err
+		}
+		return nil
+	})
+	server.bus.Subscribe(events.All, stream.Notify(db))
+	server.catalog = catalogService
+	server.scheduler = jobs.NewScheduler(logger)
+	server.scheduler.Register(jobs.NewOrderExpiryJob(orderService, server.bus, config.OrderConfirmationSLA, config.JobsInterval))
+	server.scheduler.Register(jobs.NewCartCleanupJob(cartService, server.bus, config.CartIdleDays, config.JobsInterval))
+	server.scheduler.Register(jobs.NewImageCleanupJob(imageService, config.ImageOrphanTTL, config.JobsInterval))
+	server.scheduler.Register(jobs.NewPayoutStatementsJob(payoutService, config.JobsInterval))
+	server.scheduler.Register(jobs.NewWebhookDeliveryJob(webhookService, config.WebhookInterval))
+	server.scheduler.Register(jobs.NewOutboxRelayJob(events.NewRelay(db, server.bus, 100), config.OutboxRetention, config.OutboxInterval))
+	server.scheduler.Register(jobs.NewStreamListenerJob(stream.NewListener(database.DSN(config), server.outbox, server.hub, logger), time.Second))
 	return server
 }
+
+// StartJobs starts background jobs; they stop when ctx is cancelled.
+func (s *Server) StartJobs(ctx context.Context) {
+	s.scheduler.Start(ctx)
+}
+
+// StopJobs stops background jobs and waits for running ones, including
+// catalog imports, to finish.
+func (s *Server) StopJobs() {
+	s.scheduler.Stop()
+	s.catalog.Wait()
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"github.com/spf13/viper"
 	"os"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -16,11 +18,64 @@ type Config struct {
 	LogLevel      string
 	AdminEmail    string
 	AdminPassword string
+	// Background jobs
+	JobsInterval         time.Duration
+	OrderConfirmationSLA time.Duration
+	CartIdleDays         int
+	WebhookInterval      time.Duration
+	OutboxInterval       time.Duration
+	OutboxRetention      time.Duration
+	// Reviews
+	ReviewEditWindow   time.Duration
+	ReviewBlockedWords []string
+	// Invoices and payouts
+	PaymentTermsDays int
+	PayoutPeriod     string
+	// Notifications
+	EmailSender string
+	SMSSender   string
+	// File storage
+	StorageDriver    string
+	StorageLocalDir  string
+	StoragePublicURL string
+	S3Endpoint       string
+	S3Region         string
+	S3Bucket         string
+	S3AccessKey      string
+	S3SecretKey      string
+	S3PathStyle      bool
+	S3SignedURLTTL   time.Duration
+	ImageMaxSize     int64
+	ImageOrphanTTL   time.Duration
+	// 1C exchange
+	ExchangeDir       string
+	ExchangeFileLimit int64
 }
 
 // LoadConfig loads configuration from environment variables or .env file
 func LoadConfig() *Config {
 	viper.AutomaticEnv()
+	viper.SetDefault("JOBS_INTERVAL", "5m")
+	viper.SetDefault("ORDER_CONFIRMATION_SLA", "48h")
+	viper.SetDefault("CART_IDLE_DAYS", 30)
+	viper.SetDefault("WEBHOOK_INTERVAL", "30s")
+	viper.SetDefault("OUTBOX_INTERVAL", "1s")
+	viper.SetDefault("OUTBOX_RETENTION", "168h")
+	viper.SetDefault("REVIEW_EDIT_WINDOW", "168h")
+	viper.SetDefault("PAYMENT_TERMS_DAYS", 14)
+	viper.SetDefault("PAYOUT_PERIOD", "monthly")
+	viper.SetDefault("EMAIL_SENDER", "log")
+	viper.SetDefault("SMS_SENDER", "log")
+	viper.SetDefault("STORAGE_DRIVER", "local")
+	viper.SetDefault("STORAGE_LOCAL_DIR", "./images")
+	viper.SetDefault("STORAGE_PUBLIC_URL", "http://localhost:4000/api/images")
+	viper.SetDefault("S3_REGION", "us-east-1")
+	viper.SetDefault("S3_PATH_STYLE", true)
+	viper.SetDefault("S3_SIGNED_URL_TTL", "0s")
+	viper.SetDefault("IMAGE_MAX_SIZE", 10<<20)
+	viper.SetDefault("IMAGE_ORPHAN_TTL", "24h")
+	viper.SetDefault("EXCHANGE_DIR", "./exchange")
+	viper.SetDefault("EXCHANGE_FILE_LIMIT", 10<<20)
 
 	// Attempt to read configuration from environment variables
 	//cfg := readFromEnv()
@@ -73,6 +128,38 @@ func readFromEnvFile() *Config {
 		LogLevel:      viper.GetString("LOG_LEVEL"),
 		AdminEmail:    viper.GetString("ADMIN_EMAIL"),
 		AdminPassword: viper.GetString("ADMIN_PASSWORD"),
+
+		JobsInterval:         viper.GetDuration("JOBS_INTERVAL"),
+		OrderConfirmationSLA: viper.GetDuration("ORDER_CONFIRMATION_SLA"),
+		CartIdleDays:         viper.GetInt("CART_IDLE_DAYS"),
+		WebhookInterval:      viper.GetDuration("WEBHOOK_INTERVAL"),
+		OutboxInterval:       viper.GetDuration("OUTBOX_INTERVAL"),
+		OutboxRetention:      viper.GetDuration("OUTBOX_RETENTION"),
+
+		ReviewEditWindow:   viper.GetDuration("REVIEW_EDIT_WINDOW"),
+		ReviewBlockedWords: strings.Split(viper.GetString("REVIEW_BLOCKED_WORDS"), ","),
+
+		PaymentTermsDays: viper.GetInt("PAYMENT_TERMS_DAYS"),
+		PayoutPeriod:     viper.GetString("PAYOUT_PERIOD"),
+
+		EmailSender: viper.GetString("EMAIL_SENDER"),
+		SMSSender:   viper.GetString("SMS_SENDER"),
+
+		StorageDriver:    viper.GetString("STORAGE_DRIVER"),
+		StorageLocalDir:  viper.GetString("STORAGE_LOCAL_DIR"),
+		StoragePublicURL: viper.GetString("STORAGE_PUBLIC_URL"),
+		S3Endpoint:       viper.GetString("S3_ENDPOINT"),
+		S3Region:         viper.GetString("S3_REGION"),
+		S3Bucket:         viper.GetString("S3_BUCKET"),
+		S3AccessKey:      viper.GetString("S3_ACCESS_KEY"),
+		S3SecretKey:      viper.GetString("S3_SECRET_KEY"),
+		S3PathStyle:      viper.GetBool("S3_PATH_STYLE"),
+		S3SignedURLTTL:   viper.GetDuration("S3_SIGNED_URL_TTL"),
+		ImageMaxSize:     viper.GetInt64("IMAGE_MAX_SIZE"),
+		ImageOrphanTTL:   viper.GetDuration("IMAGE_ORPHAN_TTL"),
+
+		ExchangeDir:       viper.GetString("EXCHANGE_DIR"),
+		ExchangeFileLimit: viper.GetInt64("EXCHANGE_FILE_LIMIT"),
 	}
 
 	return cfg
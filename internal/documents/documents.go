@@ -0,0 +1,325 @@
+// Package documents renders the printable documents of orders, invoices and
+// waybills, as PDF files.
+package documents
+
+import (
+	"fmt"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/pdf"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	margin     = 40.0
+	textSize   = 9.0
+	tableSize  = 8.0
+	lineHeight = 11.0
+	cellPad    = 3.0
+	footerRoom = 60.0
+)
+
+// Party is the seller or the buyer of a document.
+type Party struct {
+	CompanyName string
+	BIN         string
+	Address     string
+	Phone       string
+}
+
+// Line is an order line of a document. GrossAmount is NetAmount plus
+// TaxAmount, after the discount.
+type Line struct {
+	Name        string
+	SKU         string
+	Quantity    int64
+	UnitPrice   float64
+	Discount    float64
+	NetAmount   float64
+	TaxRate     float64
+	TaxAmount   float64
+	GrossAmount float64
+}
+
+// Data is what a document shows.
+type Data struct {
+	Number          string
+	IssuedAt        time.Time
+	Seller          Party
+	Buyer           Party
+	DeliveryAddress string
+	DeliveryDate    *time.Time
+	DueAt           time.Time
+	OrderNumbers    []string
+	Lines           []Line
+	NetAmount       float64
+	TaxAmount       float64
+	GrossAmount     float64
+	DiscountAmount  float64
+}
+
+type column struct {
+	title string
+	width float64
+	align pdf.Align
+	value func(i int, line *Line) string
+}
+
+// template lays out a kind of document.
+type template struct {
+	title      string
+	columns    []column
+	dueDate    bool
+	signatures []string
+}
+
+var (
+	numberColumn    = column{"#", 20, pdf.Right, func(i int, _ *Line) string { return strconv.Itoa(i + 1) }}
+	skuColumn       = column{"SKU", 55, pdf.Left, func(_ int, l *Line) string { return l.SKU }}
+	quantityColumn  = column{"Qty", 35, pdf.Right, func(_ int, l *Line) string { return strconv.FormatInt(l.Quantity, 10) }}
+	unitPriceColumn = column{"Unit price", 55, pdf.Right, func(_ int, l *Line) string { return formatMoney(l.UnitPrice) }}
+	netColumn       = column{"Net amount", 50, pdf.Right, func(_ int, l *Line) string { return formatMoney(l.NetAmount) }}
+	taxColumn       = column{"VAT", 35, pdf.Right, func(_ int, l *Line) string { return formatMoney(l.TaxAmount) }}
+	totalColumn     = column{"Total", 55, pdf.Right, func(_ int, l *Line) string { return formatMoney(l.GrossAmount) }}
+)
+
+var templates = map[string]template{
+	models.DocumentInvoice: {
+		title: "Invoice",
+		columns: []column{
+			numberColumn,
+			{"Product", 135, pdf.Left, func(_ int, l *Line) string { return l.Name }},
+			skuColumn,
+			quantityColumn,
+			unitPriceColumn,
+			{"Discount", 45, pdf.Right, func(_ int, l *Line) string { return formatMoney(l.Discount) }},
+			netColumn,
+			{"VAT %", 30, pdf.Right, func(_ int, l *Line) string { return strconv.FormatFloat(l.TaxRate, 'f', -1, 64) }},
+			taxColumn,
+			totalColumn,
+		},
+		dueDate:    true,
+		signatures: []string{"Issued by"},
+	},
+	models.DocumentWaybill: {
+		title: "Waybill",
+		columns: []column{
+			numberColumn,
+			{"Product", 185, pdf.Left, func(_ int, l *Line) string { return l.Name }},
+			{"SKU", 70, pdf.Left, func(_ int, l *Line) string { return l.SKU }},
+			{"Qty", 40, pdf.Right, func(_ int, l *Line) string { return strconv.FormatInt(l.Quantity, 10) }},
+			unitPriceColumn,
+			netColumn,
+			{"VAT", 40, pdf.Right, func(_ int, l *Line) string { return formatMoney(l.TaxAmount) }},
+			totalColumn,
+		},
+		signatures: []string{"Released by", "Received by"},
+	},
+}
+
+// Render returns the PDF of the document of the kind.
+func Render(kind string, data *Data) ([]byte, error) {
+	tmpl, ok := templates[kind]
+	if !ok {
+		return nil, fmt.Errorf("documents: unknown kind %q", kind)
+	}
+	doc, err := pdf.New()
+	if err != nil {
+		return nil, err
+	}
+	r := &renderer{doc: doc, tmpl: &tmpl, data: data, y: margin}
+	r.header()
+	r.parties()
+	r.table()
+	r.totals()
+	r.signatures()
+	return doc.Bytes()
+}
+
+type renderer struct {
+	doc  *pdf.Document
+	tmpl *template
+	data *Data
+	y    float64
+}
+
+// ensure starts a new page unless height fits above the footer.
+func (r *renderer) ensure(height float64) bool {
+	if r.y+height <= pdf.PageHeight-footerRoom {
+		return false
+	}
+	r.doc.AddPage()
+	r.y = margin
+	return true
+}
+
+func (r *renderer) header() {
+	r.y += 16
+	r.doc.Text(margin, r.y, 16, pdf.Bold, pdf.Left, r.tmpl.title+" No. "+r.data.Number)
+	r.doc.Text(pdf.PageWidth-margin, r.y, textSize, pdf.Regular, pdf.Right, "Date: "+formatDate(r.data.IssuedAt))
+	r.y += 16
+	if len(r.data.OrderNumbers) > 0 {
+		r.paragraph(margin, pdf.PageWidth-2*margin, "Orders: "+strings.Join(r.data.OrderNumbers, ", "))
+	}
+	r.y += 8
+}
+
+func (r *renderer) parties() {
+	width := (pdf.PageWidth - 2*margin - 20) / 2
+	top := r.y
+	r.party(margin, width, "Seller", &r.data.Seller)
+	sellerBottom := r.y
+	r.y = top
+	r.party(margin+width+20, width, "Buyer", &r.data.Buyer)
+	r.y = max(r.y, sellerBottom) + 8
+
+	if r.data.DeliveryAddress != "" {
+		r.paragraph(margin, pdf.PageWidth-2*margin, "Delivery address: "+r.data.DeliveryAddress)
+	}
+	if r.data.DeliveryDate != nil {
+		r.paragraph(margin, pdf.PageWidth-2*margin, "Delivery date: "+formatDate(*r.data.DeliveryDate))
+	}
+	if r.tmpl.dueDate && !r.data.DueAt.IsZero() {
+		r.paragraph(margin, pdf.PageWidth-2*margin, "Payment due: "+formatDate(r.data.DueAt))
+	}
+	r.y += 8
+}
+
+func (r *renderer) party(x, width float64, label string, party *Party) {
+	r.y += lineHeight
+	r.doc.Text(x, r.y, textSize, pdf.Bold, pdf.Left, label)
+	for _, line := range r.doc.Wrap(party.CompanyName, width, textSize, pdf.Bold) {
+		r.y += lineHeight
+		r.doc.Text(x, r.y, textSize, pdf.Bold, pdf.Left, line)
+	}
+	r.paragraph(x, width, "BIN: "+party.BIN)
+	if party.Address != "" {
+		r.paragraph(x, width, "Address: "+party.Address)
+	}
+	if party.Phone != "" {
+		r.paragraph(x, width, "Phone: "+party.Phone)
+	}
+}
+
+// paragraph draws s wrapped to width below the current line.
+func (r *renderer) paragraph(x, width float64, s string) {
+	for _, line := range r.doc.Wrap(s, width, textSize, pdf.Regular) {
+		r.y += lineHeight
+		r.doc.Text(x, r.y, textSize, pdf.Regular, pdf.Left, line)
+	}
+}
+
+func (r *renderer) table() {
+	header := make([]string, len(r.tmpl.columns))
+	for i, col := range r.tmpl.columns {
+		header[i] = col.title
+	}
+	r.ensure(2 * (lineHeight + 2*cellPad))
+	r.row(header, pdf.Bold, 0.9)
+	for i := range r.data.Lines {
+		cells := make([]string, len(r.tmpl.columns))
+		for j, col := range r.tmpl.columns {
+			cells[j] = col.value(i, &r.data.Lines[i])
+		}
+		if r.ensure(r.rowHeight(cells, pdf.Regular)) {
+			r.row(header, pdf.Bold, 0.9)
+		}
+		r.row(cells, pdf.Regular, 1)
+	}
+	r.y += 8
+}
+
+func (r *renderer) wrapCells(cells []string, style pdf.Style) [][]string {
+	wrapped := make([][]string, len(cells))
+	for i, cell := range cells {
+		wrapped[i] = r.doc.Wrap(cell, r.tmpl.columns[i].width-2*cellPad, tableSize, style)
+	}
+	return wrapped
+}
+
+func (r *renderer) rowHeight(cells []string, style pdf.Style) float64 {
+	lines := 1
+	for _, cell := range r.wrapCells(cells, style) {
+		lines = max(lines, len(cell))
+	}
+	return float64(lines)*lineHeight + 2*cellPad
+}
+
+func (r *renderer) row(cells []string, style pdf.Style, gray float64) {
+	height := r.rowHeight(cells, style)
+	x := margin
+	for i, lines := range r.wrapCells(cells, style) {
+		col := r.tmpl.columns[i]
+		if gray < 1 {
+			r.doc.FillRect(x, r.y, col.width, height, gray)
+		}
+		r.doc.Rect(x, r.y, col.width, height, 0.5)
+		textX := x + cellPad
+		if col.align == pdf.Right {
+			textX = x + col.width - cellPad
+		}
+		for j, line := range lines {
+			r.doc.Text(textX, r.y+cellPad+float64(j+1)*lineHeight-2, tableSize, style, col.align, line)
+		}
+		x += col.width
+	}
+	r.y += height
+}
+
+func (r *renderer) totals() {
+	rows := [][2]string{
+		{"Total net amount:", formatMoney(r.data.NetAmount)},
+		{"VAT:", formatMoney(r.data.TaxAmount)},
+		{"Total amount due (KZT):", formatMoney(r.data.GrossAmount)},
+	}
+	if r.data.DiscountAmount > 0 {
+		rows = append(rows, [2]string{"Including discounts of:", formatMoney(r.data.DiscountAmount)})
+	}
+	r.ensure(float64(len(rows)) * lineHeight)
+	for i, row := range rows {
+		style := pdf.Regular
+		if i == 2 {
+			style = pdf.Bold
+		}
+		r.y += lineHeight
+		r.doc.Text(pdf.PageWidth-margin-90, r.y, textSize, style, pdf.Right, row[0])
+		r.doc.Text(pdf.PageWidth-margin, r.y, textSize, style, pdf.Right, row[1])
+	}
+	r.y += 8
+}
+
+func (r *renderer) signatures() {
+	r.ensure(40)
+	r.y += 30
+	width := (pdf.PageWidth - 2*margin) / float64(len(r.tmpl.signatures))
+	for i, label := range r.tmpl.signatures {
+		x := margin + float64(i)*width
+		r.doc.Text(x, r.y, textSize, pdf.Regular, pdf.Left, label+":")
+		labelWidth := r.doc.TextWidth(label+":", textSize, pdf.Regular)
+		r.doc.Line(x+labelWidth+5, r.y+2, x+width-20, r.y+2, 0.5)
+	}
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("02.01.2006")
+}
+
+// formatMoney formats an amount with two decimals and spaces between
+// thousands, as is usual on Kazakh documents.
+func formatMoney(amount float64) string {
+	s := strconv.FormatFloat(models.RoundMoney(amount), 'f', 2, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, fraction := s[:len(s)-3], s[len(s)-3:]
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(digit)
+	}
+	return sign + b.String() + fraction
+}
@@ -0,0 +1,194 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"marketplace-api/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	// All subscribes a handler to every published event.
+	All = "*"
+
+	OrderExpired      = "order.expired"
+	CartPurged        = "cart.purged"
+	OrderCreated      = "order.created"
+	OrderStageChanged = "order.stage_changed"
+	OrderCancelled    = "order.cancelled"
+	ReviewCreated     = "review.created"
+	ReviewUpdated     = "review.updated"
+	ReviewDeleted     = "review.deleted"
+	ProductCreated    = "product.created"
+	ProductUpdated    = "product.updated"
+	ProductDeleted    = "product.deleted"
+	ProductLowStock   = "product.low_stock"
+	MessageCreated    = "message.created"
+	InvoiceIssued     = "invoice.issued"
+	PaymentRecorded   = "payment.recorded"
+
+	AggregateOrder   = "order"
+	AggregateProduct = "product"
+	AggregateReview  = "review"
+	AggregateCart    = "cart"
+	AggregateThread  = "thread"
+	AggregateInvoice = "invoice"
+)
+
+// OrderStageChange is the payload of OrderStageChanged.
+type OrderStageChange struct {
+	Order *models.Order `json:"order"`
+	From  models.Stage  `json:"from"`
+	To    models.Stage  `json:"to"`
+}
+
+// Event model info. Events relayed from the outbox carry their outbox ID, the
+// sequence number they were dispatched with and a JSON payload; events
+// published directly carry the payload value.
+type Event struct {
+	ID            int64       `json:"id,omitempty"`
+	Sequence      int64       `json:"sequence,omitempty"`
+	Name          string      `json:"name"`
+	AggregateType string      `json:"aggregate_type,omitempty"`
+	AggregateID   int64       `json:"aggregate_id,omitempty"`
+	DistributorID int64       `json:"distributor_id,omitempty"`
+	StoreID       int64       `json:"store_id,omitempty"`
+	ActorID       int64       `json:"actor_id,omitempty"`
+	Payload       interface{} `json:"payload"`
+	OccurredAt    time.Time   `json:"occurred_at"`
+}
+
+// Handler handles an event. Returning an error makes the relay deliver the
+// event again later, so handlers must tolerate duplicates.
+type Handler func(event Event) error
+
+// Bus delivers events to in-process subscribers.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+func (b *Bus) Subscribe(name string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[name] = append(b.handlers[name], handler)
+}
+
+// Publish delivers an event that is not kept in the outbox, such as the
+// results of background jobs.
+func (b *Bus) Publish(name string, payload interface{}) error {
+	return b.Dispatch(Event{
+		Name:       name,
+		Payload:    payload,
+		OccurredAt: time.Now(),
+	})
+}
+
+// Dispatch runs every subscriber of the event, including the ones that panic
+// or fail, and returns their errors.
+func (b *Bus) Dispatch(event Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.handlers[event.Name]...), b.handlers[All]...)
+	b.mu.RUnlock()
+	var errs []error
+	for _, handler := range handlers {
+		if err := safeHandle(handler, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func safeHandle(handler Handler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("event handler panicked: %v", r)
+		}
+	}()
+	return handler(event)
+}
+
+// NewOrderEvent returns an event of the order aggregate.
+func NewOrderEvent(name string, order *models.Order, payload interface{}) Event {
+	return Event{
+		Name:          name,
+		AggregateType: AggregateOrder,
+		AggregateID:   order.ID,
+		DistributorID: order.DistributorID,
+		StoreID:       order.StoreID,
+		Payload:       payload,
+		OccurredAt:    time.Now(),
+	}
+}
+
+// NewProductEvent returns an event of the product aggregate.
+func NewProductEvent(name string, product *models.Product) Event {
+	return Event{
+		Name:          name,
+		AggregateType: AggregateProduct,
+		AggregateID:   product.ID,
+		DistributorID: product.DistributorID,
+		Payload:       product,
+		OccurredAt:    time.Now(),
+	}
+}
+
+// NewReviewEvent returns an event of the review aggregate.
+func NewReviewEvent(name string, review *models.Review) Event {
+	return Event{
+		Name:          name,
+		AggregateType: AggregateReview,
+		AggregateID:   review.ID,
+		DistributorID: review.DistributorId,
+		StoreID:       review.StoreId,
+		Payload:       review,
+		OccurredAt:    time.Now(),
+	}
+}
+
+// NewInvoiceEvent returns an event of the invoice aggregate.
+func NewInvoiceEvent(name string, invoice *models.Invoice, payload interface{}) Event {
+	return Event{
+		Name:          name,
+		AggregateType: AggregateInvoice,
+		AggregateID:   invoice.ID,
+		DistributorID: invoice.DistributorID,
+		StoreID:       invoice.StoreID,
+		Payload:       payload,
+		OccurredAt:    time.Now(),
+	}
+}
+
+// Decode unmarshals the payload into v, whether the event was relayed from the
+// outbox or published directly.
+func (e Event) Decode(v interface{}) error {
+	raw, ok := e.Payload.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(e.Payload); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// NewMessageEvent returns an event of the thread aggregate about one of its
+// messages.
+func NewMessageEvent(name string, thread *models.MessageThread, message *models.Message) Event {
+	return Event{
+		Name:          name,
+		AggregateType: AggregateThread,
+		AggregateID:   thread.ID,
+		DistributorID: thread.DistributorID,
+		StoreID:       thread.StoreID,
+		ActorID:       message.SenderID,
+		Payload:       message,
+		OccurredAt:    time.Now(),
+	}
+}
@@ -0,0 +1,253 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+const (
+	// relayLockKey is the Postgres advisory lock that lets only one API
+	// instance relay the outbox at a time, which keeps events of an aggregate
+	// in order.
+	relayLockKey = 7320411
+	// relayMaxAttempts is how many times an event is dispatched before it is
+	// dead-lettered.
+	relayMaxAttempts = 10
+	relayBaseBackoff = 10 * time.Second
+	relayMaxBackoff  = time.Hour
+	// relayDispatchTimeout is how long the subscribers of an event may take.
+	relayDispatchTimeout = 30 * time.Second
+	// dispatchSequence is the Postgres sequence dispatched events are numbered
+	// from.
+	dispatchSequence = "outbox_dispatch_seq"
+)
+
+// Outbox writes domain events in the transaction of the change that causes
+// them.
+type Outbox struct {
+	db *gorm.DB
+}
+
+func NewOutbox(db *gorm.DB) *Outbox {
+	return &Outbox{db: db}
+}
+
+// Transaction runs fn in a database transaction. Events recorded with tx are
+// stored only if the transaction commits.
+func (o *Outbox) Transaction(fn func(tx *gorm.DB) error) error {
+	return o.db.Transaction(fn)
+}
+
+// Record stores the events in the outbox using tx.
+func (o *Outbox) Record(tx *gorm.DB, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	rows := make([]models.OutboxEvent, 0, len(events))
+	for _, event := range events {
+		payload, err := json.Marshal(event.Payload)
+		if err != nil {
+			return err
+		}
+		occurredAt := event.OccurredAt
+		if occurredAt.IsZero() {
+			occurredAt = time.Now()
+		}
+		rows = append(rows, models.OutboxEvent{
+			Name:          event.Name,
+			AggregateType: event.AggregateType,
+			AggregateID:   event.AggregateID,
+			DistributorID: event.DistributorID,
+			StoreID:       event.StoreID,
+			ActorID:       event.ActorID,
+			Payload:       payload,
+			OccurredAt:    occurredAt,
+		})
+	}
+	return tx.Create(&rows).Error
+}
+
+// GetEventByID returns a stored event.
+func (o *Outbox) GetEventByID(eventID int64) (*Event, error) {
+	var row models.OutboxEvent
+	if err := o.db.First(&row, eventID).Error; err != nil {
+		return nil, err
+	}
+	event := fromOutbox(&row)
+	return &event, nil
+}
+
+// GetDispatchedEventsAfter returns the events of the distributor or the store
+// with one of the names dispatched after the one with the sequence number, in
+// the order they were dispatched. A zero distributor or store ID matches any.
+func (o *Outbox) GetDispatchedEventsAfter(afterSequence int64, names []string, distributorID, storeID int64, limit int) ([]Event, error) {
+	query := o.db.Where("dispatch_seq > ? AND name IN ?", afterSequence, names)
+	if distributorID != 0 {
+		query = query.Where("distributor_id = ?", distributorID)
+	}
+	if storeID != 0 {
+		query = query.Where("store_id = ?", storeID)
+	}
+	var rows []models.OutboxEvent
+	if err := query.
+		Order("dispatch_seq").
+		Limit(limit).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	events := make([]Event, len(rows))
+	for i := range rows {
+		events[i] = fromOutbox(&rows[i])
+	}
+	return events, nil
+}
+
+// Relay dispatches outbox events to the bus at least once, in ID order per
+// aggregate. An event whose subscribers fail is retried with a growing delay
+// and holds back later events of the same aggregate meanwhile. After
+// relayMaxAttempts it is dead-lettered: it keeps its last error and failed_at
+// and no longer holds anything back. Dispatched events are numbered from
+// dispatchSequence under the relay lock, so readers resuming after a number
+// miss none of the events dispatched later.
+type Relay struct {
+	db        *gorm.DB
+	bus       *Bus
+	batchSize int
+}
+
+func NewRelay(db *gorm.DB, bus *Bus, batchSize int) *Relay {
+	return &Relay{db: db, bus: bus, batchSize: batchSize}
+}
+
+// Dispatch relays one batch of pending events and returns how many were
+// delivered. The relay lock is held by one connection for the batch, but every
+// event is marked in a statement of its own, so no transaction stays open while
+// subscribers run. Subscribers that take longer than relayDispatchTimeout
+// count as failed.
+func (r *Relay) Dispatch(ctx context.Context) (int, error) {
+	dispatched := 0
+	err := r.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		conn = conn.Session(&gorm.Session{NewDB: true})
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", relayLockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		// The lock belongs to the connection, which goes back to the pool, so
+		// it is released even when ctx is done.
+		defer conn.WithContext(context.Background()).Exec("SELECT pg_advisory_unlock(?)", relayLockKey)
+
+		// Due events come in ID order, so an earlier event of the aggregate
+		// that is due is in the batch ahead of later ones; one that waits for
+		// its retry holds them back.
+		now := time.Now()
+		var rows []models.OutboxEvent
+		if err := conn.Where("dispatched_at IS NULL AND failed_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", now).
+			Where(`NOT EXISTS (SELECT 1 FROM outbox_events earlier
+				WHERE earlier.aggregate_type = outbox_events.aggregate_type AND earlier.aggregate_id = outbox_events.aggregate_id
+					AND earlier.id < outbox_events.id AND earlier.dispatched_at IS NULL AND earlier.failed_at IS NULL
+					AND earlier.next_attempt_at > ?)`, now).
+			Order("id").
+			Limit(r.batchSize).
+			Find(&rows).Error; err != nil {
+			return err
+		}
+		blocked := make(map[string]bool)
+		for i := range rows {
+			row := &rows[i]
+			aggregate := fmt.Sprintf("%s:%d", row.AggregateType, row.AggregateID)
+			if blocked[aggregate] {
+				continue
+			}
+			var sequence int64
+			if err := conn.Raw("SELECT nextval(?)", dispatchSequence).Scan(&sequence).Error; err != nil {
+				return err
+			}
+			event := fromOutbox(row)
+			event.Sequence = sequence
+			if err := r.dispatch(event); err != nil {
+				blocked[aggregate] = true
+				attempts := row.Attempts + 1
+				updates := map[string]interface{}{
+					"attempts":        attempts,
+					"last_error":      err.Error(),
+					"next_attempt_at": time.Now().Add(relayBackoff(attempts)),
+				}
+				if attempts >= relayMaxAttempts {
+					updates["failed_at"] = time.Now()
+				}
+				if err := conn.Model(row).Updates(updates).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := conn.Model(row).Updates(map[string]interface{}{
+				"attempts":      gorm.Expr("attempts + 1"),
+				"dispatched_at": time.Now(),
+				"dispatch_seq":  sequence,
+			}).Error; err != nil {
+				return err
+			}
+			dispatched++
+		}
+		return nil
+	})
+	return dispatched, err
+}
+
+// dispatch hands the event to the bus and gives up waiting after
+// relayDispatchTimeout. A subscriber still running then may finish later; the
+// event is dispatched again on retry, as delivery is at least once anyway.
+func (r *Relay) dispatch(event Event) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- r.bus.Dispatch(event)
+	}()
+	timer := time.NewTimer(relayDispatchTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("subscribers did not finish within %s", relayDispatchTimeout)
+	}
+}
+
+// relayBackoff doubles the wait after every failed attempt.
+func relayBackoff(attempts int64) time.Duration {
+	backoff := relayBaseBackoff
+	for i := int64(1); i < attempts && backoff < relayMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, relayMaxBackoff)
+}
+
+// Purge removes dispatched events older than before.
+func (r *Relay) Purge(before time.Time) error {
+	return r.db.Where("dispatched_at IS NOT NULL AND dispatched_at < ?", before).Delete(&models.OutboxEvent{}).Error
+}
+
+func fromOutbox(row *models.OutboxEvent) Event {
+	var sequence int64
+	if row.DispatchSeq != nil {
+		sequence = *row.DispatchSeq
+	}
+	return Event{
+		ID:            row.ID,
+		Sequence:      sequence,
+		Name:          row.Name,
+		AggregateType: row.AggregateType,
+		AggregateID:   row.AggregateID,
+		DistributorID: row.DistributorID,
+		StoreID:       row.StoreID,
+		ActorID:       row.ActorID,
+		Payload:       row.Payload,
+		OccurredAt:    row.OccurredAt,
+	}
+}
@@ -0,0 +1,104 @@
+// Package imaging decodes uploaded images and renders the resized variants
+// served to clients.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"github.com/chai2010/webp"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+const (
+	// MaxPixels bounds the decoded size of an image, so a small file cannot
+	// expand into gigabytes of memory.
+	MaxPixels = 40_000_000
+
+	JPEGQuality = 85
+	WebPQuality = 80
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooManyPixels     = errors.New("image dimensions are too large")
+)
+
+// ContentTypes are the accepted upload formats, detected from the file
+// content rather than its name.
+var ContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Decode sniffs and decodes the image. JPEG images are rotated according to
+// their EXIF orientation; the metadata itself is dropped, as encoding only
+// writes pixels.
+func Decode(data []byte) (image.Image, error) {
+	contentType := http.DetectContentType(data)
+	if !ContentTypes[contentType] {
+		return nil, ErrUnsupportedFormat
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if contentType == "image/jpeg" {
+		img = orient(img, exifOrientation(data))
+	}
+	return img, nil
+}
+
+// Fit scales the image down so that its longer side is at most size pixels.
+// Smaller images are not enlarged.
+func Fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+	if width >= height {
+		height = max(1, height*size/width)
+		width = size
+	} else {
+		width = max(1, width*size/height)
+		height = size
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Src, nil)
+	return dst
+}
+
+// EncodeJPEG encodes the image as JPEG. JPEG has no alpha channel, so
+// transparent areas are flattened onto white.
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: JPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeWebP encodes the image as lossy WebP.
+func EncodeWebP(img image.Image) ([]byte, error) {
+	return webp.EncodeRGBA(img, WebPQuality)
+}
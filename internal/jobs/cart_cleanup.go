@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"context"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/services"
+	"time"
+)
+
+// NewCartCleanupJob purges carts that were not touched for idleDays.
+func NewCartCleanupJob(cartService *services.CartService, bus *events.Bus, idleDays int, interval time.Duration) Job {
+	if idleDays <= 0 {
+		interval = 0
+	}
+	return Job{
+		Name:     "cart_cleanup",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			carts, err := cartService.PurgeIdleCarts(time.Now().AddDate(0, 0, -idleDays))
+			for _, cart := range carts {
+				bus.Publish(events.CartPurged, cart)
+			}
+			return err
+		},
+	}
+}
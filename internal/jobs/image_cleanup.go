@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"context"
+	"marketplace-api/internal/services"
+	"time"
+)
+
+// NewImageCleanupJob deletes uploaded images that nothing refers to ttl after
+// their upload.
+func NewImageCleanupJob(imageService *services.ImageService, ttl, interval time.Duration) Job {
+	if ttl <= 0 {
+		interval = 0
+	}
+	return Job{
+		Name:     "image_cleanup",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			_, err := imageService.CollectOrphans(time.Now().Add(-ttl))
+			return err
+		},
+	}
+}
@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"context"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/services"
+	"time"
+)
+
+// NewOrderExpiryJob cancels orders left unconfirmed past the distributor's SLA and restores their stock.
+func NewOrderExpiryJob(orderService *services.OrderService, bus *events.Bus, defaultSLA, interval time.Duration) Job {
+	return Job{
+		Name:     "order_expiry",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			orders, err := orderService.ExpireUnconfirmedOrders(defaultSLA, time.Now())
+			for _, order := range orders {
+				bus.Publish(events.OrderExpired, order)
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"context"
+	"marketplace-api/internal/events"
+	"time"
+)
+
+// NewOutboxRelayJob dispatches pending outbox events to the bus subscribers and
+// removes dispatched events older than the retention.
+func NewOutboxRelayJob(relay *events.Relay, retention, interval time.Duration) Job {
+	return Job{
+		Name:     "outbox_relay",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			for {
+				dispatched, err := relay.Dispatch(ctx)
+				if err != nil {
+					return err
+				}
+				if dispatched == 0 {
+					break
+				}
+			}
+			if retention <= 0 {
+				return nil
+			}
+			return relay.Purge(time.Now().Add(-retention))
+		},
+	}
+}
@@ -0,0 +1,20 @@
+package jobs
+
+import (
+	"context"
+	"marketplace-api/internal/services"
+	"time"
+)
+
+// NewPayoutStatementsJob issues the payout statements of the last period once
+// it is over.
+func NewPayoutStatementsJob(payoutService *services.PayoutService, interval time.Duration) Job {
+	return Job{
+		Name:     "payout_statements",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			_, err := payoutService.IssueStatements(time.Now())
+			return err
+		},
+	}
+}
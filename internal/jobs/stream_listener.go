@@ -0,0 +1,17 @@
+package jobs
+
+import (
+	"marketplace-api/internal/stream"
+	"time"
+)
+
+// NewStreamListenerJob receives the events announced by the outbox relay of any
+// API instance and pushes them to the streams connected to this one. The
+// listener runs until shutdown and is restarted after interval if it fails.
+func NewStreamListenerJob(listener *stream.Listener, interval time.Duration) Job {
+	return Job{
+		Name:     "stream_listener",
+		Interval: interval,
+		Run:      listener.Run,
+	}
+}
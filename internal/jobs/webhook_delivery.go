@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"context"
+	"marketplace-api/internal/services"
+	"time"
+)
+
+// NewWebhookDeliveryJob sends due webhook deliveries and schedules retries of failed ones.
+func NewWebhookDeliveryJob(webhookService *services.WebhookService, interval time.Duration) Job {
+	return Job{
+		Name:     "webhook_delivery",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			for {
+				sent, err := webhookService.DeliverDue(ctx)
+				if err != nil || sent == 0 {
+					return err
+				}
+			}
+		},
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // Cart model info
 type Cart struct {
 	ID         int64      `json:"id" gorm:"primaryKey"`
@@ -7,13 +9,36 @@ type Cart struct {
 	Store      Store      `gorm:"foreignKey:StoreID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
 	Items      []CartItem `json:"items" gorm:"foreignKey:CartID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	TotalPrice float64    `json:"total_price"`
+	CouponCode string     `json:"coupon_code"`
+	// DiscountAmount is the total the promotions take off the items.
+	DiscountAmount float64   `json:"discount_amount" gorm:"-"`
+	NetAmount      float64   `json:"net_amount" gorm:"-"`
+	TaxAmount      float64   `json:"tax_amount" gorm:"-"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 // CartItem model info
 type CartItem struct {
-	ID        int64   `json:"id" gorm:"primaryKey"`
-	CartID    int64   `json:"cart_id"`
-	ProductID int64   `json:"product_id"`
-	Quantity  int64   `json:"quantity"`
-	Product   Product `json:"product" gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ID           int64   `json:"id" gorm:"primaryKey"`
+	CartID       int64   `json:"cart_id"`
+	ProductID    int64   `json:"product_id"`
+	Quantity     int64   `json:"quantity"`
+	Product      Product `json:"product" gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	LineDiscount `gorm:"-"`
+	TaxBreakdown `gorm:"-"`
+}
+
+// CalculateTax fills the tax breakdown of the items after their discounts,
+// whose products and distributors must be loaded, and sets the totals of the
+// cart from them.
+func (c *Cart) CalculateTax() {
+	c.NetAmount, c.TaxAmount, c.TotalPrice, c.DiscountAmount = 0, 0, 0, 0
+	for i := range c.Items {
+		item := &c.Items[i]
+		item.TaxBreakdown = ProductTax(&item.Product, item.Quantity, item.DiscountAmount)
+		c.DiscountAmount = RoundMoney(c.DiscountAmount + item.DiscountAmount)
+		c.NetAmount = RoundMoney(c.NetAmount + item.NetAmount)
+		c.TaxAmount = RoundMoney(c.TaxAmount + item.TaxAmount)
+		c.TotalPrice = RoundMoney(c.TotalPrice + item.GrossAmount)
+	}
 }
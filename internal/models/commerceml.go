@@ -0,0 +1,319 @@
+package models
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommerceML 2 documents exchanged with 1C. Only the elements the marketplace
+// uses are mapped.
+
+const (
+	CommerceMLVersion  = "2.05"
+	CommerceMLCurrency = "KZT"
+	CommerceMLVAT      = "НДС"
+
+	// CommerceMLStatusProperty and CommerceMLCancelledProperty are the order
+	// properties 1C uses for the order status and cancellation.
+	CommerceMLStatusProperty    = "Статус заказа"
+	CommerceMLCancelledProperty = "Отменен"
+	CommerceMLCancelled         = "cancelled"
+)
+
+// OrderStages lists the stages of an order in the order they are passed.
+var OrderStages = []string{StageNew, StageConfirmed, StageProcessing, StageShipped, StageSuccess}
+
+// commerceMLStatuses maps order statuses used by 1C configurations to stages.
+var commerceMLStatuses = map[string]string{
+	StageNew:        StageNew,
+	StageConfirmed:  StageConfirmed,
+	StageProcessing: StageProcessing,
+	StageShipped:    StageShipped,
+	StageSuccess:    StageSuccess,
+	"новый":         StageNew,
+	"подтвержден":   StageConfirmed,
+	"в обработке":   StageProcessing,
+	"в работе":      StageProcessing,
+	"собран":        StageProcessing,
+	"отгружен":      StageShipped,
+	"в пути":        StageShipped,
+	"доставлен":     StageSuccess,
+	"выполнен":      StageSuccess,
+	"закрыт":        StageSuccess,
+	"отменен":       CommerceMLCancelled,
+	"аннулирован":   CommerceMLCancelled,
+	"canceled":      CommerceMLCancelled,
+	"cancelled":     CommerceMLCancelled,
+}
+
+// StageIndex returns the position of the stage in OrderStages or -1.
+func StageIndex(stage string) int {
+	for i, s := range OrderStages {
+		if s == stage {
+			return i
+		}
+	}
+	return -1
+}
+
+type CommerceMLGroup struct {
+	ID     string            `xml:"Ид"`
+	Name   string            `xml:"Наименование"`
+	Groups []CommerceMLGroup `xml:"Группы>Группа"`
+}
+
+type CommerceMLProduct struct {
+	ID          string   `xml:"Ид"`
+	SKU         string   `xml:"Артикул"`
+	Name        string   `xml:"Наименование"`
+	Description string   `xml:"Описание"`
+	Groups      []string `xml:"Группы>Ид"`
+	Status      string   `xml:"Статус,attr"`
+}
+
+// CommerceMLImport is the catalog file, import.xml.
+type CommerceMLImport struct {
+	XMLName  xml.Name            `xml:"КоммерческаяИнформация"`
+	Groups   []CommerceMLGroup   `xml:"Классификатор>Группы>Группа"`
+	Products []CommerceMLProduct `xml:"Каталог>Товары>Товар"`
+}
+
+// GroupNames flattens the group tree into names by id.
+func (ci *CommerceMLImport) GroupNames() map[string]string {
+	names := make(map[string]string)
+	var walk func(groups []CommerceMLGroup)
+	walk = func(groups []CommerceMLGroup) {
+		for _, group := range groups {
+			names[group.ID] = strings.TrimSpace(group.Name)
+			walk(group.Groups)
+		}
+	}
+	walk(ci.Groups)
+	return names
+}
+
+type CommerceMLOffer struct {
+	ID     string `xml:"Ид"`
+	SKU    string `xml:"Артикул"`
+	Prices []struct {
+		PriceTypeID string `xml:"ИдТипаЦены"`
+		Price       string `xml:"ЦенаЗаЕдиницу"`
+	} `xml:"Цены>Цена"`
+	Quantity   string `xml:"Количество"`
+	Warehouses []struct {
+		Quantity string `xml:"КоличествоНаСкладе,attr"`
+	} `xml:"Склад"`
+}
+
+// ProductID returns the catalog id of the offered product. Offers of product
+// characteristics have ids in the form "product#characteristic".
+func (o *CommerceMLOffer) ProductID() string {
+	id, _, _ := strings.Cut(o.ID, "#")
+	return id
+}
+
+// Price returns the first price of the offer.
+func (o *CommerceMLOffer) Price() (float64, bool) {
+	for _, price := range o.Prices {
+		if value, err := parseCommerceMLNumber(price.Price); err == nil {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// Stock returns the offered quantity, summing warehouse quantities when the
+// total is not given.
+func (o *CommerceMLOffer) Stock() (int64, bool) {
+	if value, err := parseCommerceMLNumber(o.Quantity); err == nil {
+		return int64(value), true
+	}
+	var total float64
+	found := false
+	for _, warehouse := range o.Warehouses {
+		if value, err := parseCommerceMLNumber(warehouse.Quantity); err == nil {
+			total += value
+			found = true
+		}
+	}
+	return int64(total), found
+}
+
+// CommerceMLOffers is the price and stock file, offers.xml.
+type CommerceMLOffers struct {
+	XMLName xml.Name          `xml:"КоммерческаяИнформация"`
+	Offers  []CommerceMLOffer `xml:"ПакетПредложений>Предложения>Предложение"`
+}
+
+type CommerceMLProperty struct {
+	Name  string `xml:"Наименование"`
+	Value string `xml:"Значение"`
+}
+
+type CommerceMLContact struct {
+	Type  string `xml:"Тип"`
+	Value string `xml:"Значение"`
+}
+
+type CommerceMLCounterparty struct {
+	ID       string              `xml:"Ид"`
+	Name     string              `xml:"Наименование"`
+	Role     string              `xml:"Роль"`
+	FullName string              `xml:"ПолноеНаименование"`
+	BIN      string              `xml:"ИНН,omitempty"`
+	Address  string              `xml:"АдресРегистрации>Представление,omitempty"`
+	Contacts []CommerceMLContact `xml:"Контакты>Контакт"`
+}
+
+type CommerceMLOrderLine struct {
+	ID        string              `xml:"Ид"`
+	SKU       string              `xml:"Артикул,omitempty"`
+	Name      string              `xml:"Наименование"`
+	Unit      string              `xml:"БазоваяЕдиница"`
+	UnitPrice float64             `xml:"ЦенаЗаЕдиницу"`
+	Quantity  int64               `xml:"Количество"`
+	Total     float64             `xml:"Сумма"`
+	Taxes     []CommerceMLTax     `xml:"Налоги>Налог,omitempty"`
+	TaxRates  []CommerceMLTaxRate `xml:"СтавкиНалогов>СтавкаНалога,omitempty"`
+}
+
+// CommerceMLTax is the VAT included in the sum of a document or line.
+type CommerceMLTax struct {
+	Name     string  `xml:"Наименование"`
+	Included bool    `xml:"УчтеноВСумме"`
+	Amount   float64 `xml:"Сумма"`
+}
+
+type CommerceMLTaxRate struct {
+	Name string  `xml:"Наименование"`
+	Rate float64 `xml:"Ставка"`
+}
+
+type CommerceMLDocument struct {
+	ID             string                   `xml:"Ид"`
+	Number         string                   `xml:"Номер"`
+	Date           string                   `xml:"Дата"`
+	Time           string                   `xml:"Время"`
+	Operation      string                   `xml:"ХозОперация"`
+	Role           string                   `xml:"Роль"`
+	Currency       string                   `xml:"Валюта"`
+	Rate           int                      `xml:"Курс"`
+	Total          float64                  `xml:"Сумма"`
+	Counterparties []CommerceMLCounterparty `xml:"Контрагенты>Контрагент"`
+	Comment        string                   `xml:"Комментарий,omitempty"`
+	Taxes          []CommerceMLTax          `xml:"Налоги>Налог,omitempty"`
+	Lines          []CommerceMLOrderLine    `xml:"Товары>Товар"`
+	Properties     []CommerceMLProperty     `xml:"ЗначенияРеквизитов>ЗначениеРеквизита"`
+}
+
+// Property returns the value of the named order property.
+func (d *CommerceMLDocument) Property(name string) (string, bool) {
+	for _, property := range d.Properties {
+		if strings.EqualFold(strings.TrimSpace(property.Name), name) {
+			return strings.TrimSpace(property.Value), true
+		}
+	}
+	return "", false
+}
+
+// OrderID returns the marketplace order id of the document.
+func (d *CommerceMLDocument) OrderID() (int64, error) {
+	id := strings.TrimSpace(d.ID)
+	if id == "" {
+		id = strings.TrimSpace(d.Number)
+	}
+	return strconv.ParseInt(id, 10, 64)
+}
+
+// TargetStage returns the stage the document moves the order to, or
+// CommerceMLCancelled.
+func (d *CommerceMLDocument) TargetStage() (string, bool) {
+	if cancelled, ok := d.Property(CommerceMLCancelledProperty); ok && strings.EqualFold(cancelled, "true") {
+		return CommerceMLCancelled, true
+	}
+	status, ok := d.Property(CommerceMLStatusProperty)
+	if !ok {
+		return "", false
+	}
+	stage, ok := commerceMLStatuses[strings.ToLower(status)]
+	return stage, ok
+}
+
+// CommerceMLOrders is the orders file, orders.xml, in both directions.
+type CommerceMLOrders struct {
+	XMLName   xml.Name             `xml:"КоммерческаяИнформация"`
+	Version   string               `xml:"ВерсияСхемы,attr"`
+	CreatedAt string               `xml:"ДатаФормирования,attr"`
+	Documents []CommerceMLDocument `xml:"Документ"`
+}
+
+// NewCommerceMLDocument describes a marketplace order as a 1C order document.
+func NewCommerceMLDocument(order *Order) CommerceMLDocument {
+	productID := order.Snapshot.SKU
+	if order.Product.ExternalID != "" {
+		productID = order.Product.ExternalID
+	} else if order.ProductID != nil {
+		productID = strconv.FormatInt(*order.ProductID, 10)
+	}
+	address := strings.TrimSpace(strings.Join([]string{order.City, order.Address}, ", "))
+	address = strings.Trim(address, ", ")
+	contacts := []CommerceMLContact{}
+	if order.ContactPhone != "" {
+		contacts = append(contacts, CommerceMLContact{Type: "Телефон рабочий", Value: order.ContactPhone})
+	}
+	if order.StoreEmail != "" {
+		contacts = append(contacts, CommerceMLContact{Type: "Почта", Value: order.StoreEmail})
+	}
+	cancelled := order.Stage.Status == StageStatusError
+	var taxes []CommerceMLTax
+	var taxRates []CommerceMLTaxRate
+	if order.TaxRate > 0 {
+		taxes = []CommerceMLTax{{Name: CommerceMLVAT, Included: true, Amount: order.TaxAmount}}
+		taxRates = []CommerceMLTaxRate{{Name: CommerceMLVAT, Rate: order.TaxRate}}
+	}
+
+	return CommerceMLDocument{
+		ID:        strconv.FormatInt(order.ID, 10),
+		Number:    order.Number,
+		Date:      order.Timestamp.Format(time.DateOnly),
+		Time:      order.Timestamp.Format(time.TimeOnly),
+		Operation: "Заказ товара",
+		Role:      "Продавец",
+		Currency:  CommerceMLCurrency,
+		Rate:      1,
+		Total:     order.TotalPrice,
+		Counterparties: []CommerceMLCounterparty{{
+			ID:       strconv.FormatInt(order.StoreID, 10),
+			Name:     order.Store.Name,
+			Role:     "Покупатель",
+			FullName: order.Store.CompanyName,
+			BIN:      order.Store.BIN,
+			Address:  address,
+			Contacts: contacts,
+		}},
+		Comment: strings.TrimSpace(order.AddressLabel + " " + order.ContactName),
+		Taxes:   taxes,
+		Lines: []CommerceMLOrderLine{{
+			ID:        productID,
+			SKU:       order.Snapshot.SKU,
+			Name:      order.Snapshot.ProductName,
+			Unit:      "шт",
+			UnitPrice: order.Snapshot.UnitPrice,
+			Quantity:  order.Quantity,
+			Total:     order.TotalPrice,
+			Taxes:     taxes,
+			TaxRates:  taxRates,
+		}},
+		Properties: []CommerceMLProperty{
+			{Name: CommerceMLStatusProperty, Value: order.Stage.Stage},
+			{Name: CommerceMLCancelledProperty, Value: strconv.FormatBool(cancelled)},
+		},
+	}
+}
+
+func parseCommerceMLNumber(value string) (float64, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), " ", "")
+	return strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	validator "marketplace-api/internal/util"
+	"time"
+)
+
+const (
+	// CreditActionBlock rejects checkouts that would exceed the credit limit.
+	CreditActionBlock = "block"
+	// CreditActionFlag accepts them and puts their orders on credit hold for
+	// the distributor to review.
+	CreditActionFlag = "flag"
+)
+
+// CreditAccount model info
+// A distributor grants a store trade credit: orders are paid within the
+// payment terms, and the unpaid balance of the store's invoices may not
+// exceed the credit limit. Stores without an account pay on the default
+// terms with no limit.
+type CreditAccount struct {
+	DistributorID    int64       `json:"distributor_id" gorm:"primaryKey"`
+	Distributor      Distributor `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	StoreID          int64       `json:"store_id" gorm:"primaryKey"`
+	Store            Store       `gorm:"foreignKey:StoreID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	CreditLimit      float64     `json:"credit_limit"`
+	PaymentTermsDays int         `json:"payment_terms_days"`
+	OverLimitAction  string      `json:"over_limit_action" gorm:"not null;default:'block'"`
+	Outstanding      float64     `json:"outstanding" gorm:"->;-:migration"`
+	Available        float64     `json:"available" gorm:"-"`
+	StoreName        string      `json:"store_name,omitempty" gorm:"->;-:migration"`
+	DistributorName  string      `json:"distributor_name,omitempty" gorm:"->;-:migration"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// Exceeds reports whether adding amount to the outstanding balance goes over
+// the credit limit.
+func (ca *CreditAccount) Exceeds(outstanding, amount float64) bool {
+	return RoundMoney(outstanding+amount) > ca.CreditLimit
+}
+
+type CreditAccountInput struct {
+	CreditLimit      float64 `json:"credit_limit"`
+	PaymentTermsDays int     `json:"payment_terms_days"`
+	OverLimitAction  string  `json:"over_limit_action"`
+}
+
+func ValidateCreditAccount(v *validator.Validator, account *CreditAccount) {
+	v.Check(account.CreditLimit >= 0, "credit_limit", "must not be negative")
+	v.Check(RoundMoney(account.CreditLimit) == account.CreditLimit, "credit_limit", "must not have more than 2 decimal places")
+	v.Check(account.PaymentTermsDays >= 0 && account.PaymentTermsDays <= 365, "payment_terms_days", "must be between 0 and 365")
+	v.Check(validator.In(account.OverLimitAction, CreditActionBlock, CreditActionFlag), "over_limit_action", "must be block or flag")
+}
+
+// AgingBuckets splits unpaid invoice balances by how many days they are past
+// due.
+type AgingBuckets struct {
+	Current    float64 `json:"current" gorm:"column:current"`
+	Days1To30  float64 `json:"days_1_30" gorm:"column:days_1_30"`
+	Days31To60 float64 `json:"days_31_60" gorm:"column:days_31_60"`
+	Days61To90 float64 `json:"days_61_90" gorm:"column:days_61_90"`
+	Over90     float64 `json:"over_90" gorm:"column:over_90"`
+	Total      float64 `json:"total" gorm:"column:total"`
+}
+
+// Add adds the buckets of b to a.
+func (a *AgingBuckets) Add(b AgingBuckets) {
+	a.Current = RoundMoney(a.Current + b.Current)
+	a.Days1To30 = RoundMoney(a.Days1To30 + b.Days1To30)
+	a.Days31To60 = RoundMoney(a.Days31To60 + b.Days31To60)
+	a.Days61To90 = RoundMoney(a.Days61To90 + b.Days61To90)
+	a.Over90 = RoundMoney(a.Over90 + b.Over90)
+	a.Total = RoundMoney(a.Total + b.Total)
+}
+
+// StoreAging is one store's row of the accounts receivable aging report.
+type StoreAging struct {
+	StoreID      int64   `json:"store_id"`
+	StoreName    string  `json:"store_name"`
+	CreditLimit  float64 `json:"credit_limit"`
+	AgingBuckets `gorm:"embedded"`
+}
+
+// AgingReport is the accounts receivable aging of a distributor.
+type AgingReport struct {
+	AsOf   time.Time    `json:"as_of"`
+	Stores []StoreAging `json:"stores"`
+	Totals AgingBuckets `json:"totals"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	validator "marketplace-api/internal/util"
+	"time"
+)
+
+const (
+	DeliveryDateLayout = "2006-01-02"
+	DeliveryTimeLayout = "15:04"
+)
+
+// DeliverySlot model info
+type DeliverySlot struct {
+	ID            int64        `json:"id" gorm:"primaryKey"`
+	DistributorID int64        `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor  `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	City          string       `json:"city" gorm:"not null"`
+	Weekday       time.Weekday `json:"weekday"`
+	StartTime     string       `json:"start_time"`
+	EndTime       string       `json:"end_time"`
+	Capacity      int64        `json:"capacity"`
+	CutoffHours   int64        `json:"cutoff_hours"`
+	Active        bool         `json:"active"`
+}
+
+// DeliverySlotUsage model info
+type DeliverySlotUsage struct {
+	ID     int64        `json:"id" gorm:"primaryKey"`
+	SlotID int64        `json:"slot_id" gorm:"not null;uniqueIndex:idx_slot_date"`
+	Slot   DeliverySlot `gorm:"foreignKey:SlotID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Date   string       `json:"date" gorm:"type:date;not null;uniqueIndex:idx_slot_date"`
+	Booked int64        `json:"booked"`
+}
+
+// DeliverySlotChoice is the slot and date a store picks for a distributor at checkout
+type DeliverySlotChoice struct {
+	SlotID int64  `json:"slot_id"`
+	Date   string `json:"date"`
+}
+
+// DeliveryWindow is a DeliverySlot on a concrete date
+type DeliveryWindow struct {
+	SlotID        int64     `json:"slot_id"`
+	DistributorID int64     `json:"distributor_id"`
+	City          string    `json:"city"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Remaining     int64     `json:"remaining"`
+}
+
+// Window returns the occurrence of the slot on the given date.
+func (s *DeliverySlot) Window(date time.Time) (*DeliveryWindow, error) {
+	start, err := time.ParseInLocation(DeliveryTimeLayout, s.StartTime, time.Local)
+	if err != nil {
+		return nil, errors.New("invalid slot start time")
+	}
+	end, err := time.ParseInLocation(DeliveryTimeLayout, s.EndTime, time.Local)
+	if err != nil {
+		return nil, errors.New("invalid slot end time")
+	}
+	y, m, d := date.Date()
+	return &DeliveryWindow{
+		SlotID:        s.ID,
+		DistributorID: s.DistributorID,
+		City:          s.City,
+		Start:         time.Date(y, m, d, start.Hour(), start.Minute(), 0, 0, time.Local),
+		End:           time.Date(y, m, d, end.Hour(), end.Minute(), 0, 0, time.Local),
+	}, nil
+}
+
+// Cutoff returns the last moment the window can still be booked.
+func (s *DeliverySlot) Cutoff(window *DeliveryWindow) time.Time {
+	return window.Start.Add(-time.Duration(s.CutoffHours) * time.Hour)
+}
+
+func ValidateDeliverySlot(v *validator.Validator, slot *DeliverySlot) {
+	v.Check(slot.City != "", "city", "must be provided")
+	v.Check(slot.Weekday >= time.Sunday && slot.Weekday <= time.Saturday, "weekday", "must be between 0 (sunday) and 6 (saturday)")
+	start, startErr := time.Parse(DeliveryTimeLayout, slot.StartTime)
+	v.Check(startErr == nil, "start_time", "must be in HH:MM format")
+	end, endErr := time.Parse(DeliveryTimeLayout, slot.EndTime)
+	v.Check(endErr == nil, "end_time", "must be in HH:MM format")
+	if startErr == nil && endErr == nil {
+		v.Check(start.Before(end), "end_time", "must be after start_time")
+	}
+	v.Check(slot.Capacity > 0, "capacity", "must be greater than zero")
+	v.Check(slot.CutoffHours >= 0, "cutoff_hours", "must not be negative")
+}
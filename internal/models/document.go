@@ -0,0 +1,59 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DocumentInvoice = "invoice"
+	DocumentWaybill = "waybill"
+)
+
+var DocumentKinds = []string{DocumentInvoice, DocumentWaybill}
+
+var documentPrefixes = map[string]string{DocumentInvoice: "INV", DocumentWaybill: "WB"}
+
+// Document model info
+// A document is the PDF of an invoice or a waybill, rendered once when it is
+// first requested and served as issued from then on. The PDF is kept in the
+// blob store under Key. Numbers run per distributor and kind.
+type Document struct {
+	ID            int64     `json:"id" gorm:"primaryKey"`
+	DistributorID int64     `json:"distributor_id" gorm:"not null;uniqueIndex:idx_documents_number"`
+	Kind          string    `json:"kind" gorm:"not null;uniqueIndex:idx_documents_number;uniqueIndex:idx_documents_invoice"`
+	Number        int64     `json:"number" gorm:"not null;uniqueIndex:idx_documents_number"`
+	InvoiceID     int64     `json:"invoice_id" gorm:"not null;uniqueIndex:idx_documents_invoice"`
+	Invoice       Invoice   `gorm:"foreignKey:InvoiceID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	StoreID       int64     `json:"store_id" gorm:"not null;index"`
+	FileName      string    `json:"file_name"`
+	Key           string    `json:"-" gorm:"not null;default:''"`
+	Size          int64     `json:"size"`
+	Checksum      string    `json:"checksum"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// DisplayNumber returns the number as printed on the document.
+func (d *Document) DisplayNumber() string {
+	return DocumentNumber(d.Kind, d.Number)
+}
+
+// DocumentKey returns the blob store key of the PDF of the document. The token
+// keeps keys from being guessed, as stores may serve objects publicly.
+func DocumentKey(document *Document, token string) string {
+	return fmt.Sprintf("documents/%d/%s-%s.pdf", document.DistributorID, document.DisplayNumber(), token)
+}
+
+// DocumentNumber formats the number of a document of the kind.
+func DocumentNumber(kind string, number int64) string {
+	return fmt.Sprintf("%s-%06d", documentPrefixes[kind], number)
+}
+
+// Sequence model info
+// Sequences hand out gap-free numbers per distributor; a number is taken in
+// the transaction that uses it, so rolled back transactions give it back.
+type Sequence struct {
+	DistributorID int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name          string `gorm:"primaryKey"`
+	LastValue     int64  `gorm:"not null"`
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"github.com/lib/pq"
+	validator "marketplace-api/internal/util"
+	"strings"
+	"time"
+)
+
+const (
+	MovementReceipt      = "receipt"
+	MovementReservation  = "reservation"
+	MovementSale         = "sale"
+	MovementCancellation = "cancellation"
+	MovementReturn       = "return"
+	MovementAdjustment   = "adjustment"
+)
+
+// Warehouse model info
+type Warehouse struct {
+	ID            int64          `json:"id" gorm:"primaryKey"`
+	DistributorID int64          `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor    `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Name          string         `json:"name" gorm:"not null"`
+	City          string         `json:"city" gorm:"not null"`
+	Address       string         `json:"address"`
+	Cities        pq.StringArray `json:"cities" gorm:"type:text[]"`
+	Active        bool           `json:"active"`
+}
+
+// StockMovement model info. Movements are append-only; on-hand and reserved
+// quantities are the sums of their deltas per warehouse and product.
+type StockMovement struct {
+	ID            int64     `json:"id" gorm:"primaryKey"`
+	WarehouseID   int64     `json:"warehouse_id" gorm:"not null;index"`
+	Warehouse     Warehouse `gorm:"foreignKey:WarehouseID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;" json:"-"`
+	ProductID     int64     `json:"product_id" gorm:"not null;index"`
+	Product       Product   `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;" json:"-"`
+	OrderID       *int64    `json:"order_id,omitempty" gorm:"index"`
+	Type          string    `json:"type" gorm:"not null"`
+	OnHandDelta   int64     `json:"on_hand_delta"`
+	ReservedDelta int64     `json:"reserved_delta"`
+	Note          string    `json:"note"`
+	CreatedBy     int64     `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// StockLevel is the derived stock of a product in a warehouse
+type StockLevel struct {
+	WarehouseID int64 `json:"warehouse_id"`
+	ProductID   int64 `json:"product_id"`
+	OnHand      int64 `json:"on_hand"`
+	Reserved    int64 `json:"reserved"`
+	Available   int64 `json:"available"`
+}
+
+type StockMovementInput struct {
+	WarehouseID int64  `json:"warehouse_id"`
+	ProductID   int64  `json:"product_id"`
+	OrderID     *int64 `json:"order_id"`
+	Type        string `json:"type"`
+	Quantity    int64  `json:"quantity"`
+	Note        string `json:"note"`
+}
+
+// Serves reports whether the warehouse delivers to the city. A warehouse
+// without a list of cities serves every city.
+func (w *Warehouse) Serves(city string) bool {
+	if len(w.Cities) == 0 {
+		return true
+	}
+	for _, c := range append([]string{w.City}, w.Cities...) {
+		if strings.EqualFold(c, city) {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateWarehouse(v *validator.Validator, warehouse *Warehouse) {
+	v.Check(warehouse.Name != "", "name", "must be provided")
+	v.Check(warehouse.City != "", "city", "must be provided")
+	v.Check(validator.Unique(warehouse.Cities), "cities", "must not contain duplicate values")
+}
+
+func ValidateStockMovementInput(v *validator.Validator, input *StockMovementInput) {
+	v.Check(input.WarehouseID > 0, "warehouse_id", "must be provided")
+	v.Check(input.ProductID > 0, "product_id", "must be provided")
+	v.Check(validator.In(input.Type, MovementReceipt, MovementReturn, MovementAdjustment), "type", "must be receipt, return or adjustment")
+	if input.Type == MovementAdjustment {
+		v.Check(input.Quantity != 0, "quantity", "must not be zero")
+	} else {
+		v.Check(input.Quantity > 0, "quantity", "must be greater than zero")
+	}
+	v.Check(input.Type != MovementAdjustment || input.Note != "", "note", "must explain the adjustment")
+}
+
+// ServingWarehouses returns the active warehouses that deliver to the city,
+// the ones located in the city first.
+func ServingWarehouses(warehouses []Warehouse, city string) []Warehouse {
+	var local, others []Warehouse
+	for _, warehouse := range warehouses {
+		if !warehouse.Active || !warehouse.Serves(city) {
+			continue
+		}
+		if strings.EqualFold(warehouse.City, city) {
+			local = append(local, warehouse)
+		} else {
+			others = append(others, warehouse)
+		}
+	}
+	return append(local, others...)
+}
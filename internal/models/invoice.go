@@ -0,0 +1,121 @@
+package models
+
+import (
+	validator "marketplace-api/internal/util"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	PaymentStatusUnpaid        = "unpaid"
+	PaymentStatusPartiallyPaid = "partially_paid"
+	PaymentStatusPaid          = "paid"
+	PaymentStatusOverdue       = "overdue"
+	// PaymentStatusVoid invoices have nothing to pay, as all their orders
+	// were cancelled, and no payment left to refund.
+	PaymentStatusVoid = "void"
+
+	PaymentKindPayment = "payment"
+	PaymentKindRefund  = "refund"
+)
+
+// PaymentStatuses are the payment statuses orders and invoices can be
+// filtered by.
+var PaymentStatuses = []string{PaymentStatusUnpaid, PaymentStatusPartiallyPaid, PaymentStatusPaid, PaymentStatusOverdue, PaymentStatusVoid}
+
+var PaymentMethods = []string{"cash", "bank_transfer", "card", "other"}
+
+// Invoice model info
+// An invoice bills the orders of one checkout with one distributor, or orders
+// the distributor invoices together. Amount is the gross total of its orders
+// that are not cancelled, broken down into NetAmount and TaxAmount; PaidAmount
+// is the sum of payments less refunds.
+type Invoice struct {
+	ID            int64       `json:"id" gorm:"primaryKey"`
+	DistributorID int64       `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	StoreID       int64       `json:"store_id" gorm:"not null;index"`
+	Store         Store       `gorm:"foreignKey:StoreID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Amount        float64     `json:"amount"`
+	NetAmount     float64     `json:"net_amount"`
+	TaxAmount     float64     `json:"tax_amount"`
+	PaidAmount    float64     `json:"paid_amount"`
+	Balance       float64     `json:"balance" gorm:"-"`
+	PaymentStatus string      `json:"payment_status" gorm:"-"`
+	IssuedAt      time.Time   `json:"issued_at"`
+	DueAt         time.Time   `json:"due_at" gorm:"index"`
+	PaidAt        *time.Time  `json:"paid_at"`
+	Orders        []Order     `json:"orders,omitempty" gorm:"foreignKey:InvoiceID"`
+	Payments      []Payment   `json:"payments,omitempty" gorm:"foreignKey:InvoiceID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// Settle fills Balance and PaymentStatus as of now. Negative balances are
+// overpayments owed back to the store.
+func (i *Invoice) Settle(now time.Time) {
+	i.Balance = RoundMoney(i.Amount - i.PaidAmount)
+	switch {
+	case i.Amount == 0 && i.PaidAmount == 0:
+		i.PaymentStatus = PaymentStatusVoid
+	case i.Balance <= 0:
+		i.PaymentStatus = PaymentStatusPaid
+	case now.After(i.DueAt):
+		i.PaymentStatus = PaymentStatusOverdue
+	case i.PaidAmount > 0:
+		i.PaymentStatus = PaymentStatusPartiallyPaid
+	default:
+		i.PaymentStatus = PaymentStatusUnpaid
+	}
+}
+
+// Payment model info
+// Refunds are recorded as payments of the refund kind and reduce the paid
+// amount of the invoice.
+type Payment struct {
+	ID         int64     `json:"id" gorm:"primaryKey"`
+	InvoiceID  int64     `json:"invoice_id" gorm:"not null;index"`
+	Kind       string    `json:"kind" gorm:"not null"`
+	Amount     float64   `json:"amount"`
+	Method     string    `json:"method"`
+	Reference  string    `json:"reference"`
+	PaidAt     time.Time `json:"paid_at"`
+	RecordedBy int64     `json:"recorded_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// InvoiceInput lists the orders a distributor invoices together.
+type InvoiceInput struct {
+	OrderIDs []int64 `json:"order_ids"`
+}
+
+type PaymentInput struct {
+	Kind      string     `json:"kind"`
+	Amount    float64    `json:"amount"`
+	Method    string     `json:"method"`
+	Reference string     `json:"reference"`
+	PaidAt    *time.Time `json:"paid_at"`
+}
+
+func ValidateInvoice(v *validator.Validator, input *InvoiceInput) {
+	v.Check(len(input.OrderIDs) > 0, "order_ids", "must be provided")
+	v.Check(len(input.OrderIDs) <= 100, "order_ids", "must not contain more than 100 orders")
+	seen := make(map[int64]bool, len(input.OrderIDs))
+	for _, orderID := range input.OrderIDs {
+		v.Check(!seen[orderID], "order_ids", "must not contain duplicate values")
+		seen[orderID] = true
+	}
+}
+
+func ValidatePayment(v *validator.Validator, input *PaymentInput) {
+	v.Check(validator.In(input.Kind, PaymentKindPayment, PaymentKindRefund), "kind", "must be payment or refund")
+	v.Check(input.Amount > 0, "amount", "must be greater than zero")
+	v.Check(RoundMoney(input.Amount) == input.Amount, "amount", "must not have more than 2 decimal places")
+	v.Check(validator.In(input.Method, PaymentMethods...), "method", "must be one of "+strings.Join(PaymentMethods, ", "))
+	v.Check(len(input.Reference) <= 200, "reference", "must not be more than 200 bytes long")
+	v.Check(input.PaidAt == nil || !input.PaidAt.After(time.Now()), "paid_at", "must not be in the future")
+}
+
+// RoundMoney rounds an amount to whole tiyn.
+func RoundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
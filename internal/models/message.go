@@ -0,0 +1,98 @@
+package models
+
+import (
+	"github.com/lib/pq"
+	validator "marketplace-api/internal/util"
+	"strings"
+	"time"
+)
+
+const (
+	MaxMessageLength      = 4000
+	MaxMessageAttachments = 10
+)
+
+// ModerationReasons are the reasons admins give for hiding content.
+var ModerationReasons = []string{"spam", "abuse", "personal_data", "off_topic", "other"}
+
+// MessageThread model info. A thread is a conversation between a store and a
+// distributor, optionally about one of their orders or one of the
+// distributor's products.
+type MessageThread struct {
+	ID            int64       `json:"id" gorm:"primaryKey"`
+	StoreID       int64       `json:"store_id" gorm:"not null;index"`
+	Store         Store       `gorm:"foreignKey:StoreID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	DistributorID int64       `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	OrderID       *int64      `json:"order_id" gorm:"index"`
+	Order         *Order      `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+	ProductID     *int64      `json:"product_id" gorm:"index"`
+	Product       *Product    `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+	Subject       string      `json:"subject"`
+	Locked        bool        `json:"locked"`
+	LastMessageAt time.Time   `json:"last_message_at"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UnreadCount   int64       `json:"unread_count" gorm:"->;-:migration"`
+	Messages      []Message   `json:"messages,omitempty" gorm:"foreignKey:ThreadID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// Participant reports whether the user takes part in the thread.
+func (t *MessageThread) Participant(userID int64) bool {
+	return t.StoreID == userID || t.DistributorID == userID
+}
+
+// Message model info. ReadAt is set when the other participant reads the
+// message. Messages hidden by an admin are only shown to admins.
+type Message struct {
+	ID           int64          `json:"id" gorm:"primaryKey"`
+	ThreadID     int64          `json:"thread_id" gorm:"not null;index"`
+	SenderID     int64          `json:"sender_id" gorm:"not null"`
+	SenderRole   string         `json:"sender_role" gorm:"not null"`
+	Body         string         `json:"body"`
+	Attachments  pq.StringArray `json:"attachments" gorm:"type:text[]"`
+	ReadAt       *time.Time     `json:"read_at"`
+	HiddenAt     *time.Time     `json:"hidden_at,omitempty"`
+	HiddenBy     *int64         `json:"hidden_by,omitempty"`
+	HiddenReason string         `json:"hidden_reason,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+type MessageInput struct {
+	Body        string   `json:"body"`
+	Attachments []string `json:"attachments"`
+}
+
+// ThreadInput starts a thread. Stores give the distributor and distributors
+// the store; both are taken from the order when it is given.
+type ThreadInput struct {
+	StoreID       int64    `json:"store_id"`
+	DistributorID int64    `json:"distributor_id"`
+	OrderID       *int64   `json:"order_id"`
+	ProductID     *int64   `json:"product_id"`
+	Subject       string   `json:"subject"`
+	Body          string   `json:"body"`
+	Attachments   []string `json:"attachments"`
+}
+
+type ModerationInput struct {
+	Reason string `json:"reason"`
+}
+
+// ValidateMessage checks the message; attachments must be files uploaded
+// through the upload endpoints, which uploaded reports.
+func ValidateMessage(v *validator.Validator, message *Message, uploaded func(url string) bool) {
+	v.Check(strings.TrimSpace(message.Body) != "" || len(message.Attachments) > 0, "body", "must be provided")
+	v.Check(len(message.Body) <= MaxMessageLength, "body", "must not be more than 4000 bytes long")
+	v.Check(len(message.Attachments) <= MaxMessageAttachments, "attachments", "must not contain more than 10 files")
+	for _, attachment := range message.Attachments {
+		v.Check(uploaded(attachment), "attachments", "must be uploaded through the upload endpoints")
+	}
+}
+
+func ValidateThread(v *validator.Validator, input *ThreadInput) {
+	v.Check(len(input.Subject) <= 200, "subject", "must not be more than 200 bytes long")
+}
+
+func ValidateModeration(v *validator.Validator, input *ModerationInput) {
+	v.Check(validator.In(input.Reason, ModerationReasons...), "reason", "must be one of "+strings.Join(ModerationReasons, ", "))
+}
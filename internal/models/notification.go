@@ -0,0 +1,77 @@
+package models
+
+import (
+	validator "marketplace-api/internal/util"
+	"time"
+)
+
+const (
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// NotificationEvents are the events each role is notified about.
+var NotificationEvents = map[string][]string{
+	RoleDistributor: {"order.created", "order.cancelled", "review.created", "product.low_stock", "message.created"},
+	RoleStore:       {"order.stage_changed", "order.cancelled", "message.created"},
+}
+
+// Notification model info. A notification is kept for every event the user
+// was notified about, whichever channels were used; only the ones delivered
+// in-app are listed.
+type Notification struct {
+	ID            int64      `json:"id" gorm:"primaryKey"`
+	UserID        int64      `json:"user_id" gorm:"not null;uniqueIndex:idx_notification_event;index:idx_notification_user"`
+	User          User       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	EventID       int64      `json:"event_id" gorm:"not null;uniqueIndex:idx_notification_event"`
+	Event         string     `json:"event" gorm:"not null"`
+	AggregateType string     `json:"aggregate_type"`
+	AggregateID   int64      `json:"aggregate_id"`
+	Title         string     `json:"title"`
+	Body          string     `json:"body"`
+	InApp         bool       `json:"-" gorm:"index:idx_notification_user"`
+	ReadAt        *time.Time `json:"read_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// NotificationPreference model info. Users without a stored preference for an
+// event get DefaultNotificationPreference.
+type NotificationPreference struct {
+	UserID int64  `json:"-" gorm:"primaryKey"`
+	User   User   `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Event  string `json:"event" gorm:"primaryKey"`
+	InApp  bool   `json:"in_app"`
+	Email  bool   `json:"email"`
+	SMS    bool   `json:"sms"`
+}
+
+// DefaultNotificationPreference notifies in-app, and by email about
+// cancellations.
+func DefaultNotificationPreference(userID int64, event string) NotificationPreference {
+	return NotificationPreference{
+		UserID: userID,
+		Event:  event,
+		InApp:  true,
+		Email:  event == "order.cancelled",
+	}
+}
+
+// Enabled reports whether any channel is on.
+func (np NotificationPreference) Enabled() bool {
+	return np.InApp || np.Email || np.SMS
+}
+
+type NotificationPreferencesInput struct {
+	Preferences []NotificationPreference `json:"preferences"`
+}
+
+func ValidateNotificationPreferences(v *validator.Validator, role string, preferences []NotificationPreference) {
+	v.Check(len(preferences) > 0, "preferences", "must contain at least one preference")
+	events := make([]string, 0, len(preferences))
+	for _, preference := range preferences {
+		v.Check(validator.In(preference.Event, NotificationEvents[role]...), "preferences", "unknown event "+preference.Event)
+		events = append(events, preference.Event)
+	}
+	v.Check(validator.Unique(events), "preferences", "must not contain duplicate events")
+}
@@ -1,10 +1,16 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"regexp"
+	"time"
+)
 
 const (
 	OrderStatusActive  = "active"
 	OrderStatusClosed  = "closed"
+	StageBackordered   = "backordered"
 	StageNew           = "new"
 	StageConfirmed     = "confirmed"
 	StageProcessing    = "processing"
@@ -15,36 +21,112 @@ const (
 	StageStatusError   = "error"
 )
 
+// OrderNumberRX matches the number filter of order listings, a part of an
+// order number.
+var OrderNumberRX = regexp.MustCompile("^[A-Za-z0-9-]+$")
+
 // Order model info
+// TotalPrice is the gross amount of the line, NetAmount plus TaxAmount. Number
+// is the order number shown to people, see OrderNumber.
 type Order struct {
-	ID               int64       `json:"id" gorm:"primaryKey"`
-	StoreID          int64       `json:"store_id" gorm:"not null"`
-	Store            Store       `gorm:"foreignKey:StoreID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
-	ProductID        int64       `json:"product_id" gorm:"not null"`
-	Product          Product     `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"product"`
-	Quantity         int64       `json:"quantity"`
-	TotalPrice       float64     `json:"total_price"`
-	Timestamp        time.Time   `json:"timestamp"`
-	DistributorID    int64       `json:"distributor_id"`
-	Distributor      Distributor `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
-	Status           string      `json:"status"`
-	StageID          int64       `json:"order_id"`
-	Stage            Stage       `gorm:"foreignKey:StageID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"stage"`
-	City             string      `json:"city"`
-	Address          string      `json:"address"`
-	StoreEmail       string      `json:"store_email"`
-	DistributorEmail string      `json:"distributor_email"`
+	ID               int64           `json:"id" gorm:"primaryKey"`
+	Number           string          `json:"number" gorm:"not null;default:''"`
+	StoreID          int64           `json:"store_id" gorm:"not null"`
+	Store            Store           `gorm:"foreignKey:StoreID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	ProductID        *int64          `json:"product_id"`
+	Product          Product         `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"product"`
+	Snapshot         ProductSnapshot `gorm:"embedded;embeddedPrefix:snapshot_" json:"snapshot"`
+	Quantity         int64           `json:"quantity"`
+	TotalPrice       float64         `json:"total_price"`
+	TaxRate          float64         `json:"tax_rate"`
+	NetAmount        float64         `json:"net_amount"`
+	TaxAmount        float64         `json:"tax_amount"`
+	LineDiscount     `gorm:"embedded"`
+	Timestamp        time.Time    `json:"timestamp"`
+	DistributorID    int64        `json:"distributor_id"`
+	Distributor      Distributor  `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Status           string       `json:"status"`
+	StageID          int64        `json:"order_id"`
+	Stage            Stage        `gorm:"foreignKey:StageID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"stage"`
+	AddressID        *int64       `json:"address_id"`
+	StoreAddress     StoreAddress `gorm:"foreignKey:AddressID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+	AddressLabel     string       `json:"address_label"`
+	City             string       `json:"city"`
+	Address          string       `json:"address"`
+	ContactName      string       `json:"contact_name"`
+	ContactPhone     string       `json:"contact_phone"`
+	StoreEmail       string       `json:"store_email"`
+	DistributorEmail string       `json:"distributor_email"`
+	ExpectedAt       *time.Time   `json:"expected_at,omitempty"`
+	DeliverySlotID   *int64       `json:"delivery_slot_id,omitempty"`
+	DeliveryStart    *time.Time   `json:"delivery_start,omitempty"`
+	DeliveryEnd      *time.Time   `json:"delivery_end,omitempty"`
+	ExchangeSentAt   *time.Time   `json:"-"`
+	ExchangedAt      *time.Time   `json:"exchanged_at,omitempty"`
+	InvoiceID        *int64       `json:"invoice_id" gorm:"index"`
+	PaymentStatus    string       `json:"payment_status,omitempty" gorm:"-"`
+	// CreditHold marks orders that took the store over its credit limit.
+	CreditHold bool `json:"credit_hold"`
+}
+
+// OrderNumber formats the number of an order of the distributor, e.g.
+// DST42-2026-000381 for its 381st order of 2026.
+func OrderNumber(distributorID int64, year int, sequence int64) string {
+	return fmt.Sprintf("DST%d-%d-%06d", distributorID, year, sequence)
+}
+
+// OrderSequence names the sequence of the distributor's order numbers in the
+// year.
+func OrderSequence(year int) string {
+	return fmt.Sprintf("order-%d", year)
+}
+
+// ProductSnapshot keeps the product and distributor details as they were at
+// checkout, so later edits or deletion of the product do not change the order.
+type ProductSnapshot struct {
+	ProductName            string         `json:"product_name"`
+	SKU                    string         `json:"sku"`
+	UnitPrice              float64        `json:"unit_price"`
+	ImgURLs                pq.StringArray `json:"img_urls" gorm:"type:text[]"`
+	Category               string         `json:"category"`
+	TaxCategory            string         `json:"tax_category"`
+	DistributorName        string         `json:"distributor_name"`
+	DistributorCompanyName string         `json:"distributor_company_name"`
+	DistributorBIN         string         `json:"distributor_bin"`
+	DistributorPhone       string         `json:"distributor_phone"`
+	DistributorCity        string         `json:"distributor_city"`
+}
+
+// NewProductSnapshot captures the product and its distributor.
+func NewProductSnapshot(product *Product) ProductSnapshot {
+	return ProductSnapshot{
+		ProductName:            product.ProductName,
+		SKU:                    product.SKU,
+		UnitPrice:              product.Price,
+		ImgURLs:                product.ImgURLs,
+		Category:               product.Category,
+		TaxCategory:            product.TaxCategory,
+		DistributorName:        product.Distributor.Name,
+		DistributorCompanyName: product.Distributor.CompanyName,
+		DistributorBIN:         product.Distributor.BIN,
+		DistributorPhone:       product.Distributor.PhoneNumber,
+		DistributorCity:        product.Distributor.City,
+	}
 }
 
 // Stage model info
+// ChangedAt is when the order entered the stage.
 type Stage struct {
-	ID     int64  `json:"id" gorm:"primaryKey"`
-	Stage  string `json:"stage"`
-	Status string `json:"status"`
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	Stage     string    `json:"stage"`
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changed_at"`
 }
 
 func (s *Stage) GetNextStage() string {
 	switch s.Stage {
+	case StageBackordered:
+		return StageNew
 	case StageNew:
 		return StageConfirmed
 	case StageConfirmed:
@@ -62,6 +144,8 @@ func (s *Stage) GetNextStage() string {
 
 func (s *Stage) GetPrevStage(stage string) string {
 	switch stage {
+	case StageBackordered:
+		return ""
 	case StageNew:
 		return ""
 	case StageConfirmed:
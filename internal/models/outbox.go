@@ -0,0 +1,30 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxEvent model info. Domain events are written to the outbox in the
+// transaction of the change that caused them and relayed to subscribers
+// afterwards.
+type OutboxEvent struct {
+	ID            int64           `json:"id" gorm:"primaryKey"`
+	Name          string          `json:"name" gorm:"not null"`
+	AggregateType string          `json:"aggregate_type" gorm:"not null;index:idx_outbox_aggregate"`
+	AggregateID   int64           `json:"aggregate_id" gorm:"not null;index:idx_outbox_aggregate"`
+	DistributorID int64           `json:"distributor_id" gorm:"index"`
+	StoreID       int64           `json:"store_id" gorm:"index"`
+	ActorID       int64           `json:"actor_id"`
+	Payload       json.RawMessage `json:"payload" gorm:"type:jsonb"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	DispatchedAt  *time.Time      `json:"dispatched_at" gorm:"index"`
+	Attempts      int64           `json:"attempts"`
+	LastError     string          `json:"last_error"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at" gorm:"index"`
+	// FailedAt is set when the relay gives up on the event.
+	FailedAt *time.Time `json:"failed_at" gorm:"index"`
+	// DispatchSeq numbers events in the order they were dispatched, which
+	// differs from the ID order when events are retried.
+	DispatchSeq *int64 `json:"dispatch_seq" gorm:"uniqueIndex"`
+}
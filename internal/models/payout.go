@@ -0,0 +1,185 @@
+package models
+
+import (
+	validator "marketplace-api/internal/util"
+	"time"
+)
+
+const (
+	PayoutLineSale       = "sale"
+	PayoutLineReturn     = "return"
+	PayoutLineAdjustment = "adjustment"
+
+	StatementStatusIssued = "issued"
+	StatementStatusPaid   = "paid"
+
+	PayoutPeriodWeekly  = "weekly"
+	PayoutPeriodMonthly = "monthly"
+)
+
+// CommissionRule model info
+// A rule applies to the orders of a distributor, a category, both, or, with
+// neither set, to every order. The most specific active rule wins: distributor
+// and category, then distributor, then category, then the global rule.
+type CommissionRule struct {
+	ID            int64     `json:"id" gorm:"primaryKey"`
+	DistributorID *int64    `json:"distributor_id" gorm:"index"`
+	Category      string    `json:"category"`
+	Percent       float64   `json:"percent"`
+	FixedFee      float64   `json:"fixed_fee"`
+	Active        bool      `json:"active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// specificity ranks how narrowly the rule applies.
+func (r *CommissionRule) specificity() int {
+	rank := 0
+	if r.DistributorID != nil {
+		rank += 2
+	}
+	if r.Category != "" {
+		rank++
+	}
+	return rank
+}
+
+// Matches reports whether the rule applies to orders of the distributor in
+// the category.
+func (r *CommissionRule) Matches(distributorID int64, category string) bool {
+	return r.Active &&
+		(r.DistributorID == nil || *r.DistributorID == distributorID) &&
+		(r.Category == "" || r.Category == category)
+}
+
+// Fee returns the commission on an order line of amount. The fee never
+// exceeds the amount.
+func (r *CommissionRule) Fee(amount float64) float64 {
+	return RoundMoney(min(amount*r.Percent/100+r.FixedFee, amount))
+}
+
+// MatchCommissionRule returns the most specific rule that applies to orders of
+// the distributor in the category, or nil when no rule does.
+func MatchCommissionRule(rules []CommissionRule, distributorID int64, category string) *CommissionRule {
+	var match *CommissionRule
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Matches(distributorID, category) && (match == nil || rule.specificity() > match.specificity()) {
+			match = rule
+		}
+	}
+	return match
+}
+
+type CommissionRuleInput struct {
+	DistributorID *int64  `json:"distributor_id"`
+	Category      string  `json:"category"`
+	Percent       float64 `json:"percent"`
+	FixedFee      float64 `json:"fixed_fee"`
+	Active        *bool   `json:"active"`
+}
+
+func ValidateCommissionRule(v *validator.Validator, rule *CommissionRule) {
+	v.Check(rule.Percent >= 0 && rule.Percent <= 100, "percent", "must be between 0 and 100")
+	v.Check(rule.FixedFee >= 0, "fixed_fee", "must not be negative")
+	v.Check(RoundMoney(rule.FixedFee) == rule.FixedFee, "fixed_fee", "must not have more than 2 decimal places")
+	v.Check(rule.DistributorID == nil || *rule.DistributorID > 0, "distributor_id", "must be a positive integer")
+	v.Check(len(rule.Category) <= 100, "category", "must not be more than 100 bytes long")
+}
+
+// PayoutLine model info
+// Lines are the ledger of what the marketplace owes a distributor: completed
+// order lines less commission, returns of them, and manual adjustments. Each
+// line is paid out on the first statement issued after it.
+type PayoutLine struct {
+	ID            int64       `json:"id" gorm:"primaryKey"`
+	DistributorID int64       `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Kind          string      `json:"kind" gorm:"not null"`
+	// OrderID is unique among sale lines, so an order is paid out once.
+	OrderID *int64 `json:"order_id,omitempty" gorm:"uniqueIndex:idx_payout_lines_sale,where:kind = 'sale'"`
+	// Quantity is the number of units sold or returned.
+	Quantity    int64     `json:"quantity,omitempty"`
+	Amount      float64   `json:"amount"`
+	Commission  float64   `json:"commission"`
+	Net         float64   `json:"net"`
+	RuleID      *int64    `json:"rule_id,omitempty"`
+	Percent     float64   `json:"percent"`
+	FixedFee    float64   `json:"fixed_fee"`
+	Note        string    `json:"note"`
+	StatementID *int64    `json:"statement_id,omitempty" gorm:"index"`
+	CreatedBy   int64     `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at" gorm:"index"`
+}
+
+// Statement model info
+// A statement totals the payout lines of a distributor up to PeriodEnd. It is
+// issued once the period is over and marked paid by an admin after the
+// transfer.
+type Statement struct {
+	ID            int64        `json:"id" gorm:"primaryKey"`
+	DistributorID int64        `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor  `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	PeriodStart   time.Time    `json:"period_start"`
+	PeriodEnd     time.Time    `json:"period_end"`
+	GrossSales    float64      `json:"gross_sales"`
+	Returns       float64      `json:"returns"`
+	Commission    float64      `json:"commission"`
+	Adjustments   float64      `json:"adjustments"`
+	NetPayout     float64      `json:"net_payout"`
+	Status        string       `json:"status" gorm:"not null;index"`
+	PaidAt        *time.Time   `json:"paid_at"`
+	PaidBy        *int64       `json:"paid_by,omitempty"`
+	Reference     string       `json:"reference"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Lines         []PayoutLine `json:"lines,omitempty" gorm:"foreignKey:StatementID"`
+}
+
+// Total adds the line to the totals of the statement.
+func (s *Statement) Total(line PayoutLine) {
+	switch line.Kind {
+	case PayoutLineSale:
+		s.GrossSales = RoundMoney(s.GrossSales + line.Amount)
+	case PayoutLineReturn:
+		s.Returns = RoundMoney(s.Returns + line.Amount)
+	case PayoutLineAdjustment:
+		s.Adjustments = RoundMoney(s.Adjustments + line.Amount)
+	}
+	s.Commission = RoundMoney(s.Commission + line.Commission)
+	s.NetPayout = RoundMoney(s.NetPayout + line.Net)
+}
+
+// PayoutPeriodStart returns the start of the payout period that contains t.
+// Weekly periods start on Monday.
+func PayoutPeriodStart(period string, t time.Time) time.Time {
+	year, month, day := t.Date()
+	if period == PayoutPeriodWeekly {
+		return time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+}
+
+// StatementColumns are the columns of exported statements.
+var StatementColumns = []string{"statement_id", "line_id", "created_at", "kind", "order_id", "quantity", "amount", "percent", "fixed_fee", "commission", "net", "note"}
+
+type PayoutAdjustmentInput struct {
+	DistributorID int64   `json:"distributor_id"`
+	Amount        float64 `json:"amount"`
+	Note          string  `json:"note"`
+}
+
+type StatementPaidInput struct {
+	Reference string `json:"reference"`
+}
+
+func ValidatePayoutAdjustment(v *validator.Validator, input *PayoutAdjustmentInput) {
+	v.Check(input.DistributorID > 0, "distributor_id", "must be provided")
+	v.Check(input.Amount != 0, "amount", "must not be zero")
+	v.Check(RoundMoney(input.Amount) == input.Amount, "amount", "must not have more than 2 decimal places")
+	v.Check(input.Note != "", "note", "must explain the adjustment")
+	v.Check(len(input.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+func ValidateStatementPaid(v *validator.Validator, input *StatementPaidInput) {
+	v.Check(len(input.Reference) <= 200, "reference", "must not be more than 200 bytes long")
+}
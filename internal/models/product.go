@@ -1,22 +1,46 @@
 package models
 
 import (
+	"encoding/json"
 	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"time"
 )
 
 // Product model info
 type Product struct {
-	ID                 int64          `json:"id" gorm:"primaryKey"`
-	ProductName        string         `json:"product_name"`
-	ProductDescription string         `json:"product_description"`
-	Price              float64        `json:"price"`
-	ImgURLs            pq.StringArray `json:"ImgURLs" gorm:"type:text[]"`
-	MinimumQuantity    int64          `json:"minimum_quantity"`
-	DistributorID      int64          `gorm:"not null;" json:"distributor_id"`
-	Distributor        Distributor    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"distributor"`
-	Stock              int64          `json:"stock"`
-	City               string         `json:"city"`
-	Category           string         `json:"category"`
+	ID                   int64           `json:"id" gorm:"primaryKey"`
+	ProductName          string          `json:"product_name"`
+	SKU                  string          `json:"sku" gorm:"index"`
+	ExternalID           string          `json:"external_id" gorm:"index"`
+	ProductDescription   string          `json:"product_description"`
+	Price                float64         `json:"price"`
+	ImgURLs              pq.StringArray  `json:"ImgURLs" gorm:"type:text[]"`
+	Images               []ImageVariants `json:"images" gorm:"-"`
+	MinimumQuantity      int64           `json:"minimum_quantity"`
+	DistributorID        int64           `gorm:"not null;" json:"distributor_id"`
+	Distributor          Distributor     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"distributor"`
+	Stock                int64           `json:"stock"`
+	City                 string          `json:"city"`
+	Category             string          `json:"category"`
+	TaxCategory          string          `json:"tax_category" gorm:"not null;default:standard"`
+	AllowBackorder       bool            `json:"allow_backorder"`
+	BackorderAvailableAt *time.Time      `json:"backorder_available_at"`
+	LowStockThreshold    int64           `json:"low_stock_threshold"`
+	Rating               *RatingSummary  `json:"rating,omitempty" gorm:"-"`
+	// DeletedAt marks products deleted after stock moved, which the stock
+	// ledger keeps referring to.
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// MarshalJSON adds the variant URLs of ImgURLs, however the product was loaded.
+func (p Product) MarshalJSON() ([]byte, error) {
+	type product Product
+	p.Images = make([]ImageVariants, 0, len(p.ImgURLs))
+	for _, url := range p.ImgURLs {
+		p.Images = append(p.Images, NewImageVariants(url))
+	}
+	return json.Marshal(product(p))
 }
 
 /*
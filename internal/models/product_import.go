@@ -0,0 +1,247 @@
+package models
+
+import (
+	validator "marketplace-api/internal/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+
+	ImportFormatCSV  = "csv"
+	ImportFormatXLSX = "xlsx"
+
+	// ImgURLSeparator separates image URLs inside the img_urls column.
+	ImgURLSeparator = "|"
+)
+
+// ProductColumns is the column layout shared by catalog import and export.
+var ProductColumns = []string{
+	"sku",
+	"product_name",
+	"product_description",
+	"category",
+	"tax_category",
+	"city",
+	"price",
+	"minimum_quantity",
+	"stock",
+	"allow_backorder",
+	"backorder_available_at",
+	"img_urls",
+}
+
+// RequiredProductColumns must be present in the header of an import file.
+var RequiredProductColumns = []string{"sku", "product_name", "price"}
+
+// ProductImport model info
+type ProductImport struct {
+	ID            int64                `json:"id" gorm:"primaryKey"`
+	DistributorID int64                `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor          `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	FileName      string               `json:"file_name"`
+	Format        string               `json:"format"`
+	DryRun        bool                 `json:"dry_run"`
+	Status        string               `json:"status"`
+	TotalRows     int64                `json:"total_rows"`
+	Processed     int64                `json:"processed"`
+	Created       int64                `json:"created"`
+	Updated       int64                `json:"updated"`
+	Failed        int64                `json:"failed"`
+	Error         string               `json:"error,omitempty"`
+	CreatedAt     time.Time            `json:"created_at"`
+	FinishedAt    *time.Time           `json:"finished_at"`
+	Errors        []ProductImportError `gorm:"foreignKey:ImportID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"errors,omitempty"`
+}
+
+// ProductImportError model info
+type ProductImportError struct {
+	ID       int64  `json:"-" gorm:"primaryKey"`
+	ImportID int64  `json:"-" gorm:"not null;index"`
+	Row      int64  `json:"row"`
+	SKU      string `json:"sku"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+// ProductRow is a parsed row of an import file. Columns that are missing from
+// the file are not set on the product, so updates keep their current values.
+type ProductRow struct {
+	Number  int64
+	Columns map[string]bool
+	Product Product
+	Stock   int64
+}
+
+// ParseProductRow maps a record onto the columns of the header and reports
+// values that cannot be parsed to the validator.
+func ParseProductRow(v *validator.Validator, header []string, record []string, number int64) *ProductRow {
+	row := &ProductRow{Number: number, Columns: make(map[string]bool)}
+	for i, column := range header {
+		if column == "" {
+			continue
+		}
+		value := ""
+		if i < len(record) {
+			value = strings.TrimSpace(record[i])
+		}
+		row.Columns[column] = true
+
+		var err error
+		switch column {
+		case "sku":
+			row.Product.SKU = value
+		case "product_name":
+			row.Product.ProductName = value
+		case "product_description":
+			row.Product.ProductDescription = value
+		case "category":
+			row.Product.Category = value
+		case "tax_category":
+			row.Product.TaxCategory = value
+		case "city":
+			row.Product.City = value
+		case "price":
+			row.Product.Price, err = parseOptionalFloat(value)
+			v.Check(err == nil, column, "must be a number")
+		case "minimum_quantity":
+			row.Product.MinimumQuantity, err = parseOptionalInt(value)
+			v.Check(err == nil, column, "must be an integer value")
+		case "stock":
+			row.Stock, err = parseOptionalInt(value)
+			v.Check(err == nil, column, "must be an integer value")
+		case "allow_backorder":
+			row.Product.AllowBackorder, err = parseOptionalBool(value)
+			v.Check(err == nil, column, "must be true or false")
+		case "backorder_available_at":
+			row.Product.BackorderAvailableAt, err = parseOptionalDate(value)
+			v.Check(err == nil, column, "must be a date in YYYY-MM-DD format")
+		case "img_urls":
+			row.Product.ImgURLs = nil
+			for _, url := range strings.Split(value, ImgURLSeparator) {
+				if url = strings.TrimSpace(url); url != "" {
+					row.Product.ImgURLs = append(row.Product.ImgURLs, url)
+				}
+			}
+		}
+	}
+	return row
+}
+
+// NormaliseHeader turns column titles like "Product Name" into column keys.
+func NormaliseHeader(header []string) []string {
+	columns := make([]string, len(header))
+	for i, title := range header {
+		columns[i] = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(title)), " ", "_")
+	}
+	return columns
+}
+
+func ValidateImportHeader(v *validator.Validator, header []string) {
+	seen := make(map[string]bool, len(header))
+	for _, column := range header {
+		if column == "" {
+			continue
+		}
+		v.Check(validator.In(column, ProductColumns...), column, "unknown column")
+		v.Check(!seen[column], column, "column is repeated")
+		seen[column] = true
+	}
+	for _, column := range RequiredProductColumns {
+		v.Check(seen[column], column, "column must be present")
+	}
+}
+
+func ValidateProductRow(v *validator.Validator, row *ProductRow) {
+	v.Check(row.Product.SKU != "", "sku", "must be provided")
+	v.Check(len(row.Product.SKU) <= 64, "sku", "must not be more than 64 bytes long")
+	v.Check(row.Product.ProductName != "", "product_name", "must be provided")
+	v.Check(len(row.Product.ProductName) <= 500, "product_name", "must not be more than 500 bytes long")
+	v.Check(row.Product.Price > 0, "price", "must be greater than zero")
+	v.Check(row.Product.TaxCategory == "" || validator.In(row.Product.TaxCategory, TaxCategories...), "tax_category", "must be standard, zero or exempt")
+	v.Check(row.Product.MinimumQuantity >= 0, "minimum_quantity", "must not be negative")
+	v.Check(row.Stock >= 0, "stock", "must not be negative")
+}
+
+// ProductRecord formats the product in the ProductColumns layout.
+func ProductRecord(product *Product) []string {
+	backorderAvailableAt := ""
+	if product.BackorderAvailableAt != nil {
+		backorderAvailableAt = product.BackorderAvailableAt.Format(time.DateOnly)
+	}
+	return []string{
+		product.SKU,
+		product.ProductName,
+		product.ProductDescription,
+		product.Category,
+		product.TaxCategory,
+		product.City,
+		strconv.FormatFloat(product.Price, 'f', -1, 64),
+		strconv.FormatInt(product.MinimumQuantity, 10),
+		strconv.FormatInt(product.Stock, 10),
+		strconv.FormatBool(product.AllowBackorder),
+		backorderAvailableAt,
+		strings.Join(product.ImgURLs, ImgURLSeparator),
+	}
+}
+
+func parseOptionalFloat(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if !strings.Contains(value, ".") {
+		// decimal comma, e.g. "12,50"
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func parseOptionalInt(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		// spreadsheets store whole numbers as floats, e.g. "10.0"
+		f, ferr := strconv.ParseFloat(value, 64)
+		if ferr != nil || f != float64(int64(f)) {
+			return 0, err
+		}
+		return int64(f), nil
+	}
+	return n, nil
+}
+
+func parseOptionalBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "0", "false", "no":
+		return false, nil
+	case "1", "true", "yes":
+		return true, nil
+	}
+	return false, strconv.ErrSyntax
+}
+
+func parseOptionalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	// spreadsheet date cells hold the number of days since 1899-12-30
+	days, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	t := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(days))
+	return &t, nil
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"github.com/lib/pq"
+	validator "marketplace-api/internal/util"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	PromotionPercentage  = "percentage"
+	PromotionFixedAmount = "fixed_amount"
+	PromotionBuyXGetY    = "buy_x_get_y"
+)
+
+var PromotionKinds = []string{PromotionPercentage, PromotionFixedAmount, PromotionBuyXGetY}
+
+var CouponCodeRX = regexp.MustCompile("^[A-Z0-9_-]+$")
+
+// Promotion model info
+// Percentage promotions take Value percent off matching lines and fixed amount
+// promotions take Value off each unit. Buy-X-get-Y promotions make FreeQuantity
+// of every BuyQuantity plus FreeQuantity units of a line free, so "buy 10 get
+// 1 free" charges 10 of 11 units ordered. Empty scopes match everything. A
+// line gets the one promotion that takes the most off it.
+type Promotion struct {
+	ID            int64          `json:"id" gorm:"primaryKey"`
+	DistributorID int64          `json:"distributor_id" gorm:"not null;index"`
+	Distributor   Distributor    `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Name          string         `json:"name"`
+	Kind          string         `json:"kind" gorm:"not null"`
+	Value         float64        `json:"value"`
+	BuyQuantity   int64          `json:"buy_quantity"`
+	FreeQuantity  int64          `json:"free_quantity"`
+	ProductIDs    pq.Int64Array  `json:"product_ids" gorm:"type:bigint[]"`
+	Categories    pq.StringArray `json:"categories" gorm:"type:text[]"`
+	StoreIDs      pq.Int64Array  `json:"store_ids" gorm:"type:bigint[]"`
+	Cities        pq.StringArray `json:"cities" gorm:"type:text[]"`
+	StartsAt      *time.Time     `json:"starts_at"`
+	EndsAt        *time.Time     `json:"ends_at"`
+	// UsageLimit and PerStoreLimit cap the checkouts the promotion applies
+	// to, in total and per store. Zero means no limit.
+	UsageLimit    int64     `json:"usage_limit"`
+	PerStoreLimit int64     `json:"per_store_limit"`
+	CouponCode    string    `json:"coupon_code" gorm:"index"`
+	Active        bool      `json:"active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Applies reports whether the promotion applies to the product bought by the
+// store for delivery to the city with the coupon code at the time.
+func (p *Promotion) Applies(product *Product, storeID int64, city, couponCode string, now time.Time) bool {
+	return p.Active && p.DistributorID == product.DistributorID &&
+		(p.StartsAt == nil || !now.Before(*p.StartsAt)) &&
+		(p.EndsAt == nil || now.Before(*p.EndsAt)) &&
+		(p.CouponCode == "" || strings.EqualFold(p.CouponCode, couponCode)) &&
+		(len(p.ProductIDs) == 0 || slices.Contains(p.ProductIDs, product.ID)) &&
+		(len(p.Categories) == 0 || slices.Contains(p.Categories, product.Category)) &&
+		(len(p.StoreIDs) == 0 || slices.Contains(p.StoreIDs, storeID)) &&
+		(len(p.Cities) == 0 || slices.ContainsFunc(p.Cities, func(c string) bool { return strings.EqualFold(c, city) }))
+}
+
+// Discount returns the amount the promotion takes off quantity units at the
+// unit price.
+func (p *Promotion) Discount(unitPrice float64, quantity int64) float64 {
+	switch p.Kind {
+	case PromotionPercentage:
+		return RoundMoney(unitPrice * float64(quantity) * p.Value / 100)
+	case PromotionFixedAmount:
+		return RoundMoney(min(p.Value, unitPrice) * float64(quantity))
+	case PromotionBuyXGetY:
+		if p.BuyQuantity <= 0 || p.FreeQuantity <= 0 {
+			return 0
+		}
+		free := quantity / (p.BuyQuantity + p.FreeQuantity) * p.FreeQuantity
+		return RoundMoney(unitPrice * float64(free))
+	}
+	return 0
+}
+
+// BestPromotion returns the promotion that takes the most off quantity units
+// of the product and the discount, or nil when none applies.
+func BestPromotion(promotions []Promotion, product *Product, quantity, storeID int64, city, couponCode string, now time.Time) (*Promotion, float64) {
+	var best *Promotion
+	var bestDiscount float64
+	for i := range promotions {
+		promotion := &promotions[i]
+		if !promotion.Applies(product, storeID, city, couponCode, now) {
+			continue
+		}
+		if discount := promotion.Discount(product.Price, quantity); discount > bestDiscount {
+			best, bestDiscount = promotion, discount
+		}
+	}
+	return best, bestDiscount
+}
+
+// LineDiscount is the promotion applied to a cart or order line.
+type LineDiscount struct {
+	PromotionID    *int64  `json:"promotion_id,omitempty" gorm:"index"`
+	PromotionName  string  `json:"promotion_name,omitempty"`
+	DiscountAmount float64 `json:"discount_amount"`
+}
+
+// PromotionReport sums up the orders a promotion was applied to, leaving out
+// cancelled ones.
+type PromotionReport struct {
+	Checkouts      int64   `json:"checkouts"`
+	Orders         int64   `json:"orders"`
+	Quantity       int64   `json:"quantity"`
+	DiscountAmount float64 `json:"discount_amount"`
+	GrossSales     float64 `json:"gross_sales"`
+}
+
+type PromotionInput struct {
+	Name          string     `json:"name"`
+	Kind          string     `json:"kind"`
+	Value         float64    `json:"value"`
+	BuyQuantity   int64      `json:"buy_quantity"`
+	FreeQuantity  int64      `json:"free_quantity"`
+	ProductIDs    []int64    `json:"product_ids"`
+	Categories    []string   `json:"categories"`
+	StoreIDs      []int64    `json:"store_ids"`
+	Cities        []string   `json:"cities"`
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	UsageLimit    int64      `json:"usage_limit"`
+	PerStoreLimit int64      `json:"per_store_limit"`
+	CouponCode    string     `json:"coupon_code"`
+	Active        *bool      `json:"active"`
+}
+
+// NormaliseCouponCode makes coupon codes case-insensitive.
+func NormaliseCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func ValidatePromotion(v *validator.Validator, promotion *Promotion) {
+	v.Check(promotion.Name != "", "name", "must be provided")
+	v.Check(len(promotion.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(validator.In(promotion.Kind, PromotionKinds...), "kind", "must be percentage, fixed_amount or buy_x_get_y")
+	switch promotion.Kind {
+	case PromotionPercentage:
+		v.Check(promotion.Value > 0 && promotion.Value <= 100, "value", "must be between 0 and 100")
+	case PromotionFixedAmount:
+		v.Check(promotion.Value > 0, "value", "must be greater than zero")
+		v.Check(RoundMoney(promotion.Value) == promotion.Value, "value", "must not have more than 2 decimal places")
+	case PromotionBuyXGetY:
+		v.Check(promotion.BuyQuantity > 0, "buy_quantity", "must be greater than zero")
+		v.Check(promotion.FreeQuantity > 0, "free_quantity", "must be greater than zero")
+	}
+	v.Check(promotion.StartsAt == nil || promotion.EndsAt == nil || promotion.EndsAt.After(*promotion.StartsAt), "ends_at", "must be after starts_at")
+	v.Check(promotion.UsageLimit >= 0, "usage_limit", "must not be negative")
+	v.Check(promotion.PerStoreLimit >= 0, "per_store_limit", "must not be negative")
+	v.Check(len(promotion.CouponCode) <= 50, "coupon_code", "must not be more than 50 bytes long")
+	v.Check(promotion.CouponCode == "" || validator.Matches(promotion.CouponCode, CouponCodeRX), "coupon_code", "must only contain letters, digits, dashes and underscores")
+	for key, size := range map[string]int{
+		"product_ids": len(promotion.ProductIDs),
+		"categories":  len(promotion.Categories),
+		"store_ids":   len(promotion.StoreIDs),
+		"cities":      len(promotion.Cities),
+	} {
+		v.Check(size <= 100, key, "must not contain more than 100 values")
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import "github.com/lib/pq"
+
+const (
+	RatingSubjectProduct     = "product"
+	RatingSubjectDistributor = "distributor"
+)
+
+// RatingSummary model info
+// Summaries are updated together with the reviews, so listings read them
+// without aggregating reviews.
+type RatingSummary struct {
+	SubjectType string  `json:"-" gorm:"primaryKey"`
+	SubjectID   int64   `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Count       int64   `json:"count" gorm:"not null;default:0"`
+	Sum         int64   `json:"-" gorm:"not null;default:0"`
+	Average     float64 `json:"average" gorm:"not null;default:0"`
+	// Distribution holds the number of 1 to 5 star reviews.
+	Distribution pq.Int64Array `json:"distribution" gorm:"type:bigint[];not null;default:'{0,0,0,0,0}'"`
+}
+
+// NewRatingSummary returns the summary of a subject without reviews.
+func NewRatingSummary(subjectType string, subjectID int64) *RatingSummary {
+	return &RatingSummary{SubjectType: subjectType, SubjectID: subjectID, Distribution: pq.Int64Array{0, 0, 0, 0, 0}}
+}
@@ -1,18 +1,116 @@
 package models
 
+import (
+	validator "marketplace-api/internal/util"
+	"strings"
+	"time"
+)
+
+const (
+	ReviewStatusPublished = "published"
+	// ReviewStatusHeld reviews wait for a moderator, after the pre-filter or
+	// enough reports caught them.
+	ReviewStatusHeld   = "held"
+	ReviewStatusHidden = "hidden"
+
+	// MaxReviewLength bounds the text of a review and of its reply in bytes.
+	MaxReviewLength = 2000
+	// ReviewFlagThreshold is the number of open reports that holds a published
+	// review for moderation.
+	ReviewFlagThreshold = 3
+)
+
+// ReviewQueues are the views of the admin moderation queue. Pending holds the
+// held reviews and the reviews with open reports.
+var ReviewQueues = []string{"pending", ReviewStatusHeld, ReviewStatusHidden, ReviewStatusPublished, "flagged", "all"}
+
 // Review model info
+// Reviews are written for an order line the store received. OrderID is empty,
+// and VerifiedPurchase false, for reviews written before that was required.
 type Review struct {
-	ID            int64  `json:"id" gorm:"primaryKey"`
-	DistributorId int64  `json:"distributor_id"`
-	ProductId     int64  `json:"product_id"`
-	StoreId       int64  `json:"store_id"`
-	Rating        int    `json:"rating"`
-	Text          string `json:"text"`
+	ID               int64        `json:"id" gorm:"primaryKey"`
+	DistributorId    int64        `json:"distributor_id"`
+	ProductId        int64        `json:"product_id"`
+	StoreId          int64        `json:"store_id"`
+	OrderID          *int64       `json:"order_id" gorm:"uniqueIndex"`
+	VerifiedPurchase bool         `json:"verified_purchase"`
+	Rating           int          `json:"rating"`
+	Text             string       `json:"text"`
+	Status           string       `json:"status" gorm:"not null;default:'published';index"`
+	ModerationReason string       `json:"moderation_reason,omitempty"`
+	ModeratedBy      *int64       `json:"-"`
+	ModeratedAt      *time.Time   `json:"moderated_at,omitempty"`
+	PublishedAt      *time.Time   `json:"published_at,omitempty"`
+	Reply            string       `json:"reply"`
+	RepliedAt        *time.Time   `json:"replied_at"`
+	Flags            []ReviewFlag `json:"flags,omitempty" gorm:"foreignKey:ReviewID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+}
+
+// EditableUntil returns the end of the time the store can edit the review.
+func (r *Review) EditableUntil(window time.Duration) time.Time {
+	return r.CreatedAt.Add(window)
 }
 
+// ReviewFlag model info
+// A report of a review by a store or by the reviewed distributor. Moderation
+// decisions resolve the open reports.
+type ReviewFlag struct {
+	ID         int64      `json:"id" gorm:"primaryKey"`
+	ReviewID   int64      `json:"review_id" gorm:"not null;uniqueIndex:idx_review_flag_reporter"`
+	ReporterID int64      `json:"reporter_id" gorm:"not null;uniqueIndex:idx_review_flag_reporter"`
+	Reason     string     `json:"reason"`
+	Comment    string     `json:"comment"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ReviewInput is a review of an order line. DistributorId and ProductId are
+// optional and, when given, must match the order.
 type ReviewInput struct {
+	OrderID       int64  `json:"order_id"`
 	DistributorId int64  `json:"distributor_id"`
 	ProductId     int64  `json:"product_id"`
 	Rating        int    `json:"rating"`
 	Text          string `json:"text"`
 }
+
+// ReviewUpdateInput holds the fields a store can edit.
+type ReviewUpdateInput struct {
+	Rating int    `json:"rating"`
+	Text   string `json:"text"`
+}
+
+func ValidateReview(v *validator.Validator, input *ReviewInput) {
+	v.Check(input.OrderID > 0, "order_id", "must be provided")
+	validateReviewContent(v, input.Rating, input.Text)
+}
+
+func ValidateReviewUpdate(v *validator.Validator, input *ReviewUpdateInput) {
+	validateReviewContent(v, input.Rating, input.Text)
+}
+
+func validateReviewContent(v *validator.Validator, rating int, text string) {
+	v.Check(rating >= 1 && rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(text) <= MaxReviewLength, "text", "must not be more than 2000 bytes long")
+}
+
+type ReviewReplyInput struct {
+	Text string `json:"text"`
+}
+
+type ReviewFlagInput struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+func ValidateReviewReply(v *validator.Validator, input *ReviewReplyInput) {
+	v.Check(strings.TrimSpace(input.Text) != "", "text", "must be provided")
+	v.Check(len(input.Text) <= MaxReviewLength, "text", "must not be more than 2000 bytes long")
+}
+
+func ValidateReviewFlag(v *validator.Validator, input *ReviewFlagInput) {
+	v.Check(validator.In(input.Reason, ModerationReasons...), "reason", "must be one of "+strings.Join(ModerationReasons, ", "))
+	v.Check(len(input.Comment) <= 500, "comment", "must not be more than 500 bytes long")
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	validator "marketplace-api/internal/util"
+)
+
+// StoreAddress model info
+type StoreAddress struct {
+	ID           int64  `json:"id" gorm:"primaryKey"`
+	StoreID      int64  `json:"store_id" gorm:"not null;index"`
+	Store        Store  `gorm:"foreignKey:StoreID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Label        string `json:"label" gorm:"not null"`
+	City         string `json:"city" gorm:"not null"`
+	Address      string `json:"address" gorm:"not null"`
+	ContactName  string `json:"contact_name"`
+	ContactPhone string `json:"contact_phone"`
+	IsDefault    bool   `json:"is_default"`
+}
+
+type StoreAddressInput struct {
+	Label        string `json:"label"`
+	City         string `json:"city"`
+	Address      string `json:"address"`
+	ContactName  string `json:"contact_name"`
+	ContactPhone string `json:"contact_phone"`
+	IsDefault    bool   `json:"is_default"`
+}
+
+func ValidateStoreAddress(v *validator.Validator, address *StoreAddress) {
+	v.Check(address.Label != "", "label", "must be provided")
+	v.Check(len(address.Label) <= 100, "label", "must not be more than 100 bytes long")
+	v.Check(address.City != "", "city", "must be provided")
+	v.Check(address.Address != "", "address", "must be provided")
+	v.Check(len(address.ContactPhone) <= 20, "contact_phone", "must not be more than 20 bytes long")
+}
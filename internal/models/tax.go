@@ -0,0 +1,63 @@
+package models
+
+import validator "marketplace-api/internal/util"
+
+const (
+	// TaxCategoryStandard products are taxed at the distributor's VAT rate.
+	TaxCategoryStandard = "standard"
+	// TaxCategoryZero products are taxable at a zero rate.
+	TaxCategoryZero = "zero"
+	// TaxCategoryExempt products are exempt from VAT.
+	TaxCategoryExempt = "exempt"
+)
+
+var TaxCategories = []string{TaxCategoryStandard, TaxCategoryZero, TaxCategoryExempt}
+
+// TaxSettings are the VAT settings of a distributor. Distributors that are not
+// VAT payers charge no tax; PricesIncludeVAT tells whether the prices of their
+// products are gross or net amounts.
+type TaxSettings struct {
+	VATPayer         bool    `json:"vat_payer"`
+	VATRate          float64 `json:"vat_rate"`
+	PricesIncludeVAT bool    `json:"prices_include_vat"`
+}
+
+// TaxRate returns the VAT rate in percent of products in the tax category.
+func (s TaxSettings) TaxRate(taxCategory string) float64 {
+	if !s.VATPayer || taxCategory == TaxCategoryZero || taxCategory == TaxCategoryExempt {
+		return 0
+	}
+	return s.VATRate
+}
+
+func ValidateTaxSettings(v *validator.Validator, settings *TaxSettings) {
+	v.Check(settings.VATRate >= 0 && settings.VATRate <= 100, "vat_rate", "must be between 0 and 100")
+	v.Check(!settings.VATPayer || settings.VATRate > 0, "vat_rate", "must be provided for VAT payers")
+}
+
+// TaxBreakdown splits an amount into its net amount and the VAT on it.
+type TaxBreakdown struct {
+	TaxRate     float64 `json:"tax_rate"`
+	NetAmount   float64 `json:"net_amount"`
+	TaxAmount   float64 `json:"tax_amount"`
+	GrossAmount float64 `json:"gross_amount"`
+}
+
+// ProductTax returns the breakdown of quantity units of the product less the
+// discount with the VAT settings of its distributor, which must be loaded. The
+// tax is rounded per line.
+func ProductTax(product *Product, quantity int64, discount float64) TaxBreakdown {
+	settings := product.Distributor.TaxSettings
+	tax := TaxBreakdown{TaxRate: settings.TaxRate(product.TaxCategory)}
+	amount := RoundMoney(product.Price*float64(quantity) - discount)
+	if settings.PricesIncludeVAT {
+		tax.GrossAmount = amount
+		tax.NetAmount = RoundMoney(amount * 100 / (100 + tax.TaxRate))
+		tax.TaxAmount = RoundMoney(amount - tax.NetAmount)
+	} else {
+		tax.NetAmount = amount
+		tax.TaxAmount = RoundMoney(amount * tax.TaxRate / 100)
+		tax.GrossAmount = RoundMoney(amount + tax.TaxAmount)
+	}
+	return tax
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	ImageThumbnail = "thumbnail"
+	ImageMedium    = "medium"
+	ImageLarge     = "large"
+
+	ImageFormatWebP = "webp"
+	ImageFormatJPEG = "jpg"
+)
+
+// ImageSizes maps each variant to the longest side of its image in pixels.
+var ImageSizes = map[string]int{
+	ImageThumbnail: 200,
+	ImageMedium:    600,
+	ImageLarge:     1600,
+}
+
+// ImageFormats are the formats each variant is rendered in.
+var ImageFormats = map[string]string{
+	ImageFormatWebP: "image/webp",
+	ImageFormatJPEG: "image/jpeg",
+}
+
+// Upload model info
+// Every uploaded image is stored as a set of variants under Key, e.g.
+// "<key>/medium.webp". URL is the URL of the large JPEG, the one kept in
+// Product.ImgURLs and message attachments.
+type Upload struct {
+	ID         int64     `json:"id" gorm:"primaryKey"`
+	Key        string    `json:"key" gorm:"not null;uniqueIndex"`
+	URL        string    `json:"url" gorm:"not null;uniqueIndex"`
+	UploaderID int64     `json:"uploader_id" gorm:"index"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// ImageVariantKey returns the key of a variant of the upload.
+func ImageVariantKey(key, size, format string) string {
+	return key + "/" + size + "." + format
+}
+
+// ImageVariantKeys returns the keys of all variants of the upload.
+func ImageVariantKeys(key string) []string {
+	var keys []string
+	for size := range ImageSizes {
+		for format := range ImageFormats {
+			keys = append(keys, ImageVariantKey(key, size, format))
+		}
+	}
+	return keys
+}
+
+// ImageVariant holds the URLs of one variant in each format.
+type ImageVariant struct {
+	WebP string `json:"webp"`
+	JPEG string `json:"jpeg"`
+}
+
+// ImageVariants holds the URLs of every variant of an image.
+type ImageVariants struct {
+	Thumbnail ImageVariant `json:"thumbnail"`
+	Medium    ImageVariant `json:"medium"`
+	Large     ImageVariant `json:"large"`
+}
+
+// NewImageVariants derives the variant URLs from the URL of an upload.
+// Images uploaded before variants existed, and external images, have a single
+// file, which is used for every variant.
+func NewImageVariants(url string) ImageVariants {
+	suffix := "/" + ImageLarge + "." + ImageFormatJPEG
+	if !strings.HasSuffix(url, suffix) {
+		variant := ImageVariant{WebP: url, JPEG: url}
+		return ImageVariants{Thumbnail: variant, Medium: variant, Large: variant}
+	}
+	base := strings.TrimSuffix(url, suffix)
+	variant := func(size string) ImageVariant {
+		return ImageVariant{
+			WebP: ImageVariantKey(base, size, ImageFormatWebP),
+			JPEG: ImageVariantKey(base, size, ImageFormatJPEG),
+		}
+	}
+	return ImageVariants{Thumbnail: variant(ImageThumbnail), Medium: variant(ImageMedium), Large: variant(ImageLarge)}
+}
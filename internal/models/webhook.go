@@ -0,0 +1,89 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/lib/pq"
+	validator "marketplace-api/internal/util"
+	"net/url"
+	"time"
+)
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+// WebhookEvents are the events distributors can subscribe webhooks to.
+var WebhookEvents = []string{
+	"order.created",
+	"order.stage_changed",
+	"order.cancelled",
+	"review.created",
+	"product.low_stock",
+	"invoice.issued",
+	"payment.recorded",
+}
+
+// Webhook model info
+type Webhook struct {
+	ID                  int64          `json:"id" gorm:"primaryKey"`
+	DistributorID       int64          `json:"distributor_id" gorm:"not null;index"`
+	Distributor         Distributor    `gorm:"foreignKey:DistributorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	URL                 string         `json:"url" gorm:"not null"`
+	Secret              string         `json:"-" gorm:"not null"`
+	Events              pq.StringArray `json:"events" gorm:"type:text[]"`
+	Active              bool           `json:"active"`
+	ConsecutiveFailures int64          `json:"consecutive_failures"`
+	DisabledAt          *time.Time     `json:"disabled_at"`
+	CreatedAt           time.Time      `json:"created_at"`
+}
+
+// Subscribes reports whether the webhook receives the event.
+func (w *Webhook) Subscribes(event string) bool {
+	return validator.In(event, w.Events...)
+}
+
+// WebhookDelivery model info. Deliveries are the outbox of webhook calls: they
+// are written when the event happens and sent by the delivery job.
+type WebhookDelivery struct {
+	ID            int64            `json:"id" gorm:"primaryKey"`
+	WebhookID     int64            `json:"webhook_id" gorm:"not null;index"`
+	Webhook       Webhook          `gorm:"foreignKey:WebhookID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	EventID       string           `json:"event_id" gorm:"not null;index"`
+	Event         string           `json:"event" gorm:"not null"`
+	Payload       json.RawMessage  `json:"payload" gorm:"type:jsonb"`
+	Status        string           `json:"status" gorm:"not null;index"`
+	Attempts      int64            `json:"attempts"`
+	NextAttemptAt time.Time        `json:"next_attempt_at" gorm:"index"`
+	DeliveredAt   *time.Time       `json:"delivered_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Logs          []WebhookAttempt `gorm:"foreignKey:DeliveryID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"logs,omitempty"`
+}
+
+// WebhookAttempt model info
+type WebhookAttempt struct {
+	ID           int64     `json:"id" gorm:"primaryKey"`
+	DeliveryID   int64     `json:"delivery_id" gorm:"not null;index"`
+	StatusCode   int       `json:"status_code"`
+	ResponseBody string    `json:"response_body"`
+	Error        string    `json:"error"`
+	DurationMs   int64     `json:"duration_ms"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type WebhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least one event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", "unknown event "+event)
+	}
+}
@@ -0,0 +1,83 @@
+// Package moderation screens user text before it is published.
+package moderation
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	ReasonAbuse = "abuse"
+	ReasonSpam  = "spam"
+)
+
+// blockedWords are word stems of common profanity in Russian and English.
+// A word is blocked when it starts with one of them.
+var blockedWords = []string{
+	"хуй", "хуе", "хуё", "хуя", "пизд", "ебан", "ебат", "ебал", "ебло", "еблан", "ёбан", "бляд", "блять",
+	"сука", "суки", "мудак", "мудил", "залуп", "гандон", "пидор", "пидар", "шлюх", "долбоеб", "долбоёб",
+	"fuck", "shit", "bitch", "cunt", "asshole", "motherfuck", "dick", "whore",
+}
+
+var (
+	linkRX  = regexp.MustCompile(`(?i)(https?://|www\.|t\.me/|wa\.me/|\b[a-z0-9-]+\.(com|ru|kz|net|org|info|shop|store)\b)`)
+	emailRX = regexp.MustCompile(`(?i)[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}`)
+	phoneRX = regexp.MustCompile(`(\+|\b)[78][\s(-]*\d{3}[\s)-]*\d{3}[\s-]*\d{2}[\s-]*\d{2}\b|\+\d{10,}`)
+)
+
+// maxRun is the longest run of one character allowed, ignoring spaces.
+const maxRun = 7
+
+// Filter holds text that contains profanity or looks like spam, such as
+// links, contact details or long runs of one character.
+type Filter struct {
+	words []string
+}
+
+// NewFilter returns a filter that blocks the default words and the extra ones.
+func NewFilter(extraWords []string) *Filter {
+	words := append([]string{}, blockedWords...)
+	for _, word := range extraWords {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			words = append(words, word)
+		}
+	}
+	return &Filter{words: words}
+}
+
+// Check returns the reason to hold the text, or false when it can be
+// published.
+func (f *Filter) Check(text string) (string, bool) {
+	lower := strings.ToLower(text)
+	for _, token := range strings.FieldsFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for _, word := range f.words {
+			if strings.HasPrefix(token, word) {
+				return ReasonAbuse, true
+			}
+		}
+	}
+	if linkRX.MatchString(lower) || emailRX.MatchString(lower) || phoneRX.MatchString(lower) || hasLongRun(lower) {
+		return ReasonSpam, true
+	}
+	return "", false
+}
+
+func hasLongRun(text string) bool {
+	var last rune
+	run := 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		if r == last {
+			run++
+		} else {
+			last, run = r, 1
+		}
+		if run > maxRun {
+			return true
+		}
+	}
+	return false
+}
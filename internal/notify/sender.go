@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+)
+
+// EmailSender delivers email notifications. The implementation is selected
+// with EMAIL_SENDER.
+type EmailSender interface {
+	SendEmail(ctx context.Context, to, subject, body string) error
+}
+
+// SMSSender delivers SMS notifications. The implementation is selected with
+// SMS_SENDER; gateways are added to NewSMSSender.
+type SMSSender interface {
+	SendSMS(ctx context.Context, phone, text string) error
+}
+
+// LogSender writes the messages to the log instead of sending them. It is used
+// in development and when no provider is configured.
+type LogSender struct {
+	logger *logrus.Logger
+}
+
+func NewLogSender(logger *logrus.Logger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+func (ls *LogSender) SendEmail(ctx context.Context, to, subject, body string) error {
+	ls.logger.WithFields(logrus.Fields{"to": to, "subject": subject}).Info("email: " + body)
+	return nil
+}
+
+func (ls *LogSender) SendSMS(ctx context.Context, phone, text string) error {
+	ls.logger.WithField("phone", phone).Info("sms: " + text)
+	return nil
+}
+
+// NewEmailSender returns the email sender with the given name.
+func NewEmailSender(name string, logger *logrus.Logger) (EmailSender, error) {
+	switch name {
+	case "", "log":
+		return NewLogSender(logger), nil
+	}
+	return nil, fmt.Errorf("unknown email sender %q", name)
+}
+
+// NewSMSSender returns the SMS sender with the given name.
+func NewSMSSender(name string, logger *logrus.Logger) (SMSSender, error) {
+	switch name {
+	case "", "log":
+		return NewLogSender(logger), nil
+	}
+	return nil, fmt.Errorf("unknown sms sender %q", name)
+}
@@ -0,0 +1,191 @@
+package pdf
+
+import (
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// fallbacks are drawn for letters the Go fonts have no glyphs for, which are
+// mostly the Kazakh letters of the Cyrillic alphabet. The documents keep the
+// original text when it is copied or searched.
+var fallbacks = map[rune]rune{
+	'Ә': 'А', 'ә': 'а', 'Ғ': 'Г', 'ғ': 'г', 'Қ': 'К', 'қ': 'к', 'Ң': 'Н', 'ң': 'н',
+	'Ө': 'О', 'ө': 'о', 'Ұ': 'У', 'ұ': 'у', 'Ү': 'У', 'ү': 'у', 'Һ': 'Х', 'һ': 'х',
+	'І': 'I', 'і': 'i', '₸': 'T', '№': 'N',
+}
+
+var loadFaces = sync.OnceValues(func() ([2]*face, error) {
+	regular, err := newFace("GoRegular", goregular.TTF)
+	if err != nil {
+		return [2]*face{}, err
+	}
+	bold, err := newFace("GoBold", gobold.TTF)
+	if err != nil {
+		return [2]*face{}, err
+	}
+	return [2]*face{regular, bold}, nil
+})
+
+// face is a parsed font. Its metrics are in thousandths of the font size.
+type face struct {
+	name    string
+	ttf     []byte
+	font    *sfnt.Font
+	ppem    fixed.Int26_6
+	bbox    [4]int
+	ascent  int
+	descent int
+	capH    int
+
+	mu      sync.Mutex
+	buf     sfnt.Buffer
+	glyphs  map[rune]sfnt.GlyphIndex
+	advance map[sfnt.GlyphIndex]int
+}
+
+func newFace(name string, ttf []byte) (*face, error) {
+	f, err := sfnt.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	fc := &face{
+		name:    name,
+		ttf:     ttf,
+		font:    f,
+		ppem:    fixed.I(int(f.UnitsPerEm())),
+		glyphs:  make(map[rune]sfnt.GlyphIndex),
+		advance: make(map[sfnt.GlyphIndex]int),
+	}
+	bounds, err := f.Bounds(&fc.buf, fc.ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	fc.bbox = [4]int{fc.scale(bounds.Min.X), -fc.scale(bounds.Max.Y), fc.scale(bounds.Max.X), -fc.scale(bounds.Min.Y)}
+	metrics, err := f.Metrics(&fc.buf, fc.ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	fc.ascent = fc.scale(metrics.Ascent)
+	fc.descent = -fc.scale(metrics.Descent)
+	fc.capH = fc.scale(metrics.CapHeight)
+	return fc, nil
+}
+
+// scale converts font units, read at one pixel per unit, to thousandths of
+// the font size.
+func (fc *face) scale(v fixed.Int26_6) int {
+	return v.Round() * 1000 / int(fc.font.UnitsPerEm())
+}
+
+// glyph returns the glyph drawn for r and its advance width.
+func (fc *face) glyph(r rune) (sfnt.GlyphIndex, int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	gid, ok := fc.glyphs[r]
+	if !ok {
+		gid, _ = fc.font.GlyphIndex(&fc.buf, r)
+		if fallback, ok := fallbacks[r]; ok && gid == 0 {
+			gid, _ = fc.font.GlyphIndex(&fc.buf, fallback)
+		}
+		fc.glyphs[r] = gid
+	}
+	advance, ok := fc.advance[gid]
+	if !ok {
+		a, err := fc.font.GlyphAdvance(&fc.buf, gid, fc.ppem, font.HintingNone)
+		if err == nil {
+			advance = fc.scale(a)
+		}
+		fc.advance[gid] = advance
+	}
+	return gid, advance
+}
+
+func (fc *face) width(s string) int {
+	width := 0
+	for _, r := range s {
+		_, advance := fc.glyph(r)
+		width += advance
+	}
+	return width
+}
+
+func (fc *face) descriptor(fontFileID int) string {
+	return fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		fc.name, fc.bbox[0], fc.bbox[1], fc.bbox[2], fc.bbox[3], fc.ascent, fc.descent, fc.capH, fontFileID)
+}
+
+// fontUse tracks the glyphs a document draws in a face, for the widths and
+// text mapping written with it.
+type fontUse struct {
+	face   *face
+	runes  map[sfnt.GlyphIndex]rune
+	widths map[sfnt.GlyphIndex]int
+}
+
+func newFontUse(fc *face) *fontUse {
+	return &fontUse{face: fc, runes: make(map[sfnt.GlyphIndex]rune), widths: make(map[sfnt.GlyphIndex]int)}
+}
+
+// encode returns s as the hex string of its glyph ids.
+func (u *fontUse) encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		gid, advance := u.face.glyph(r)
+		if _, ok := u.runes[gid]; !ok {
+			u.runes[gid] = r
+			u.widths[gid] = advance
+		}
+		fmt.Fprintf(&b, "%04X", uint16(gid))
+	}
+	return b.String()
+}
+
+func (u *fontUse) gids() []sfnt.GlyphIndex {
+	gids := make([]sfnt.GlyphIndex, 0, len(u.runes))
+	for gid := range u.runes {
+		gids = append(gids, gid)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+	return gids
+}
+
+func (u *fontUse) cidFont(descriptorID int) string {
+	var widths strings.Builder
+	for _, gid := range u.gids() {
+		fmt.Fprintf(&widths, " %d [%d]", gid, u.widths[gid])
+	}
+	return fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW 1000 /W [%s ] /CIDToGIDMap /Identity >>",
+		u.face.name, descriptorID, widths.String())
+}
+
+// toUnicode returns the CMap that maps the glyphs back to text.
+func (u *fontUse) toUnicode() []byte {
+	var b strings.Builder
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	gids := u.gids()
+	for start := 0; start < len(gids); start += 100 {
+		end := min(start+100, len(gids))
+		fmt.Fprintf(&b, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			fmt.Fprintf(&b, "<%04X> <", uint16(gid))
+			for _, unit := range utf16.Encode([]rune{u.runes[gid]}) {
+				fmt.Fprintf(&b, "%04X", unit)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMapResource defineresource pop\nend\nend\n")
+	return []byte(b.String())
+}
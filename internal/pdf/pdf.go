@@ -0,0 +1,248 @@
+// Package pdf writes simple A4 PDF documents of text, lines and boxes. Text is
+// set in the Go fonts, which are embedded in every document and cover Latin
+// and Cyrillic.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// A4 page size in points.
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Style is the weight text is set in.
+type Style int
+
+const (
+	Regular Style = iota
+	Bold
+)
+
+// Align positions text relative to the x it is drawn at.
+type Align int
+
+const (
+	Left Align = iota
+	Right
+	Center
+)
+
+// Document is a PDF being drawn. Coordinates are in points from the top left
+// corner of the page, and text is placed by its baseline.
+type Document struct {
+	faces [2]*face
+	fonts [2]*fontUse
+	pages []*bytes.Buffer
+}
+
+// New returns a document with one empty page.
+func New() (*Document, error) {
+	faces, err := loadFaces()
+	if err != nil {
+		return nil, err
+	}
+	d := &Document{faces: faces}
+	d.AddPage()
+	return d, nil
+}
+
+// AddPage starts a new page; later drawing goes on it.
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+// PageCount returns the number of pages.
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+func (d *Document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+func (d *Document) font(style Style) *fontUse {
+	if d.fonts[style] == nil {
+		d.fonts[style] = newFontUse(d.faces[style])
+	}
+	return d.fonts[style]
+}
+
+// Text draws s at size points with its baseline at y.
+func (d *Document) Text(x, y, size float64, style Style, align Align, s string) {
+	if s == "" {
+		return
+	}
+	switch align {
+	case Right:
+		x -= d.TextWidth(s, size, style)
+	case Center:
+		x -= d.TextWidth(s, size, style) / 2
+	}
+	fmt.Fprintf(d.page(), "BT /F%d %s Tf %s %s Td <%s> Tj ET\n",
+		style+1, number(size), number(x), number(PageHeight-y), d.font(style).encode(s))
+}
+
+// TextWidth returns the width of s set at size points.
+func (d *Document) TextWidth(s string, size float64, style Style) float64 {
+	return float64(d.faces[style].width(s)) * size / 1000
+}
+
+// Wrap breaks s into lines no wider than width, at spaces where possible.
+func (d *Document) Wrap(s string, width, size float64, style Style) []string {
+	var lines []string
+	line := []rune{}
+	lastSpace := -1
+	for _, r := range s {
+		if r == '\n' {
+			lines = append(lines, string(line))
+			line, lastSpace = line[:0:0], -1
+			continue
+		}
+		line = append(line, r)
+		if r == ' ' {
+			lastSpace = len(line) - 1
+		}
+		if len(line) > 1 && d.TextWidth(string(line), size, style) > width {
+			if lastSpace > 0 {
+				lines = append(lines, string(line[:lastSpace]))
+				line = append([]rune{}, line[lastSpace+1:]...)
+			} else {
+				lines = append(lines, string(line[:len(line)-1]))
+				line = []rune{r}
+			}
+			lastSpace = -1
+			for i, c := range line {
+				if c == ' ' {
+					lastSpace = i
+				}
+			}
+		}
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// Line draws a line of width points.
+func (d *Document) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%s w %s %s m %s %s l S\n",
+		number(width), number(x1), number(PageHeight-y1), number(x2), number(PageHeight-y2))
+}
+
+// Rect outlines the box with its top left corner at x, y.
+func (d *Document) Rect(x, y, w, h, width float64) {
+	fmt.Fprintf(d.page(), "%s w %s %s %s %s re S\n",
+		number(width), number(x), number(PageHeight-y-h), number(w), number(h))
+}
+
+// FillRect fills the box with a shade of gray from 0, black, to 1, white.
+func (d *Document) FillRect(x, y, w, h, gray float64) {
+	fmt.Fprintf(d.page(), "q %s g %s %s %s %s re f Q\n",
+		number(gray), number(x), number(PageHeight-y-h), number(w), number(h))
+}
+
+// WriteTo writes the document as a PDF file.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var objects [][]byte
+	add := func(object []byte) int {
+		objects = append(objects, object)
+		return len(objects)
+	}
+	reserve := func() int {
+		return add(nil)
+	}
+
+	catalog := reserve()
+	pages := reserve()
+
+	fontRefs := ""
+	for style, use := range d.fonts {
+		if use == nil {
+			continue
+		}
+		fontFile, err := stream("/Length1 "+strconv.Itoa(len(use.face.ttf)), use.face.ttf)
+		if err != nil {
+			return 0, err
+		}
+		fontFileID := add(fontFile)
+		descriptorID := add([]byte(use.face.descriptor(fontFileID)))
+		cidFontID := add([]byte(use.cidFont(descriptorID)))
+		toUnicode, err := stream("", use.toUnicode())
+		if err != nil {
+			return 0, err
+		}
+		toUnicodeID := add(toUnicode)
+		fontID := add([]byte(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+			use.face.name, cidFontID, toUnicodeID)))
+		fontRefs += fmt.Sprintf(" /F%d %d 0 R", style+1, fontID)
+	}
+
+	kids := ""
+	for _, content := range d.pages {
+		contents, err := stream("", content.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		contentsID := add(contents)
+		pageID := add([]byte(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /Font <<%s >> >> /Contents %d 0 R >>",
+			pages, number(PageWidth), number(PageHeight), fontRefs, contentsID)))
+		kids += fmt.Sprintf(" %d 0 R", pageID)
+	}
+	objects[catalog-1] = []byte(fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+	objects[pages-1] = []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s ] /Count %d >>", kids, len(d.pages)))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(object)
+		out.WriteString("\nendobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, catalog, xref)
+	return out.WriteTo(w)
+}
+
+// Bytes returns the document as a PDF file.
+func (d *Document) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// stream returns a compressed stream object with the extra dictionary entries.
+func stream(entries string, data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	var object bytes.Buffer
+	fmt.Fprintf(&object, "<< /Length %d /Filter /FlateDecode %s>>\nstream\n", compressed.Len(), entries)
+	compressed.WriteTo(&object)
+	object.WriteString("\nendstream")
+	return object.Bytes(), nil
+}
+
+func number(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"gorm.io/gorm"
 	"marketplace-api/internal/models"
+	"time"
 )
 
 type CartRepository struct {
@@ -45,6 +46,10 @@ func (cr *CartRepository) UpdateCart(cart *models.Cart) error {
 	return cr.db.Where("id = ?", cart.ID).Updates(&cart).Error
 }
 
+func (cr *CartRepository) SetCouponCode(cartID int64, code string) error {
+	return cr.db.Model(&models.Cart{}).Where("id = ?", cartID).Update("coupon_code", code).Error
+}
+
 func (cr *CartRepository) RemoveCartItem(cartID int64, productID int64) error {
 	return cr.db.Where("product_id = ? AND cart_id = ?", productID, cartID).Delete(&models.CartItem{}).Error
 }
@@ -66,6 +71,14 @@ func (cr *CartRepository) DeleteCart(storeID int64) error {
 	return nil
 }
 
+func (cr *CartRepository) GetIdleCarts(before time.Time) ([]models.Cart, error) {
+	var carts []models.Cart
+	if err := cr.db.Where("updated_at < ?", before).Find(&carts).Error; err != nil {
+		return nil, err
+	}
+	return carts, nil
+}
+
 func (cr *CartRepository) GetCartItems(cartID int64) ([]models.CartItem, error) {
 	var cartItems []models.CartItem
 	if err := cr.db.Where("cart_id = ?", cartID).Find(&cartItems).Error; err != nil {
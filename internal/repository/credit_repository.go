@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+// outstandingSQL is the unpaid balance of the store's invoices with the
+// distributor of a credit account. Overpaid invoices do not count as credit.
+const outstandingSQL = `COALESCE((SELECT SUM(GREATEST(invoices.amount - invoices.paid_amount, 0)) FROM invoices
+	WHERE invoices.distributor_id = credit_accounts.distributor_id AND invoices.store_id = credit_accounts.store_id), 0)`
+
+const agingSQL = `SELECT i.store_id, stores.name AS store_name, COALESCE(credit_accounts.credit_limit, 0) AS credit_limit,
+	ROUND(SUM(CASE WHEN i.due_at >= @as_of THEN i.balance ELSE 0 END)::numeric, 2) AS "current",
+	ROUND(SUM(CASE WHEN i.due_at < @as_of AND i.due_at >= @due_30 THEN i.balance ELSE 0 END)::numeric, 2) AS days_1_30,
+	ROUND(SUM(CASE WHEN i.due_at < @due_30 AND i.due_at >= @due_60 THEN i.balance ELSE 0 END)::numeric, 2) AS days_31_60,
+	ROUND(SUM(CASE WHEN i.due_at < @due_60 AND i.due_at >= @due_90 THEN i.balance ELSE 0 END)::numeric, 2) AS days_61_90,
+	ROUND(SUM(CASE WHEN i.due_at < @due_90 THEN i.balance ELSE 0 END)::numeric, 2) AS over_90,
+	ROUND(SUM(i.balance)::numeric, 2) AS total
+FROM (
+	SELECT store_id, due_at, GREATEST(amount - paid_amount, 0) AS balance
+	FROM invoices WHERE distributor_id = @distributor_id AND issued_at <= @as_of
+) i
+JOIN stores ON stores.id = i.store_id
+LEFT JOIN credit_accounts ON credit_accounts.distributor_id = @distributor_id AND credit_accounts.store_id = i.store_id
+WHERE i.balance > 0
+GROUP BY i.store_id, stores.name, credit_accounts.credit_limit
+ORDER BY total DESC, i.store_id`
+
+type CreditRepository struct {
+	db *gorm.DB
+}
+
+func NewCreditRepository(db *gorm.DB) *CreditRepository {
+	return &CreditRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (cr *CreditRepository) WithTx(tx *gorm.DB) *CreditRepository {
+	return &CreditRepository{db: tx}
+}
+
+// GetCreditAccounts returns the credit accounts of the distributor or the
+// store with their outstanding balances.
+func (cr *CreditRepository) GetCreditAccounts(userID int64, role string) ([]models.CreditAccount, error) {
+	var accounts []models.CreditAccount
+	if err := cr.db.Model(&models.CreditAccount{}).
+		Select("credit_accounts.*, stores.name AS store_name, distributors.name AS distributor_name, "+outstandingSQL+" AS outstanding").
+		Joins("JOIN stores ON stores.id = credit_accounts.store_id").
+		Joins("JOIN distributors ON distributors.id = credit_accounts.distributor_id").
+		Where("credit_accounts."+role+"_id = ?", userID).
+		Order("credit_accounts.created_at").
+		Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (cr *CreditRepository) GetCreditAccount(distributorID, storeID int64) (*models.CreditAccount, error) {
+	var account models.CreditAccount
+	if err := cr.db.Select("credit_accounts.*, "+outstandingSQL+" AS outstanding").
+		Where("distributor_id = ? AND store_id = ?", distributorID, storeID).
+		First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// LockCreditAccount returns the credit account and locks it until the
+// transaction ends, so concurrent checkouts of the store are checked against
+// the limit one after another.
+func (cr *CreditRepository) LockCreditAccount(distributorID, storeID int64) (*models.CreditAccount, error) {
+	var account models.CreditAccount
+	if err := cr.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("distributor_id = ? AND store_id = ?", distributorID, storeID).
+		First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// GetOutstanding returns the unpaid balance of the store's invoices with the
+// distributor.
+func (cr *CreditRepository) GetOutstanding(distributorID, storeID int64) (float64, error) {
+	var outstanding float64
+	if err := cr.db.Model(&models.Invoice{}).
+		Select("COALESCE(SUM(GREATEST(amount - paid_amount, 0)), 0)").
+		Where("distributor_id = ? AND store_id = ?", distributorID, storeID).
+		Scan(&outstanding).Error; err != nil {
+		return 0, err
+	}
+	return outstanding, nil
+}
+
+// SaveCreditAccount creates the credit account or updates its terms.
+func (cr *CreditRepository) SaveCreditAccount(account *models.CreditAccount) error {
+	return cr.db.Omit("Distributor", "Store").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "distributor_id"}, {Name: "store_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"credit_limit", "payment_terms_days", "over_limit_action", "updated_at"}),
+	}).Create(account).Error
+}
+
+func (cr *CreditRepository) DeleteCreditAccount(distributorID, storeID int64) error {
+	result := cr.db.Where("distributor_id = ? AND store_id = ?", distributorID, storeID).Delete(&models.CreditAccount{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAging returns the unpaid invoice balances of each store with the
+// distributor by days past due.
+func (cr *CreditRepository) GetAging(distributorID int64, asOf time.Time) ([]models.StoreAging, error) {
+	var rows []models.StoreAging
+	if err := cr.db.Raw(agingSQL, map[string]interface{}{
+		"distributor_id": distributorID,
+		"as_of":          asOf,
+		"due_30":         asOf.AddDate(0, 0, -30),
+		"due_60":         asOf.AddDate(0, 0, -60),
+		"due_90":         asOf.AddDate(0, 0, -90),
+	}).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+var ErrDeliverySlotFull = errors.New("delivery slot is fully booked")
+
+type DeliveryRepository struct {
+	db *gorm.DB
+}
+
+func NewDeliveryRepository(db *gorm.DB) *DeliveryRepository {
+	return &DeliveryRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (dr *DeliveryRepository) WithTx(tx *gorm.DB) *DeliveryRepository {
+	return &DeliveryRepository{db: tx}
+}
+
+func (dr *DeliveryRepository) CreateSlot(slot *models.DeliverySlot) error {
+	return dr.db.Create(slot).Error
+}
+
+func (dr *DeliveryRepository) UpdateSlot(slot *models.DeliverySlot) error {
+	return dr.db.Model(slot).
+		Select("city", "weekday", "start_time", "end_time", "capacity", "cutoff_hours", "active").
+		Where("id = ?", slot.ID).
+		Updates(slot).Error
+}
+
+func (dr *DeliveryRepository) DeleteSlot(slotID int64) error {
+	return dr.db.Delete(&models.DeliverySlot{}, slotID).Error
+}
+
+func (dr *DeliveryRepository) GetSlotByID(slotID int64) (*models.DeliverySlot, error) {
+	var slot models.DeliverySlot
+	if err := dr.db.First(&slot, slotID).Error; err != nil {
+		return nil, err
+	}
+	return &slot, nil
+}
+
+func (dr *DeliveryRepository) GetSlotsByDistributorID(distributorID int64, city string) ([]models.DeliverySlot, error) {
+	var slots []models.DeliverySlot
+	if err := dr.db.Where("distributor_id = ? AND (LOWER(city) = LOWER(?) OR ? = '')", distributorID, city, city).
+		Order("weekday ASC").
+		Order("start_time ASC").
+		Find(&slots).Error; err != nil {
+		return nil, err
+	}
+	return slots, nil
+}
+
+func (dr *DeliveryRepository) GetBooked(slotID int64, date time.Time) (int64, error) {
+	var usage models.DeliverySlotUsage
+	err := dr.db.Where("slot_id = ? AND date = ?", slotID, date.Format(models.DeliveryDateLayout)).First(&usage).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return usage.Booked, nil
+}
+
+// ReserveSlot books one delivery in the slot on the given date. The conditional
+// update keeps concurrent checkouts from overbooking the slot.
+func (dr *DeliveryRepository) ReserveSlot(slot *models.DeliverySlot, date time.Time) error {
+	day := date.Format(models.DeliveryDateLayout)
+	return dr.db.Transaction(func(tx *gorm.DB) error {
+		usage := &models.DeliverySlotUsage{SlotID: slot.ID, Date: day}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(usage).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.DeliverySlotUsage{}).
+			Where("slot_id = ? AND date = ? AND booked < ?", slot.ID, day, slot.Capacity).
+			UpdateColumn("booked", gorm.Expr("booked + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDeliverySlotFull
+		}
+		return nil
+	})
+}
+
+func (dr *DeliveryRepository) ReleaseSlot(slotID int64, date time.Time) error {
+	return dr.db.Model(&models.DeliverySlotUsage{}).
+		Where("slot_id = ? AND date = ? AND booked > 0", slotID, date.Format(models.DeliveryDateLayout)).
+		UpdateColumn("booked", gorm.Expr("booked - 1")).Error
+}
@@ -48,7 +48,7 @@ func (sr *DistributorRepository) GetStoreByID(id int64) (*models.Store, error) {
 func (sr *DistributorRepository) UpdateDistributor(distributorID int64, updatedDistributor *models.Distributor) error {
 	updatedDistributor.ID = distributorID
 	updatedDistributor.UserID = distributorID
-	if err := sr.db.Where("id = ?", distributorID).Updates(&updatedDistributor).Error; err != nil {
+	if err := sr.db.Where("id = ?", distributorID).Omit("vat_payer", "vat_rate", "prices_include_vat").Updates(&updatedDistributor).Error; err != nil {
 		return err
 	}
 	if updatedDistributor.ImgUrl == "" {
@@ -57,6 +57,15 @@ func (sr *DistributorRepository) UpdateDistributor(distributorID int64, updatedD
 	return nil
 }
 
+// UpdateTaxSettings replaces the VAT settings of the distributor.
+func (sr *DistributorRepository) UpdateTaxSettings(distributorID int64, settings models.TaxSettings) error {
+	return sr.db.Model(&models.Distributor{}).Where("id = ?", distributorID).Updates(map[string]interface{}{
+		"vat_payer":          settings.VATPayer,
+		"vat_rate":           settings.VATRate,
+		"prices_include_vat": settings.PricesIncludeVAT,
+	}).Error
+}
+
 func (sr *DistributorRepository) DeleteDistributor(distributorID int64) error {
 	distributor, err := sr.GetDistributorByID(distributorID)
 	if err != nil {
@@ -88,3 +97,13 @@ func (sr *DistributorRepository) GetEmail(userID int64) (string, error) {
 	}
 	return user.Email, nil
 }
+
+// GetServedCities returns the cities distributors are based in or deliver to.
+func (sr *DistributorRepository) GetServedCities() ([]string, error) {
+	var cities []string
+	if err := sr.db.Raw("SELECT city FROM distributors WHERE city <> '' UNION SELECT city FROM delivery_slots WHERE active AND city <> '' ORDER BY city").
+		Scan(&cities).Error; err != nil {
+		return nil, err
+	}
+	return cities, nil
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+)
+
+type DocumentRepository struct {
+	db *gorm.DB
+}
+
+func NewDocumentRepository(db *gorm.DB) *DocumentRepository {
+	return &DocumentRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (dr *DocumentRepository) WithTx(tx *gorm.DB) *DocumentRepository {
+	return &DocumentRepository{db: tx}
+}
+
+// GetDocument returns the document of the kind issued for the invoice.
+func (dr *DocumentRepository) GetDocument(invoiceID int64, kind string) (*models.Document, error) {
+	var document models.Document
+	if err := dr.db.Where("invoice_id = ? AND kind = ?", invoiceID, kind).First(&document).Error; err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+func (dr *DocumentRepository) CreateDocument(document *models.Document) error {
+	return dr.db.Omit("Invoice").Create(document).Error
+}
+
+// GetLegacyContent returns the PDF of a document issued while documents were
+// kept in the database.
+func (dr *DocumentRepository) GetLegacyContent(documentID int64) ([]byte, error) {
+	var content []byte
+	if err := dr.db.Raw(`SELECT content FROM documents WHERE id = ?`, documentID).Row().Scan(&content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// MoveDocument records the key the PDF of a document issued while documents
+// were kept in the database is now stored under, and drops the old copy. It
+// reports false if the document was moved already.
+func (dr *DocumentRepository) MoveDocument(documentID int64, key string) (bool, error) {
+	result := dr.db.Exec(`UPDATE documents SET key = ?, content = NULL WHERE id = ? AND key = ''`, key, documentID)
+	return result.RowsAffected > 0, result.Error
+}
+
+// NextNumber takes the next number of the distributor's sequence. It must run
+// inside the transaction that uses the number.
+func (dr *DocumentRepository) NextNumber(distributorID int64, name string) (int64, error) {
+	return nextSequenceValues(dr.db, distributorID, name, 1)
+}
+
+// nextSequenceValues takes count consecutive values of the sequence, creating
+// it if needed, and returns the first of them. The row stays locked until the
+// transaction ends, so values are handed out without gaps or duplicates.
+func nextSequenceValues(db *gorm.DB, distributorID int64, name string, count int64) (int64, error) {
+	var last int64
+	err := db.Raw(`INSERT INTO sequences (distributor_id, name, last_value) VALUES (?, ?, ?)
+		ON CONFLICT (distributor_id, name) DO UPDATE SET last_value = sequences.last_value + EXCLUDED.last_value
+		RETURNING last_value`, distributorID, name, count).Scan(&last).Error
+	if err != nil {
+		return 0, err
+	}
+	return last - count + 1, nil
+}
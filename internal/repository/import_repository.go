@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+)
+
+type ImportRepository struct {
+	db *gorm.DB
+}
+
+func NewImportRepository(db *gorm.DB) *ImportRepository {
+	return &ImportRepository{db: db}
+}
+
+func (ir *ImportRepository) CreateImport(productImport *models.ProductImport) error {
+	return ir.db.Omit("Errors").Create(productImport).Error
+}
+
+// UpdateImport saves the status and the counters of the import.
+func (ir *ImportRepository) UpdateImport(productImport *models.ProductImport) error {
+	return ir.db.Model(productImport).
+		Select("status", "total_rows", "processed", "created", "updated", "failed", "error", "finished_at").
+		Updates(productImport).Error
+}
+
+func (ir *ImportRepository) AddImportErrors(errors []models.ProductImportError) error {
+	if len(errors) == 0 {
+		return nil
+	}
+	return ir.db.CreateInBatches(errors, 500).Error
+}
+
+func (ir *ImportRepository) GetImportByID(importID int64) (*models.ProductImport, error) {
+	var productImport models.ProductImport
+	err := ir.db.Preload("Errors", func(db *gorm.DB) *gorm.DB {
+		return db.Order("row, id")
+	}).First(&productImport, importID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &productImport, nil
+}
+
+func (ir *ImportRepository) GetImportsByDistributorID(distributorID int64, filters models.Filters) ([]models.ProductImport, models.Metadata, error) {
+	var total int64
+	query := ir.db.Model(&models.ProductImport{}).Where("distributor_id = ?", distributorID)
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var imports []models.ProductImport
+	err := query.Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Limit(filters.Limit()).
+		Offset(filters.Offset()).
+		Find(&imports).Error
+	if err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return imports, models.CalculateMetadata(int(total), filters.Page, filters.PageSize), nil
+}
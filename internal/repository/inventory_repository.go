@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+)
+
+var ErrInsufficientStock = errors.New("not enough quantity in stock")
+
+type InventoryRepository struct {
+	db *gorm.DB
+}
+
+func NewInventoryRepository(db *gorm.DB) *InventoryRepository {
+	return &InventoryRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (ir *InventoryRepository) WithTx(tx *gorm.DB) *InventoryRepository {
+	return &InventoryRepository{db: tx}
+}
+
+func (ir *InventoryRepository) CreateWarehouse(warehouse *models.Warehouse) error {
+	return ir.db.Create(warehouse).Error
+}
+
+func (ir *InventoryRepository) UpdateWarehouse(warehouse *models.Warehouse) error {
+	return ir.db.Model(warehouse).
+		Select("name", "city", "address", "cities", "active").
+		Where("id = ?", warehouse.ID).
+		Updates(warehouse).Error
+}
+
+func (ir *InventoryRepository) DeleteWarehouse(warehouseID int64) error {
+	return ir.db.Delete(&models.Warehouse{}, warehouseID).Error
+}
+
+func (ir *InventoryRepository) GetWarehouseByID(warehouseID int64) (*models.Warehouse, error) {
+	var warehouse models.Warehouse
+	if err := ir.db.First(&warehouse, warehouseID).Error; err != nil {
+		return nil, err
+	}
+	return &warehouse, nil
+}
+
+func (ir *InventoryRepository) GetWarehouses(distributorID int64) ([]models.Warehouse, error) {
+	var warehouses []models.Warehouse
+	if err := ir.db.Where("distributor_id = ?", distributorID).Order("id ASC").Find(&warehouses).Error; err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+func (ir *InventoryRepository) HasMovements(warehouseID int64) (bool, error) {
+	var count int64
+	if err := ir.db.Model(&models.StockMovement{}).Where("warehouse_id = ?", warehouseID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (ir *InventoryRepository) GetStockLevels(productID int64) ([]models.StockLevel, error) {
+	return stockLevels(ir.db, productID)
+}
+
+func (ir *InventoryRepository) GetWarehouseStockLevels(warehouseID int64) ([]models.StockLevel, error) {
+	var levels []models.StockLevel
+	if err := ir.db.Model(&models.StockMovement{}).
+		Select("warehouse_id, product_id, SUM(on_hand_delta) AS on_hand, SUM(reserved_delta) AS reserved, SUM(on_hand_delta - reserved_delta) AS available").
+		Where("warehouse_id = ?", warehouseID).
+		Group("warehouse_id, product_id").
+		Order("product_id ASC").
+		Scan(&levels).Error; err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+func (ir *InventoryRepository) GetMovements(distributorID, productID, warehouseID int64, filters models.Filters) ([]models.StockMovement, models.Metadata, error) {
+	query := ir.db.Model(&models.StockMovement{}).
+		Joins("JOIN warehouses ON warehouses.id = stock_movements.warehouse_id").
+		Where("warehouses.distributor_id = ?", distributorID).
+		Where("(stock_movements.product_id = ? OR ? = 0)", productID, productID).
+		Where("(stock_movements.warehouse_id = ? OR ? = 0)", warehouseID, warehouseID).
+		Session(&gorm.Session{})
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var movements []models.StockMovement
+	if err := query.Select("stock_movements.*").
+		Order("stock_movements." + filters.SortColumn() + " " + filters.SortDirection()).
+		Order("stock_movements.id DESC").
+		Limit(filters.Limit()).
+		Offset(filters.Offset()).
+		Find(&movements).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return movements, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// GetAvailable returns how much of the product can still be reserved in the warehouses.
+func (ir *InventoryRepository) GetAvailable(productID int64, warehouses []models.Warehouse) (int64, error) {
+	levels, err := stockLevels(ir.db, productID)
+	if err != nil {
+		return 0, err
+	}
+	byWarehouse := levelsByWarehouse(levels)
+	var available int64
+	for _, warehouse := range warehouses {
+		available += byWarehouse[warehouse.ID].Available
+	}
+	return available, nil
+}
+
+// AppendMovement records a manual movement, refusing ones that would leave the
+// warehouse with less on hand than is reserved.
+func (ir *InventoryRepository) AppendMovement(movement *models.StockMovement) error {
+	return ir.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, movement.ProductID); err != nil {
+			return err
+		}
+		levels, err := stockLevels(tx, movement.ProductID)
+		if err != nil {
+			return err
+		}
+		level := levelsByWarehouse(levels)[movement.WarehouseID]
+		if level.Available+movement.OnHandDelta-movement.ReservedDelta < 0 {
+			return ErrInsufficientStock
+		}
+		if err := tx.Create(movement).Error; err != nil {
+			return err
+		}
+		return refreshProductStock(tx, movement.ProductID)
+	})
+}
+
+// Reserve allocates the order quantity from the warehouses in the given order
+// of preference. Either the whole quantity is reserved or nothing is.
+func (ir *InventoryRepository) Reserve(order *models.Order, productID int64, warehouses []models.Warehouse) error {
+	return ir.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+		levels, err := stockLevels(tx, productID)
+		if err != nil {
+			return err
+		}
+		byWarehouse := levelsByWarehouse(levels)
+		remaining := order.Quantity
+		var movements []models.StockMovement
+		for _, warehouse := range warehouses {
+			available := byWarehouse[warehouse.ID].Available
+			if available <= 0 {
+				continue
+			}
+			if available > remaining {
+				available = remaining
+			}
+			movements = append(movements, models.StockMovement{
+				WarehouseID:   warehouse.ID,
+				ProductID:     productID,
+				OrderID:       &order.ID,
+				Type:          models.MovementReservation,
+				ReservedDelta: available,
+				CreatedBy:     order.StoreID,
+			})
+			remaining -= available
+			if remaining == 0 {
+				break
+			}
+		}
+		if remaining > 0 || len(movements) == 0 {
+			return ErrInsufficientStock
+		}
+		if err := tx.Create(&movements).Error; err != nil {
+			return err
+		}
+		return refreshProductStock(tx, productID)
+	})
+}
+
+// Settle turns the outstanding reservations of the order into sales or
+// cancellations. Settling an order twice has no effect.
+func (ir *InventoryRepository) Settle(order *models.Order, productID int64, movementType string) error {
+	return ir.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+		var outstanding []struct {
+			WarehouseID int64
+			Reserved    int64
+		}
+		if err := tx.Model(&models.StockMovement{}).
+			Select("warehouse_id, SUM(reserved_delta) AS reserved").
+			Where("order_id = ? AND product_id = ?", order.ID, productID).
+			Group("warehouse_id").
+			Having("SUM(reserved_delta) > 0").
+			Scan(&outstanding).Error; err != nil {
+			return err
+		}
+		if len(outstanding) == 0 {
+			return nil
+		}
+		var movements []models.StockMovement
+		for _, reservation := range outstanding {
+			movement := models.StockMovement{
+				WarehouseID:   reservation.WarehouseID,
+				ProductID:     productID,
+				OrderID:       &order.ID,
+				Type:          movementType,
+				ReservedDelta: -reservation.Reserved,
+			}
+			if movementType == models.MovementSale {
+				movement.OnHandDelta = -reservation.Reserved
+			}
+			movements = append(movements, movement)
+		}
+		if err := tx.Create(&movements).Error; err != nil {
+			return err
+		}
+		return refreshProductStock(tx, productID)
+	})
+}
+
+// lockProduct locks the product for a change of its stock. Deleted products
+// are locked too, so the orders still holding their stock can be settled.
+func lockProduct(tx *gorm.DB, productID int64) error {
+	var product models.Product
+	return tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&product, productID).Error
+}
+
+func stockLevels(db *gorm.DB, productID int64) ([]models.StockLevel, error) {
+	var levels []models.StockLevel
+	if err := db.Model(&models.StockMovement{}).
+		Select("warehouse_id, product_id, SUM(on_hand_delta) AS on_hand, SUM(reserved_delta) AS reserved, SUM(on_hand_delta - reserved_delta) AS available").
+		Where("product_id = ?", productID).
+		Group("warehouse_id, product_id").
+		Order("warehouse_id ASC").
+		Scan(&levels).Error; err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+func levelsByWarehouse(levels []models.StockLevel) map[int64]models.StockLevel {
+	byWarehouse := make(map[int64]models.StockLevel, len(levels))
+	for _, level := range levels {
+		byWarehouse[level.WarehouseID] = level
+	}
+	return byWarehouse
+}
+
+// refreshProductStock keeps Product.Stock equal to the quantity available
+// across all warehouses.
+func refreshProductStock(tx *gorm.DB, productID int64) error {
+	return tx.Exec(`UPDATE products SET stock = COALESCE(
+		(SELECT SUM(on_hand_delta - reserved_delta) FROM stock_movements WHERE product_id = ?), 0)
+		WHERE id = ?`, productID, productID).Error
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+// paymentStatusSQL computes models.Invoice.Settle in SQL, so invoices and
+// orders can be filtered by payment status.
+const paymentStatusSQL = `CASE
+	WHEN invoices.amount = 0 AND invoices.paid_amount = 0 THEN 'void'
+	WHEN ROUND((invoices.amount - invoices.paid_amount)::numeric, 2) <= 0 THEN 'paid'
+	WHEN invoices.due_at < NOW() THEN 'overdue'
+	WHEN invoices.paid_amount > 0 THEN 'partially_paid'
+	ELSE 'unpaid' END`
+
+type InvoiceRepository struct {
+	db *gorm.DB
+}
+
+func NewInvoiceRepository(db *gorm.DB) *InvoiceRepository {
+	return &InvoiceRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (ir *InvoiceRepository) WithTx(tx *gorm.DB) *InvoiceRepository {
+	return &InvoiceRepository{db: tx}
+}
+
+func (ir *InvoiceRepository) CreateInvoice(invoice *models.Invoice) error {
+	return ir.db.Omit("Orders", "Payments").Create(invoice).Error
+}
+
+// AttachOrders bills the orders on the invoice and returns how many of them
+// were not invoiced yet.
+func (ir *InvoiceRepository) AttachOrders(invoiceID int64, orderIDs []int64) (int64, error) {
+	result := ir.db.Model(&models.Order{}).
+		Where("id IN ? AND invoice_id IS NULL", orderIDs).
+		Update("invoice_id", invoiceID)
+	return result.RowsAffected, result.Error
+}
+
+// LockOrders returns the orders of the distributor and locks them until the
+// transaction ends.
+func (ir *InvoiceRepository) LockOrders(distributorID int64, orderIDs []int64) ([]models.Order, error) {
+	var orders []models.Order
+	if err := ir.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Stage").
+		Where("id IN ? AND distributor_id = ?", orderIDs, distributorID).
+		Order("id").
+		Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// LockInvoice returns the invoice of the distributor and locks it until the
+// transaction ends, so concurrent payments add up.
+func (ir *InvoiceRepository) LockInvoice(distributorID, invoiceID int64) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := ir.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND distributor_id = ?", invoiceID, distributorID).
+		First(&invoice).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// GetInvoice returns the invoice of the distributor or the store with its
+// orders and payments.
+func (ir *InvoiceRepository) GetInvoice(userID, invoiceID int64, role string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := ir.db.Preload("Orders", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Orders.Stage").
+		Preload("Payments", func(db *gorm.DB) *gorm.DB { return db.Order("paid_at, id") }).
+		Where("id = ? AND "+role+"_id = ?", invoiceID, userID).
+		First(&invoice).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// GetInvoices returns the invoices of the distributor or the store, optionally
+// only those of one counterparty or payment status.
+func (ir *InvoiceRepository) GetInvoices(userID int64, role string, counterpartyID int64, paymentStatus string, filters models.Filters) ([]models.Invoice, models.Metadata, error) {
+	query := ir.db.Model(&models.Invoice{}).Where("invoices."+role+"_id = ?", userID)
+	if counterpartyID > 0 {
+		counterparty := "distributor"
+		if role == "distributor" {
+			counterparty = "store"
+		}
+		query = query.Where("invoices."+counterparty+"_id = ?", counterpartyID)
+	}
+	if paymentStatus != "" {
+		query = query.Where(paymentStatusSQL+" = ?", paymentStatus)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var invoices []models.Invoice
+	if err := query.Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Order("id").
+		Limit(filters.Limit()).Offset(filters.Offset()).
+		Find(&invoices).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return invoices, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// GetInvoicesByID returns the invoices with the given IDs.
+func (ir *InvoiceRepository) GetInvoicesByID(invoiceIDs []int64) ([]models.Invoice, error) {
+	var invoices []models.Invoice
+	if len(invoiceIDs) == 0 {
+		return invoices, nil
+	}
+	if err := ir.db.Where("id IN ?", invoiceIDs).Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+func (ir *InvoiceRepository) CreatePayment(payment *models.Payment) error {
+	return ir.db.Create(payment).Error
+}
+
+func (ir *InvoiceRepository) UpdatePaidAmount(invoice *models.Invoice) error {
+	return ir.db.Model(invoice).Updates(map[string]interface{}{
+		"paid_amount": invoice.PaidAmount,
+		"paid_at":     invoice.PaidAt,
+	}).Error
+}
+
+// RecalculateAmount sets the amounts of the invoice to the totals of its
+// orders that are not cancelled and returns the invoice.
+func (ir *InvoiceRepository) RecalculateAmount(invoiceID int64) (*models.Invoice, error) {
+	err := ir.db.Exec(`UPDATE invoices SET amount = ROUND(COALESCE(totals.gross, 0)::numeric, 2),
+		net_amount = ROUND(COALESCE(totals.net, 0)::numeric, 2),
+		tax_amount = ROUND(COALESCE(totals.tax, 0)::numeric, 2)
+	FROM (
+		SELECT SUM(orders.total_price) AS gross, SUM(orders.net_amount) AS net, SUM(orders.tax_amount) AS tax
+		FROM orders JOIN stages ON stages.id = orders.stage_id
+		WHERE orders.invoice_id = ? AND stages.status <> ?
+	) AS totals WHERE invoices.id = ?`, invoiceID, models.StageStatusError, invoiceID).Error
+	if err != nil {
+		return nil, err
+	}
+	var invoice models.Invoice
+	if err := ir.db.First(&invoice, invoiceID).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// SetPaidAt records when the invoice was settled, or clears it when a refund
+// or a changed amount leaves it unpaid again.
+func (ir *InvoiceRepository) SetPaidAt(invoiceID int64, paidAt *time.Time) error {
+	return ir.db.Model(&models.Invoice{}).Where("id = ?", invoiceID).Update("paid_at", paidAt).Error
+}
+
+// ordersWithPaymentStatus narrows an order query to the orders whose invoice
+// has the payment status.
+func ordersWithPaymentStatus(db *gorm.DB, paymentStatus string) *gorm.DB {
+	return db.Where("orders.invoice_id IN (SELECT invoices.id FROM invoices WHERE "+paymentStatusSQL+" = ?)", paymentStatus)
+}
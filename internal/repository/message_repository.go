@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+// ThreadQuery selects threads. Zero fields match every thread.
+type ThreadQuery struct {
+	StoreID       int64
+	DistributorID int64
+	OrderID       int64
+	ProductID     int64
+	// ReaderID is the user unread counts are computed for.
+	ReaderID int64
+}
+
+type MessageRepository struct {
+	db *gorm.DB
+}
+
+func NewMessageRepository(db *gorm.DB) *MessageRepository {
+	return &MessageRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (mr *MessageRepository) WithTx(tx *gorm.DB) *MessageRepository {
+	return &MessageRepository{db: tx}
+}
+
+func (mr *MessageRepository) CreateThread(thread *models.MessageThread) error {
+	return mr.db.Omit("Store", "Distributor", "Order", "Product", "Messages").Create(thread).Error
+}
+
+func (mr *MessageRepository) GetThreadByID(threadID, readerID int64) (*models.MessageThread, error) {
+	var thread models.MessageThread
+	if err := mr.db.Select("message_threads.*, ("+unreadCount+") AS unread_count", readerID).
+		First(&thread, threadID).Error; err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+
+func (mr *MessageRepository) GetThreads(q ThreadQuery, filters models.Filters) ([]models.MessageThread, models.Metadata, error) {
+	query := mr.db.Model(&models.MessageThread{}).
+		Where("(store_id = ? OR ? = 0)", q.StoreID, q.StoreID).
+		Where("(distributor_id = ? OR ? = 0)", q.DistributorID, q.DistributorID).
+		Where("(order_id = ? OR ? = 0)", q.OrderID, q.OrderID).
+		Where("(product_id = ? OR ? = 0)", q.ProductID, q.ProductID).
+		Session(&gorm.Session{})
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var threads []models.MessageThread
+	if err := query.Select("message_threads.*, ("+unreadCount+") AS unread_count", q.ReaderID).
+		Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Order("id DESC").
+		Limit(filters.Limit()).
+		Offset(filters.Offset()).
+		Find(&threads).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return threads, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// unreadCount counts the visible messages of a thread the reader has not read.
+const unreadCount = `SELECT COUNT(*) FROM messages WHERE messages.thread_id = message_threads.id
+	AND messages.sender_id <> ? AND messages.read_at IS NULL AND messages.hidden_at IS NULL`
+
+// CountUnread counts the unread messages of the user across their threads.
+func (mr *MessageRepository) CountUnread(userID int64) (int64, error) {
+	var count int64
+	if err := mr.db.Model(&models.Message{}).
+		Joins("JOIN message_threads ON message_threads.id = messages.thread_id").
+		Where("(message_threads.store_id = ? OR message_threads.distributor_id = ?)", userID, userID).
+		Where("messages.sender_id <> ? AND messages.read_at IS NULL AND messages.hidden_at IS NULL", userID).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CreateMessage adds the message to its thread and moves the thread up.
+func (mr *MessageRepository) CreateMessage(message *models.Message) error {
+	if err := mr.db.Create(message).Error; err != nil {
+		return err
+	}
+	return mr.db.Model(&models.MessageThread{}).
+		Where("id = ?", message.ThreadID).
+		Update("last_message_at", message.CreatedAt).Error
+}
+
+func (mr *MessageRepository) GetMessageByID(messageID int64) (*models.Message, error) {
+	var message models.Message
+	if err := mr.db.First(&message, messageID).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetMessages returns the messages of the thread, oldest first.
+func (mr *MessageRepository) GetMessages(threadID int64, includeHidden bool) ([]models.Message, error) {
+	var messages []models.Message
+	if err := mr.db.Where("thread_id = ? AND (hidden_at IS NULL OR ?)", threadID, includeHidden).
+		Order("created_at ASC").
+		Order("id ASC").
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkRead marks the messages the reader received in the thread as read and
+// returns how many were unread.
+func (mr *MessageRepository) MarkRead(threadID, readerID int64, readAt time.Time) (int64, error) {
+	result := mr.db.Model(&models.Message{}).
+		Where("thread_id = ? AND sender_id <> ? AND read_at IS NULL", threadID, readerID).
+		Update("read_at", readAt)
+	return result.RowsAffected, result.Error
+}
+
+func (mr *MessageRepository) HideMessage(messageID, adminID int64, reason string, hiddenAt time.Time) error {
+	return mr.db.Model(&models.Message{}).Where("id = ?", messageID).Updates(map[string]interface{}{
+		"hidden_at":     hiddenAt,
+		"hidden_by":     adminID,
+		"hidden_reason": reason,
+	}).Error
+}
+
+func (mr *MessageRepository) RestoreMessage(messageID int64) error {
+	return mr.db.Model(&models.Message{}).Where("id = ?", messageID).Updates(map[string]interface{}{
+		"hidden_at":     nil,
+		"hidden_by":     nil,
+		"hidden_reason": "",
+	}).Error
+}
+
+func (mr *MessageRepository) SetThreadLocked(threadID int64, locked bool) error {
+	return mr.db.Model(&models.MessageThread{}).Where("id = ?", threadID).Update("locked", locked).Error
+}
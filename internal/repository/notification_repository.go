@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+type NotificationRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepository(db *gorm.DB) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+// CreateNotification stores the notification unless the user was already
+// notified about the event, and reports whether it was stored.
+func (nr *NotificationRepository) CreateNotification(notification *models.Notification) (bool, error) {
+	result := nr.db.Omit("User").Clauses(clause.OnConflict{DoNothing: true}).Create(notification)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (nr *NotificationRepository) GetNotifications(userID int64, unreadOnly bool, filters models.Filters) ([]models.Notification, models.Metadata, error) {
+	query := nr.db.Model(&models.Notification{}).
+		Where("user_id = ? AND in_app", userID).
+		Where("(read_at IS NULL OR ?)", !unreadOnly).
+		Session(&gorm.Session{})
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var notifications []models.Notification
+	if err := query.Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Order("id DESC").
+		Limit(filters.Limit()).
+		Offset(filters.Offset()).
+		Find(&notifications).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return notifications, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+func (nr *NotificationRepository) CountUnread(userID int64) (int64, error) {
+	var count int64
+	if err := nr.db.Model(&models.Notification{}).Where("user_id = ? AND in_app AND read_at IS NULL", userID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkRead marks the notification of the user as read. Marking it again keeps
+// the first read time.
+func (nr *NotificationRepository) MarkRead(userID, notificationID int64, readAt time.Time) error {
+	result := nr.db.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ? AND in_app", notificationID, userID).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", readAt))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks the unread notifications of the user as read and returns
+// how many there were.
+func (nr *NotificationRepository) MarkAllRead(userID int64, readAt time.Time) (int64, error) {
+	result := nr.db.Model(&models.Notification{}).
+		Where("user_id = ? AND in_app AND read_at IS NULL", userID).
+		Update("read_at", readAt)
+	return result.RowsAffected, result.Error
+}
+
+func (nr *NotificationRepository) GetPreferences(userID int64) ([]models.NotificationPreference, error) {
+	var preferences []models.NotificationPreference
+	if err := nr.db.Where("user_id = ?", userID).Find(&preferences).Error; err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+func (nr *NotificationRepository) GetPreference(userID int64, event string) (*models.NotificationPreference, error) {
+	var preference models.NotificationPreference
+	if err := nr.db.Where("user_id = ? AND event = ?", userID, event).First(&preference).Error; err != nil {
+		return nil, err
+	}
+	return &preference, nil
+}
+
+func (nr *NotificationRepository) SavePreferences(preferences []models.NotificationPreference) error {
+	return nr.db.Omit("User").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "event"}},
+		DoUpdates: clause.AssignmentColumns([]string{"in_app", "email", "sms"}),
+	}).Create(&preferences).Error
+}
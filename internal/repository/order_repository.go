@@ -3,6 +3,7 @@ package repository
 import (
 	"gorm.io/gorm"
 	"marketplace-api/internal/models"
+	"time"
 )
 
 type OrderRepository struct {
@@ -13,14 +14,37 @@ func NewOrderRepository(db *gorm.DB) *OrderRepository {
 	return &OrderRepository{db: db}
 }
 
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (or *OrderRepository) WithTx(tx *gorm.DB) *OrderRepository {
+	return &OrderRepository{db: tx}
+}
+
 func (or *OrderRepository) CreateOrder(order *models.Order) error {
 	return or.db.Create(&order).Error
 }
 
+func (or *OrderRepository) DeleteOrder(order *models.Order) error {
+	if err := or.db.Delete(&models.Order{}, order.ID).Error; err != nil {
+		return err
+	}
+	return or.db.Delete(&models.Stage{}, order.StageID).Error
+}
+
 func (or *OrderRepository) CreateOrderStage(stage *models.Stage) error {
+	stage.ChangedAt = time.Now()
 	return or.db.Create(&stage).Error
 }
+
+// UpdateOrderStage saves the stage and restarts its clock if the order moved
+// to another stage.
 func (or *OrderRepository) UpdateOrderStage(stage *models.Stage) error {
+	var current models.Stage
+	if err := or.db.Select("stage").First(&current, stage.ID).Error; err != nil {
+		return err
+	}
+	if current.Stage != stage.Stage {
+		stage.ChangedAt = time.Now()
+	}
 	return or.db.Updates(&stage).Error
 }
 
@@ -32,6 +56,13 @@ func (or *OrderRepository) GetOrderByID(userID, orderID int64, role string) (*mo
 	return order, nil
 }
 
+// NextOrderNumbers takes count numbers of the distributor's orders in the year
+// and returns the first of them. It must run inside the transaction that
+// creates the orders.
+func (or *OrderRepository) NextOrderNumbers(distributorID int64, year int, count int64) (int64, error) {
+	return nextSequenceValues(or.db, distributorID, models.OrderSequence(year), count)
+}
+
 func (or *OrderRepository) GetStageByID(stageID int64) (*models.Stage, error) {
 	var orderStages models.Stage
 	if err := or.db.Where("id = ?", stageID).Find(&orderStages).Error; err != nil {
@@ -44,14 +75,56 @@ func (or *OrderRepository) UpdateOrderStatus(order *models.Order) error {
 	return or.db.Where("id = ?", order.ID).Updates(order).Error
 }
 
-func (or *OrderRepository) GetOrders(userID int64, role string) ([]models.Order, error) {
+// GetOrders returns the orders of the store or the distributor, only those
+// whose invoice has the payment status and whose number contains number if
+// they are given.
+func (or *OrderRepository) GetOrders(userID int64, role string, paymentStatus string, number string) ([]models.Order, error) {
 	var orders []models.Order
-	if err := or.db.Where(role+"_id = ?", userID).Find(&orders).Error; err != nil {
+	query := or.db.Where(role+"_id = ?", userID)
+	if paymentStatus != "" {
+		query = ordersWithPaymentStatus(query, paymentStatus)
+	}
+	if number != "" {
+		query = query.Where("number ILIKE ?", "%"+number+"%")
+	}
+	if err := query.Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (or *OrderRepository) GetActiveOrdersByStage(stage string) ([]models.Order, error) {
+	var orders []models.Order
+	if err := or.db.Preload("Stage").Where("stage_id IN (SELECT id FROM stages WHERE stage = ?) AND status = ?", stage, models.OrderStatusActive).Find(&orders).Error; err != nil {
 		return nil, err
 	}
 	return orders, nil
 }
 
+// CountActiveOrdersInDeliveryBooking counts the active orders of the invoice
+// delivered in the window of the slot.
+func (or *OrderRepository) CountActiveOrdersInDeliveryBooking(invoiceID, slotID int64, start time.Time) (int64, error) {
+	var count int64
+	if err := or.db.Model(&models.Order{}).
+		Where("invoice_id = ? AND delivery_slot_id = ? AND delivery_start = ? AND status = ?", invoiceID, slotID, start, models.OrderStatusActive).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountActiveOrdersInDeliveryWindow counts the active orders of the store
+// without an invoice delivered in the window of the slot.
+func (or *OrderRepository) CountActiveOrdersInDeliveryWindow(storeID, slotID int64, start time.Time) (int64, error) {
+	var count int64
+	if err := or.db.Model(&models.Order{}).
+		Where("store_id = ? AND delivery_slot_id = ? AND delivery_start = ? AND status = ? AND invoice_id IS NULL", storeID, slotID, start, models.OrderStatusActive).
+		Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func (or *OrderRepository) GetSuccessOrders(userID int64, role string) ([]models.Order, error) {
 	var orders []models.Order
 	if err := or.db.Find(&orders, "stage_id IN (SELECT id FROM stages WHERE stage = 'success' AND status = 'success') AND orders."+role+"_id = ?", userID).Error; err != nil {
@@ -59,3 +132,41 @@ func (or *OrderRepository) GetSuccessOrders(userID int64, role string) ([]models
 	}
 	return orders, nil
 }
+
+func (or *OrderRepository) GetBackorderedOrders(productID int64) ([]models.Order, error) {
+	var orders []models.Order
+	if err := or.db.Where("stage_id IN (SELECT id FROM stages WHERE stage = ?) AND product_id = ? AND status = ?", models.StageBackordered, productID, models.OrderStatusActive).
+		Order("timestamp ASC").
+		Order("id ASC").
+		Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GetOrdersForExchange returns the orders of the distributor that 1C has not
+// confirmed receiving yet.
+func (or *OrderRepository) GetOrdersForExchange(distributorID int64) ([]models.Order, error) {
+	var orders []models.Order
+	if err := or.db.Preload("Stage").Preload("Store").Preload("Product").
+		Where("distributor_id = ? AND exchanged_at IS NULL", distributorID).
+		Order("id ASC").
+		Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (or *OrderRepository) MarkOrdersSent(orderIDs []int64, sentAt time.Time) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	return or.db.Model(&models.Order{}).Where("id IN ?", orderIDs).Update("exchange_sent_at", sentAt).Error
+}
+
+// ConfirmSentOrders marks the orders sent to 1C as received.
+func (or *OrderRepository) ConfirmSentOrders(distributorID int64) error {
+	return or.db.Model(&models.Order{}).
+		Where("distributor_id = ? AND exchanged_at IS NULL AND exchange_sent_at IS NOT NULL", distributorID).
+		Update("exchanged_at", gorm.Expr("exchange_sent_at")).Error
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+type PayoutRepository struct {
+	db *gorm.DB
+}
+
+func NewPayoutRepository(db *gorm.DB) *PayoutRepository {
+	return &PayoutRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (pr *PayoutRepository) WithTx(tx *gorm.DB) *PayoutRepository {
+	return &PayoutRepository{db: tx}
+}
+
+func (pr *PayoutRepository) GetCommissionRules() ([]models.CommissionRule, error) {
+	var rules []models.CommissionRule
+	if err := pr.db.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (pr *PayoutRepository) GetCommissionRuleByID(ruleID int64) (*models.CommissionRule, error) {
+	var rule models.CommissionRule
+	if err := pr.db.First(&rule, ruleID).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// HasCommissionRule reports whether another active rule has the same
+// distributor and category.
+func (pr *PayoutRepository) HasCommissionRule(rule *models.CommissionRule) (bool, error) {
+	query := pr.db.Model(&models.CommissionRule{}).Where("active AND category = ? AND id <> ?", rule.Category, rule.ID)
+	if rule.DistributorID == nil {
+		query = query.Where("distributor_id IS NULL")
+	} else {
+		query = query.Where("distributor_id = ?", *rule.DistributorID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (pr *PayoutRepository) CreateCommissionRule(rule *models.CommissionRule) error {
+	return pr.db.Create(rule).Error
+}
+
+func (pr *PayoutRepository) UpdateCommissionRule(rule *models.CommissionRule) error {
+	return pr.db.Model(rule).Updates(map[string]interface{}{
+		"distributor_id": rule.DistributorID,
+		"category":       rule.Category,
+		"percent":        rule.Percent,
+		"fixed_fee":      rule.FixedFee,
+		"active":         rule.Active,
+	}).Error
+}
+
+func (pr *PayoutRepository) DeleteCommissionRule(ruleID int64) error {
+	result := pr.db.Delete(&models.CommissionRule{}, ruleID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CreatePayoutLine adds the line unless the order already has a sale line,
+// and reports whether it was created.
+func (pr *PayoutRepository) CreatePayoutLine(line *models.PayoutLine) (bool, error) {
+	result := pr.db.Omit("Distributor").Clauses(clause.OnConflict{DoNothing: true}).Create(line)
+	return result.RowsAffected > 0, result.Error
+}
+
+// LockSaleLine returns the sale line of the order and locks it until the
+// transaction ends, so concurrent returns of the order add up.
+func (pr *PayoutRepository) LockSaleLine(orderID int64) (*models.PayoutLine, error) {
+	var line models.PayoutLine
+	if err := pr.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND kind = ?", orderID, models.PayoutLineSale).
+		First(&line).Error; err != nil {
+		return nil, err
+	}
+	return &line, nil
+}
+
+// GetReturnedQuantity returns the number of units of the order returned so far.
+func (pr *PayoutRepository) GetReturnedQuantity(orderID int64) (int64, error) {
+	var quantity int64
+	if err := pr.db.Model(&models.PayoutLine{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("order_id = ? AND kind = ?", orderID, models.PayoutLineReturn).
+		Scan(&quantity).Error; err != nil {
+		return 0, err
+	}
+	return quantity, nil
+}
+
+// GetPendingLines returns the lines of the distributor that are not on a
+// statement yet.
+func (pr *PayoutRepository) GetPendingLines(distributorID int64) ([]models.PayoutLine, error) {
+	var lines []models.PayoutLine
+	if err := pr.db.Where("distributor_id = ? AND statement_id IS NULL", distributorID).
+		Order("created_at, id").
+		Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// GetDistributorsWithPendingLines returns the distributors that have lines
+// created before the time and not on a statement.
+func (pr *PayoutRepository) GetDistributorsWithPendingLines(before time.Time) ([]int64, error) {
+	var distributorIDs []int64
+	if err := pr.db.Model(&models.PayoutLine{}).
+		Distinct("distributor_id").
+		Where("statement_id IS NULL AND created_at < ?", before).
+		Order("distributor_id").
+		Pluck("distributor_id", &distributorIDs).Error; err != nil {
+		return nil, err
+	}
+	return distributorIDs, nil
+}
+
+// LockPendingLines returns the lines of the distributor created before the
+// time and not on a statement, and locks them until the transaction ends.
+func (pr *PayoutRepository) LockPendingLines(distributorID int64, before time.Time) ([]models.PayoutLine, error) {
+	var lines []models.PayoutLine
+	if err := pr.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("distributor_id = ? AND statement_id IS NULL AND created_at < ?", distributorID, before).
+		Order("created_at, id").
+		Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func (pr *PayoutRepository) CreateStatement(statement *models.Statement) error {
+	return pr.db.Omit("Distributor", "Lines").Create(statement).Error
+}
+
+func (pr *PayoutRepository) AssignLines(statementID int64, lineIDs []int64) error {
+	return pr.db.Model(&models.PayoutLine{}).Where("id IN ?", lineIDs).Update("statement_id", statementID).Error
+}
+
+// GetStatements returns the statements of the distributor, or of every
+// distributor when distributorID is 0, optionally only those with the status.
+func (pr *PayoutRepository) GetStatements(distributorID int64, status string, filters models.Filters) ([]models.Statement, models.Metadata, error) {
+	query := pr.db.Model(&models.Statement{})
+	if distributorID > 0 {
+		query = query.Where("distributor_id = ?", distributorID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var statements []models.Statement
+	if err := query.Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Order("id").
+		Limit(filters.Limit()).Offset(filters.Offset()).
+		Find(&statements).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return statements, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// GetStatement returns the statement with its lines. A distributorID of 0
+// matches the statement of any distributor.
+func (pr *PayoutRepository) GetStatement(distributorID, statementID int64) (*models.Statement, error) {
+	query := pr.db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("created_at, id") })
+	if distributorID > 0 {
+		query = query.Where("distributor_id = ?", distributorID)
+	}
+	var statement models.Statement
+	if err := query.First(&statement, statementID).Error; err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
+
+func (pr *PayoutRepository) LockStatement(statementID int64) (*models.Statement, error) {
+	var statement models.Statement
+	if err := pr.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&statement, statementID).Error; err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
+
+func (pr *PayoutRepository) MarkStatementPaid(statement *models.Statement) error {
+	return pr.db.Model(statement).Updates(map[string]interface{}{
+		"status":    statement.Status,
+		"paid_at":   statement.PaidAt,
+		"paid_by":   statement.PaidBy,
+		"reference": statement.Reference,
+	}).Error
+}
@@ -4,8 +4,11 @@ package repository
 
 import (
 	"database/sql"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"marketplace-api/internal/models"
+	"time"
 )
 
 type ProductRepository struct {
@@ -16,20 +19,29 @@ func NewProductRepository(db *gorm.DB) *ProductRepository {
 	return &ProductRepository{db: db}
 }
 
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (pr *ProductRepository) WithTx(tx *gorm.DB) *ProductRepository {
+	return &ProductRepository{db: tx}
+}
+
 func (pr *ProductRepository) CreateProduct(product *models.Product) error {
-	if err := pr.db.Create(&product).Error; err != nil {
+	if err := pr.db.Omit("stock").Create(&product).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
+// UpdateProduct updates everything but the stock, which is derived from the
+// stock movement ledger.
 func (pr *ProductRepository) UpdateProduct(product *models.Product) error {
-	if product.Stock == 0 {
-		if err := pr.db.Where("id = ?", product.ID).Model(product).Update("stock", 0).Error; err != nil {
-			return err
-		}
+	if err := pr.db.Where("id = ?", product.ID).Omit("stock").Updates(&product).Error; err != nil {
+		return err
 	}
-	if err := pr.db.Where("id = ?", product.ID).Updates(&product).Error; err != nil {
+	if err := pr.db.Model(&product).Where("id = ?", product.ID).Updates(map[string]interface{}{
+		"allow_backorder":        product.AllowBackorder,
+		"backorder_available_at": product.BackorderAvailableAt,
+		"low_stock_threshold":    product.LowStockThreshold,
+	}).Error; err != nil {
 		return err
 	}
 
@@ -40,11 +52,24 @@ func (pr *ProductRepository) UpdateProduct(product *models.Product) error {
 	return nil
 }
 
+// DeleteProduct deletes the product. A product with stock movements is only
+// marked deleted so the ledger keeps its history; it is taken out of carts and
+// lets go of its images, as a deleted product would.
 func (pr *ProductRepository) DeleteProduct(productID int64) error {
-	if err := pr.db.Delete(&models.Product{}, productID).Error; err != nil {
+	var moved bool
+	if err := pr.db.Raw(`SELECT EXISTS (SELECT 1 FROM stock_movements WHERE product_id = ?)`, productID).Scan(&moved).Error; err != nil {
 		return err
 	}
-	return nil
+	if !moved {
+		return pr.db.Unscoped().Delete(&models.Product{}, productID).Error
+	}
+	if err := pr.db.Where("product_id = ?", productID).Delete(&models.CartItem{}).Error; err != nil {
+		return err
+	}
+	return pr.db.Model(&models.Product{}).Where("id = ?", productID).Updates(map[string]interface{}{
+		"deleted_at": time.Now(),
+		"img_urls":   pq.StringArray{},
+	}).Error
 }
 
 func (pr *ProductRepository) GetProductByID(productID int64) (*models.Product, error) {
@@ -55,12 +80,75 @@ func (pr *ProductRepository) GetProductByID(productID int64) (*models.Product, e
 	return &product, nil
 }
 
+func (pr *ProductRepository) GetProductBySKU(distributorID int64, sku string) (*models.Product, error) {
+	var product models.Product
+	if err := pr.db.Where("distributor_id = ? AND sku = ?", distributorID, sku).Order("id").First(&product).Error; err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (pr *ProductRepository) GetProductByExternalID(distributorID int64, externalID string) (*models.Product, error) {
+	var product models.Product
+	if err := pr.db.Where("distributor_id = ? AND external_id = ?", distributorID, externalID).Order("id").First(&product).Error; err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (pr *ProductRepository) UpdatePrice(productID int64, price float64) error {
+	return pr.db.Model(&models.Product{}).Where("id = ?", productID).Update("price", price).Error
+}
+
+// GetAllProductsByDistributorID returns the whole catalog of the distributor
+// for exports.
+func (pr *ProductRepository) GetAllProductsByDistributorID(distributorID int64) ([]models.Product, error) {
+	var products []models.Product
+	if err := pr.db.Where("distributor_id = ?", distributorID).Order("sku, id").Find(&products).Error; err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// productOrder returns the ORDER BY clause of the product listings; rating
+// sorts by the average from the joined rating summary.
+func productOrder(filters models.Filters) string {
+	column := filters.SortColumn()
+	if column == "rating" {
+		column = "COALESCE(rating_summaries.average, 0)"
+	}
+	return column + " " + filters.SortDirection()
+}
+
+// productRating holds the rating summary columns joined to product listings,
+// which are NULL for products without reviews.
+type productRating struct {
+	count        sql.NullInt64
+	average      sql.NullFloat64
+	distribution pq.Int64Array
+}
+
+func (r *productRating) summary(productID int64) *models.RatingSummary {
+	if !r.count.Valid {
+		return models.NewRatingSummary(models.RatingSubjectProduct, productID)
+	}
+	return &models.RatingSummary{
+		SubjectType:  models.RatingSubjectProduct,
+		SubjectID:    productID,
+		Count:        r.count.Int64,
+		Average:      r.average.Float64,
+		Distribution: r.distribution,
+	}
+}
+
 func (pr *ProductRepository) GetProductsByDistributorID(productName string, filters models.Filters, distributorID int64) ([]*models.Product, models.Metadata, error) {
 	rows, err := pr.db.Table("products").Select("count(*) OVER()",
 		"id", "category", "product_name", "product_description",
-		"price", "img_urls", "minimum_quantity", "stock", "city").Where(
-		"(to_tsvector('simple', product_name) @@ plainto_tsquery('simple', ?) OR ? = '') AND distributor_id = ?", productName, productName, distributorID).
-		Order(filters.SortColumn() + " " + filters.SortDirection()).
+		"price", "img_urls", "minimum_quantity", "stock", "city",
+		"rating_summaries.count", "rating_summaries.average", "rating_summaries.distribution").Where(
+		"(to_tsvector('simple', product_name) @@ plainto_tsquery('simple', ?) OR ? = '') AND distributor_id = ? AND products.deleted_at IS NULL", productName, productName, distributorID).
+		Joins("LEFT JOIN rating_summaries ON rating_summaries.subject_type = ? AND rating_summaries.subject_id = products.id", models.RatingSubjectProduct).
+		Order(productOrder(filters)).
 		Order("id ASC").
 		Limit(filters.Limit()).
 		Limit(filters.Offset()).Rows()
@@ -80,6 +168,7 @@ func (pr *ProductRepository) GetProductsByDistributorID(productName string, filt
 
 	for rows.Next() {
 		var product models.Product
+		var rating productRating
 
 		err := rows.Scan(
 			&totalRecords,
@@ -92,10 +181,14 @@ func (pr *ProductRepository) GetProductsByDistributorID(productName string, filt
 			&product.MinimumQuantity,
 			&product.Stock,
 			&product.City,
+			&rating.count,
+			&rating.average,
+			&rating.distribution,
 		)
 		if err != nil {
 			return nil, models.Metadata{}, err
 		}
+		product.Rating = rating.summary(product.ID)
 		products = append(products, &product)
 	}
 
@@ -109,9 +202,11 @@ func (pr *ProductRepository) GetProductsByDistributorID(productName string, filt
 func (pr *ProductRepository) GetProducts(productName string, filters models.Filters) ([]*models.Product, models.Metadata, error) {
 	rows, err := pr.db.Table("products").Select("count(*) OVER()",
 		"id", "category", "product_name", "product_description",
-		"price", "img_urls", "minimum_quantity", "stock", "city").Where(
-		"(products.stock != 0) AND (to_tsvector('simple', product_name) @@ plainto_tsquery('simple', ?) OR ? = '')", productName, productName).
-		Order(filters.SortColumn() + " " + filters.SortDirection()).
+		"price", "img_urls", "minimum_quantity", "stock", "city",
+		"rating_summaries.count", "rating_summaries.average", "rating_summaries.distribution").Where(
+		"products.deleted_at IS NULL AND (products.stock != 0 OR products.allow_backorder) AND (to_tsvector('simple', product_name) @@ plainto_tsquery('simple', ?) OR ? = '')", productName, productName).
+		Joins("LEFT JOIN rating_summaries ON rating_summaries.subject_type = ? AND rating_summaries.subject_id = products.id", models.RatingSubjectProduct).
+		Order(productOrder(filters)).
 		Order("id ASC").
 		Limit(filters.Limit()).
 		Limit(filters.Offset()).Rows()
@@ -131,6 +226,7 @@ func (pr *ProductRepository) GetProducts(productName string, filters models.Filt
 
 	for rows.Next() {
 		var product models.Product
+		var rating productRating
 
 		err := rows.Scan(
 			&totalRecords,
@@ -143,10 +239,14 @@ func (pr *ProductRepository) GetProducts(productName string, filters models.Filt
 			&product.MinimumQuantity,
 			&product.Stock,
 			&product.City,
+			&rating.count,
+			&rating.average,
+			&rating.distribution,
 		)
 		if err != nil {
 			return nil, models.Metadata{}, err
 		}
+		product.Rating = rating.summary(product.ID)
 		products = append(products, &product)
 	}
 
@@ -166,11 +266,18 @@ func (pr *ProductRepository) GetEmail(userID int64) (string, error) {
 	return user.Email, nil
 }
 
-func (pr *ProductRepository) CreatReview(review *models.Review) error {
-	if err := pr.db.Create(&review).Error; err != nil {
-		return err
+// CreatReview stores the review unless its order line was already reviewed,
+// and reports whether it was stored.
+func (pr *ProductRepository) CreatReview(review *models.Review) (bool, error) {
+	result := pr.db.Clauses(clause.OnConflict{DoNothing: true}).Create(review)
+	if result.Error != nil {
+		return false, result.Error
 	}
-	return nil
+	return result.RowsAffected > 0, nil
+}
+
+func (pr *ProductRepository) UpdateReview(review *models.Review) error {
+	return pr.db.Model(review).Updates(map[string]interface{}{"rating": review.Rating, "text": review.Text}).Error
 }
 
 func (pr *ProductRepository) GetReviews(id int64, role string) ([]models.Review, error) {
@@ -180,11 +287,11 @@ func (pr *ProductRepository) GetReviews(id int64, role string) ([]models.Review,
 			return nil, err
 		}
 	} else if role == "distributor" {
-		if err := pr.db.Where("distributor_id = ?", id).Find(&reviews).Error; err != nil {
+		if err := pr.db.Where("distributor_id = ? AND status = ?", id, models.ReviewStatusPublished).Find(&reviews).Error; err != nil {
 			return nil, err
 		}
 	} else if role == "product" {
-		if err := pr.db.Where("product_id = ?", id).Find(&reviews).Error; err != nil {
+		if err := pr.db.Where("product_id = ? AND status = ?", id, models.ReviewStatusPublished).Find(&reviews).Error; err != nil {
 			return nil, err
 		}
 	}
@@ -192,9 +299,98 @@ func (pr *ProductRepository) GetReviews(id int64, role string) ([]models.Review,
 	return reviews, nil
 }
 
-func (pr *ProductRepository) DeleteByReviewId(id int64) error {
-	if err := pr.db.Delete(&models.Review{}, id).Error; err != nil {
-		return err
+// LockReview loads the review and locks it until the transaction ends, so
+// concurrent changes apply to the rating summaries one at a time.
+func (pr *ProductRepository) LockReview(reviewID int64) (*models.Review, error) {
+	var review models.Review
+	if err := pr.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&review, reviewID).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (pr *ProductRepository) GetReviewByID(reviewID int64) (*models.Review, error) {
+	var review models.Review
+	if err := pr.db.Preload("Flags", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&review, reviewID).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// GetReviewQueue returns the reviews of a moderation queue, see
+// models.ReviewQueues.
+func (pr *ProductRepository) GetReviewQueue(queue string, filters models.Filters) ([]models.Review, models.Metadata, error) {
+	openFlags := "EXISTS (SELECT 1 FROM review_flags f WHERE f.review_id = reviews.id AND f.resolved_at IS NULL)"
+	query := pr.db.Model(&models.Review{})
+	switch queue {
+	case "pending":
+		query = query.Where("status = ? OR (status = ? AND "+openFlags+")", models.ReviewStatusHeld, models.ReviewStatusPublished)
+	case "flagged":
+		query = query.Where(openFlags)
+	case models.ReviewStatusHeld, models.ReviewStatusHidden, models.ReviewStatusPublished:
+		query = query.Where("status = ?", queue)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var reviews []models.Review
+	if err := query.Preload("Flags", "resolved_at IS NULL").
+		Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Order("id").
+		Limit(filters.Limit()).Offset(filters.Offset()).
+		Find(&reviews).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return reviews, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// UpdateReviewStatus saves the moderation state of the review.
+func (pr *ProductRepository) UpdateReviewStatus(review *models.Review) error {
+	return pr.db.Model(review).Updates(map[string]interface{}{
+		"status":            review.Status,
+		"moderation_reason": review.ModerationReason,
+		"moderated_by":      review.ModeratedBy,
+		"moderated_at":      review.ModeratedAt,
+		"published_at":      review.PublishedAt,
+	}).Error
+}
+
+func (pr *ProductRepository) UpdateReviewReply(review *models.Review) error {
+	return pr.db.Model(review).Updates(map[string]interface{}{"reply": review.Reply, "replied_at": review.RepliedAt}).Error
+}
+
+// CreateReviewFlag stores the report unless the reporter already reported the
+// review, and reports whether it was stored.
+func (pr *ProductRepository) CreateReviewFlag(flag *models.ReviewFlag) (bool, error) {
+	result := pr.db.Clauses(clause.OnConflict{DoNothing: true}).Create(flag)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (pr *ProductRepository) CountOpenReviewFlags(reviewID int64) (int64, error) {
+	var count int64
+	err := pr.db.Model(&models.ReviewFlag{}).Where("review_id = ? AND resolved_at IS NULL", reviewID).Count(&count).Error
+	return count, err
+}
+
+func (pr *ProductRepository) ResolveReviewFlags(reviewID int64, resolvedAt time.Time) error {
+	return pr.db.Model(&models.ReviewFlag{}).Where("review_id = ? AND resolved_at IS NULL", reviewID).Update("resolved_at", resolvedAt).Error
+}
+
+func (pr *ProductRepository) DeleteByReviewId(storeID, id int64) error {
+	result := pr.db.Where("store_id = ?", storeID).Delete(&models.Review{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
 	return nil
 }
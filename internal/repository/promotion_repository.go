@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+// promotionUsesSQL counts the checkouts a promotion was applied to. Each
+// checkout bills a distributor's lines on one invoice.
+const promotionUsesSQL = `(SELECT COUNT(DISTINCT orders.invoice_id) FROM orders
+	JOIN stages ON stages.id = orders.stage_id
+	WHERE orders.promotion_id = promotions.id AND stages.status <> @cancelled`
+
+type PromotionRepository struct {
+	db *gorm.DB
+}
+
+func NewPromotionRepository(db *gorm.DB) *PromotionRepository {
+	return &PromotionRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (pr *PromotionRepository) WithTx(tx *gorm.DB) *PromotionRepository {
+	return &PromotionRepository{db: tx}
+}
+
+func (pr *PromotionRepository) CreatePromotion(promotion *models.Promotion) error {
+	return pr.db.Omit("Distributor").Create(promotion).Error
+}
+
+func (pr *PromotionRepository) UpdatePromotion(promotion *models.Promotion) error {
+	return pr.db.Model(promotion).Select(
+		"name", "kind", "value", "buy_quantity", "free_quantity", "product_ids", "categories",
+		"store_ids", "cities", "starts_at", "ends_at", "usage_limit", "per_store_limit", "coupon_code", "active",
+	).Updates(promotion).Error
+}
+
+func (pr *PromotionRepository) DeletePromotion(distributorID, promotionID int64) error {
+	result := pr.db.Where("distributor_id = ?", distributorID).Delete(&models.Promotion{}, promotionID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (pr *PromotionRepository) GetPromotion(distributorID, promotionID int64) (*models.Promotion, error) {
+	var promotion models.Promotion
+	if err := pr.db.Where("distributor_id = ?", distributorID).First(&promotion, promotionID).Error; err != nil {
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+func (pr *PromotionRepository) GetPromotions(distributorID int64, filters models.Filters) ([]models.Promotion, models.Metadata, error) {
+	query := pr.db.Model(&models.Promotion{}).Where("distributor_id = ?", distributorID).Session(&gorm.Session{})
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var promotions []models.Promotion
+	if err := query.Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Order("id").
+		Limit(filters.Limit()).Offset(filters.Offset()).
+		Find(&promotions).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return promotions, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
+
+// GetAvailablePromotions returns the active promotions of the distributors
+// running at the time that the store has not used up.
+func (pr *PromotionRepository) GetAvailablePromotions(distributorIDs []int64, storeID int64, now time.Time) ([]models.Promotion, error) {
+	var promotions []models.Promotion
+	err := pr.db.Where("distributor_id IN ? AND active", distributorIDs).
+		Where("starts_at IS NULL OR starts_at <= ?", now).
+		Where("ends_at IS NULL OR ends_at > ?", now).
+		Where("usage_limit = 0 OR "+promotionUsesSQL+") < usage_limit",
+			map[string]interface{}{"cancelled": models.StageStatusError}).
+		Where("per_store_limit = 0 OR "+promotionUsesSQL+" AND orders.store_id = @store_id) < per_store_limit",
+			map[string]interface{}{"cancelled": models.StageStatusError, "store_id": storeID}).
+		Order("id").
+		Find(&promotions).Error
+	if err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
+
+// LockLimitedPromotions locks the active promotions of the distributors that
+// have usage limits until the transaction ends, so concurrent checkouts
+// count each other's uses.
+func (pr *PromotionRepository) LockLimitedPromotions(distributorIDs []int64) error {
+	var ids []int64
+	return pr.db.Model(&models.Promotion{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("distributor_id IN ? AND active AND (usage_limit > 0 OR per_store_limit > 0)", distributorIDs).
+		Order("id").
+		Pluck("id", &ids).Error
+}
+
+// CouponAvailable reports whether a promotion the store has not used up runs
+// at the time with the coupon code.
+func (pr *PromotionRepository) CouponAvailable(code string, storeID int64, now time.Time) (bool, error) {
+	var distributorIDs []int64
+	if err := pr.db.Model(&models.Promotion{}).
+		Where("coupon_code = ? AND active", code).
+		Distinct().Pluck("distributor_id", &distributorIDs).Error; err != nil {
+		return false, err
+	}
+	if len(distributorIDs) == 0 {
+		return false, nil
+	}
+	promotions, err := pr.GetAvailablePromotions(distributorIDs, storeID, now)
+	if err != nil {
+		return false, err
+	}
+	for _, promotion := range promotions {
+		if promotion.CouponCode == code {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (pr *PromotionRepository) GetPromotionReport(promotionID int64) (*models.PromotionReport, error) {
+	var report models.PromotionReport
+	err := pr.db.Model(&models.Order{}).
+		Select(`COUNT(DISTINCT orders.invoice_id) AS checkouts, COUNT(*) AS orders,
+			COALESCE(SUM(orders.quantity), 0) AS quantity,
+			ROUND(COALESCE(SUM(orders.discount_amount), 0)::numeric, 2) AS discount_amount,
+			ROUND(COALESCE(SUM(orders.total_price), 0)::numeric, 2) AS gross_sales`).
+		Joins("JOIN stages ON stages.id = orders.stage_id").
+		Where("orders.promotion_id = ? AND stages.status <> ?", promotionID, models.StageStatusError).
+		Scan(&report).Error
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+)
+
+type RatingRepository struct {
+	db *gorm.DB
+}
+
+func NewRatingRepository(db *gorm.DB) *RatingRepository {
+	return &RatingRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (rr *RatingRepository) WithTx(tx *gorm.DB) *RatingRepository {
+	return &RatingRepository{db: tx}
+}
+
+// AddRating adds delta reviews with the rating to the summary of the subject.
+// A negative delta removes them.
+func (rr *RatingRepository) AddRating(subjectType string, subjectID int64, rating int, delta int64) error {
+	if rating < 1 || rating > 5 {
+		return nil
+	}
+	summary := models.NewRatingSummary(subjectType, subjectID)
+	if err := rr.db.Exec(`INSERT INTO rating_summaries (subject_type, subject_id, count, sum, average, distribution)
+		VALUES (?, ?, 0, 0, 0, ?) ON CONFLICT DO NOTHING`, subjectType, subjectID, summary.Distribution).Error; err != nil {
+		return err
+	}
+	return rr.db.Exec(`UPDATE rating_summaries SET
+			count = count + @delta,
+			sum = sum + @sum,
+			average = CASE WHEN count + @delta > 0 THEN (sum + @sum)::float8 / (count + @delta) ELSE 0 END,
+			distribution[@rating] = distribution[@rating] + @delta
+		WHERE subject_type = @type AND subject_id = @id`,
+		map[string]interface{}{"delta": delta, "sum": delta * int64(rating), "rating": rating, "type": subjectType, "id": subjectID}).Error
+}
+
+// GetRatingSummary returns the summary of the subject; subjects without
+// reviews get an empty one.
+func (rr *RatingRepository) GetRatingSummary(subjectType string, subjectID int64) (*models.RatingSummary, error) {
+	var summary models.RatingSummary
+	err := rr.db.Where("subject_type = ? AND subject_id = ?", subjectType, subjectID).First(&summary).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.NewRatingSummary(subjectType, subjectID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
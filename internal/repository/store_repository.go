@@ -79,3 +79,52 @@ func (sr *StoreRepository) GetEmail(userID int64) (string, error) {
 	}
 	return user.Email, nil
 }
+
+func (sr *StoreRepository) CreateAddress(address *models.StoreAddress) error {
+	return sr.db.Create(address).Error
+}
+
+func (sr *StoreRepository) UpdateAddress(address *models.StoreAddress) error {
+	return sr.db.Model(address).
+		Select("label", "city", "address", "contact_name", "contact_phone").
+		Where("id = ? AND store_id = ?", address.ID, address.StoreID).
+		Updates(address).Error
+}
+
+func (sr *StoreRepository) DeleteAddress(storeID, addressID int64) error {
+	return sr.db.Where("id = ? AND store_id = ?", addressID, storeID).Delete(&models.StoreAddress{}).Error
+}
+
+func (sr *StoreRepository) GetAddressByID(storeID, addressID int64) (*models.StoreAddress, error) {
+	var address models.StoreAddress
+	if err := sr.db.Where("id = ? AND store_id = ?", addressID, storeID).First(&address).Error; err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+func (sr *StoreRepository) GetAddresses(storeID int64) ([]models.StoreAddress, error) {
+	var addresses []models.StoreAddress
+	if err := sr.db.Where("store_id = ?", storeID).Order("is_default DESC").Order("id ASC").Find(&addresses).Error; err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func (sr *StoreRepository) GetDefaultAddress(storeID int64) (*models.StoreAddress, error) {
+	var address models.StoreAddress
+	if err := sr.db.Where("store_id = ? AND is_default", storeID).First(&address).Error; err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// SetDefaultAddress makes the address the only default one of the store.
+func (sr *StoreRepository) SetDefaultAddress(storeID, addressID int64) error {
+	return sr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.StoreAddress{}).Where("store_id = ? AND id <> ?", storeID, addressID).UpdateColumn("is_default", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.StoreAddress{}).Where("store_id = ? AND id = ?", storeID, addressID).UpdateColumn("is_default", true).Error
+	})
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+// imageReferences matches the uploads that a product, an order snapshot or a
+// message refers to.
+const imageReferences = `EXISTS (SELECT 1 FROM products WHERE uploads.url = ANY(products.img_urls))
+	OR EXISTS (SELECT 1 FROM orders WHERE uploads.url = ANY(orders.snapshot_img_urls))
+	OR EXISTS (SELECT 1 FROM messages WHERE uploads.url = ANY(messages.attachments))`
+
+type UploadRepository struct {
+	db *gorm.DB
+}
+
+func NewUploadRepository(db *gorm.DB) *UploadRepository {
+	return &UploadRepository{db: db}
+}
+
+func (ur *UploadRepository) CreateUpload(upload *models.Upload) error {
+	return ur.db.Create(upload).Error
+}
+
+func (ur *UploadRepository) GetUploadByURL(url string) (*models.Upload, error) {
+	var upload models.Upload
+	if err := ur.db.Where("url = ?", url).First(&upload).Error; err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (ur *UploadRepository) DeleteUpload(uploadID int64) error {
+	return ur.db.Delete(&models.Upload{}, uploadID).Error
+}
+
+// ImageInUse reports whether a product, an order or a message still refers to
+// the image.
+func (ur *UploadRepository) ImageInUse(url string) (bool, error) {
+	var inUse bool
+	if err := ur.db.Raw(`SELECT EXISTS (SELECT 1 FROM products WHERE ? = ANY(img_urls))
+		OR EXISTS (SELECT 1 FROM orders WHERE ? = ANY(snapshot_img_urls))
+		OR EXISTS (SELECT 1 FROM messages WHERE ? = ANY(attachments))`, url, url, url).Scan(&inUse).Error; err != nil {
+		return false, err
+	}
+	return inUse, nil
+}
+
+// GetOrphanedUploads returns up to limit uploads created before the time that
+// nothing refers to.
+func (ur *UploadRepository) GetOrphanedUploads(before time.Time, limit int) ([]models.Upload, error) {
+	var uploads []models.Upload
+	if err := ur.db.Where("created_at < ?", before).
+		Where("NOT (" + imageReferences + ")").
+		Order("id").Limit(limit).
+		Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
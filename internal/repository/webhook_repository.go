@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"marketplace-api/internal/models"
+	"time"
+)
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (wr *WebhookRepository) CreateWebhook(webhook *models.Webhook) error {
+	return wr.db.Create(webhook).Error
+}
+
+func (wr *WebhookRepository) UpdateWebhook(webhook *models.Webhook) error {
+	return wr.db.Model(webhook).
+		Select("url", "secret", "events", "active", "consecutive_failures", "disabled_at").
+		Updates(webhook).Error
+}
+
+func (wr *WebhookRepository) DeleteWebhook(webhookID int64) error {
+	return wr.db.Delete(&models.Webhook{}, webhookID).Error
+}
+
+func (wr *WebhookRepository) GetWebhookByID(webhookID int64) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := wr.db.First(&webhook, webhookID).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (wr *WebhookRepository) GetWebhooksByDistributorID(distributorID int64) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	if err := wr.db.Where("distributor_id = ?", distributorID).Order("id").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetSubscribedWebhooks returns the active webhooks of the distributor that
+// receive the event.
+func (wr *WebhookRepository) GetSubscribedWebhooks(distributorID int64, event string) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	if err := wr.db.Where("distributor_id = ? AND active AND ? = ANY(events)", distributorID, event).Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetQueuedWebhookIDs returns the webhooks that already have a delivery of the
+// event.
+func (wr *WebhookRepository) GetQueuedWebhookIDs(eventID string) ([]int64, error) {
+	var webhookIDs []int64
+	if err := wr.db.Model(&models.WebhookDelivery{}).Where("event_id = ?", eventID).Distinct().Pluck("webhook_id", &webhookIDs).Error; err != nil {
+		return nil, err
+	}
+	return webhookIDs, nil
+}
+
+func (wr *WebhookRepository) CreateDeliveries(deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return wr.db.Omit("Webhook", "Logs").Create(&deliveries).Error
+}
+
+// ClaimDueDelivery locks the next due pending delivery of an active webhook
+// and pushes its next attempt back by the lease, so other instances do not
+// send it at the same time. It returns gorm.ErrRecordNotFound if none is due.
+func (wr *WebhookRepository) ClaimDueDelivery(now time.Time, lease time.Duration) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := wr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryStatusPending, now).
+			Where("webhook_id IN (SELECT id FROM webhooks WHERE active)").
+			Order("next_attempt_at, id").
+			Take(&delivery).Error; err != nil {
+			return err
+		}
+		return tx.Model(&delivery).Omit(clause.Associations).Update("next_attempt_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := wr.GetWebhookByID(delivery.WebhookID)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Webhook = *webhook
+	return &delivery, nil
+}
+
+// RecordAttempt saves the attempt log with the new state of the delivery and
+// counts the attempt on its webhook: a delivered attempt resets the failures,
+// a failed one adds to them and disables the webhook once disableAfter
+// attempts in a row failed. The count is kept in SQL, so concurrent attempts
+// all add up.
+func (wr *WebhookRepository) RecordAttempt(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, delivered bool, disableAfter int64, now time.Time) error {
+	return wr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(attempt).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(delivery).Omit(clause.Associations).
+			Select("status", "attempts", "next_attempt_at", "delivered_at").
+			Updates(delivery).Error; err != nil {
+			return err
+		}
+		if delivered {
+			return tx.Exec(`UPDATE webhooks SET consecutive_failures = 0 WHERE id = ? AND active`, delivery.WebhookID).Error
+		}
+		return tx.Exec(`UPDATE webhooks SET consecutive_failures = consecutive_failures + 1,
+			active = active AND consecutive_failures + 1 < @limit,
+			disabled_at = CASE WHEN active AND consecutive_failures + 1 >= @limit THEN @now ELSE disabled_at END
+			WHERE id = @id`, map[string]interface{}{"limit": disableAfter, "now": now, "id": delivery.WebhookID}).Error
+	})
+}
+
+func (wr *WebhookRepository) GetDeliveryByID(deliveryID int64) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := wr.db.Preload("Logs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&delivery, deliveryID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (wr *WebhookRepository) GetDeliveries(webhookID int64, status string, filters models.Filters) ([]models.WebhookDelivery, models.Metadata, error) {
+	query := wr.db.Model(&models.WebhookDelivery{}).
+		Where("webhook_id = ? AND (status = ? OR ? = '')", webhookID, status, status)
+	var totalRecords int64
+	if err := query.Session(&gorm.Session{}).Count(&totalRecords).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	var deliveries []models.WebhookDelivery
+	if err := query.Order(filters.SortColumn() + " " + filters.SortDirection()).
+		Order("id DESC").
+		Limit(filters.Limit()).
+		Offset(filters.Offset()).
+		Find(&deliveries).Error; err != nil {
+		return nil, models.Metadata{}, err
+	}
+	return deliveries, models.CalculateMetadata(int(totalRecords), filters.Page, filters.PageSize), nil
+}
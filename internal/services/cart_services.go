@@ -5,16 +5,18 @@ import (
 	"gorm.io/gorm"
 	"marketplace-api/internal/models"
 	"marketplace-api/internal/repository"
+	"time"
 )
 
 type CartService struct {
 	cartRepository        *repository.CartRepository
 	productRepository     *repository.ProductRepository
 	distributorRepository *repository.DistributorRepository
+	promotionService      *PromotionService
 }
 
-func NewCartService(cartRepository *repository.CartRepository, productRepository *repository.ProductRepository, distributorRepository *repository.DistributorRepository) *CartService {
-	return &CartService{cartRepository: cartRepository, productRepository: productRepository, distributorRepository: distributorRepository}
+func NewCartService(cartRepository *repository.CartRepository, productRepository *repository.ProductRepository, distributorRepository *repository.DistributorRepository, promotionService *PromotionService) *CartService {
+	return &CartService{cartRepository: cartRepository, productRepository: productRepository, distributorRepository: distributorRepository, promotionService: promotionService}
 }
 
 func (cs *CartService) AddCartItem(storeID int64, product *models.Product, quantity int64) error {
@@ -120,9 +122,49 @@ func (cs *CartService) GetCart(storeID int64) (*models.Cart, error) {
 		product.Distributor = *distributor
 		cart.Items[i].Product = *product
 	}
+	// The delivery address is chosen at checkout, so city-wide promotions
+	// are shown for the store's own city.
+	store, err := cs.distributorRepository.GetStoreByID(storeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.promotionService.ApplyPromotions(cart, store.City, time.Now()); err != nil {
+		return nil, err
+	}
 	return cart, nil
 }
 
+// SetCouponCode applies the coupon code to the store's cart, or removes the
+// coupon when code is empty.
+func (cs *CartService) SetCouponCode(storeID int64, code string) error {
+	cart, err := cs.cartRepository.GetCartByStoreID(storeID)
+	if err != nil {
+		return err
+	}
+	if code != "" {
+		if err := cs.promotionService.CheckCoupon(code, storeID); err != nil {
+			return err
+		}
+	}
+	return cs.cartRepository.SetCouponCode(cart.ID, code)
+}
+
 func (cs *CartService) DeleteCart(storeID int64) error {
 	return cs.cartRepository.DeleteCart(storeID)
 }
+
+// PurgeIdleCarts deletes carts last updated before the given time and returns them.
+func (cs *CartService) PurgeIdleCarts(before time.Time) ([]models.Cart, error) {
+	carts, err := cs.cartRepository.GetIdleCarts(before)
+	if err != nil {
+		return nil, err
+	}
+	var purged []models.Cart
+	for _, cart := range carts {
+		if err := cs.cartRepository.DeleteCart(cart.StoreID); err != nil {
+			return purged, err
+		}
+		purged = append(purged, cart)
+	}
+	return purged, nil
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	validator "marketplace-api/internal/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+// importProgressEvery is how often, in rows, the progress of a running import
+// is saved.
+const importProgressEvery = 100
+
+type CatalogService struct {
+	productRepository *repository.ProductRepository
+	productService    *ProductService
+	importRepository  *repository.ImportRepository
+	inventoryService  *InventoryService
+	orderService      *OrderService
+	wg                sync.WaitGroup
+}
+
+func NewCatalogService(productRepository *repository.ProductRepository, productService *ProductService, importRepository *repository.ImportRepository, inventoryService *InventoryService, orderService *OrderService) *CatalogService {
+	return &CatalogService{productRepository: productRepository, productService: productService, importRepository: importRepository, inventoryService: inventoryService, orderService: orderService}
+}
+
+// StartImport saves the import and processes the records in the background.
+// The first record is the header, already normalised and validated.
+func (cs *CatalogService) StartImport(productImport *models.ProductImport, records [][]string) error {
+	productImport.Status = models.ImportStatusPending
+	for _, record := range records[1:] {
+		if !blankRecord(record) {
+			productImport.TotalRows++
+		}
+	}
+	if err := cs.importRepository.CreateImport(productImport); err != nil {
+		return err
+	}
+
+	cs.wg.Add(1)
+	go func() {
+		defer cs.wg.Done()
+		cs.runImport(productImport, records)
+	}()
+	return nil
+}
+
+// Wait blocks until running imports have finished.
+func (cs *CatalogService) Wait() {
+	cs.wg.Wait()
+}
+
+func (cs *CatalogService) GetImportByID(importID int64) (*models.ProductImport, error) {
+	return cs.importRepository.GetImportByID(importID)
+}
+
+func (cs *CatalogService) GetImports(distributorID int64, filters models.Filters) ([]models.ProductImport, models.Metadata, error) {
+	return cs.importRepository.GetImportsByDistributorID(distributorID, filters)
+}
+
+// Export returns the catalog of the distributor in the import column layout.
+func (cs *CatalogService) Export(distributorID int64) ([][]string, error) {
+	products, err := cs.productRepository.GetAllProductsByDistributorID(distributorID)
+	if err != nil {
+		return nil, err
+	}
+	records := make([][]string, 0, len(products)+1)
+	records = append(records, models.ProductColumns)
+	for i := range products {
+		records = append(records, models.ProductRecord(&products[i]))
+	}
+	return records, nil
+}
+
+func (cs *CatalogService) runImport(productImport *models.ProductImport, records [][]string) {
+	productImport.Status = models.ImportStatusRunning
+	if err := cs.importRepository.UpdateImport(productImport); err != nil {
+		cs.failImport(productImport, err)
+		return
+	}
+
+	header := records[0]
+	seen := make(map[string]int64)
+	var rowErrors []models.ProductImportError
+	for i, record := range records[1:] {
+		if blankRecord(record) {
+			continue
+		}
+		number := int64(i + 2)
+
+		v := validator.New()
+		row := models.ParseProductRow(v, header, record, number)
+		models.ValidateProductRow(v, row)
+		if first, ok := seen[row.Product.SKU]; ok && row.Product.SKU != "" {
+			v.AddError("sku", fmt.Sprintf("repeats the sku of row %d", first))
+		} else {
+			seen[row.Product.SKU] = number
+		}
+
+		if v.Valid() {
+			created, err := cs.applyRow(productImport, row)
+			switch {
+			case errors.Is(err, repository.ErrInsufficientStock):
+				v.AddError("stock", "cannot be lower than the quantity reserved by orders")
+			case err != nil:
+				v.AddError("row", err.Error())
+			case created:
+				productImport.Created++
+			default:
+				productImport.Updated++
+			}
+		}
+		if !v.Valid() {
+			productImport.Failed++
+			for field, message := range v.Errors {
+				rowErrors = append(rowErrors, models.ProductImportError{
+					ImportID: productImport.ID,
+					Row:      number,
+					SKU:      row.Product.SKU,
+					Field:    field,
+					Message:  message,
+				})
+			}
+		}
+
+		productImport.Processed++
+		if productImport.Processed%importProgressEvery == 0 {
+			if err := cs.saveProgress(productImport, &rowErrors); err != nil {
+				cs.failImport(productImport, err)
+				return
+			}
+		}
+	}
+
+	now := time.Now()
+	productImport.Status = models.ImportStatusCompleted
+	productImport.FinishedAt = &now
+	if err := cs.saveProgress(productImport, &rowErrors); err != nil {
+		cs.failImport(productImport, err)
+	}
+}
+
+// applyRow creates or updates the product with the sku of the row. In a dry
+// run it only reports which of the two would happen.
+func (cs *CatalogService) applyRow(productImport *models.ProductImport, row *models.ProductRow) (bool, error) {
+	existing, err := cs.productRepository.GetProductBySKU(productImport.DistributorID, row.Product.SKU)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	if productImport.DryRun {
+		return existing == nil, nil
+	}
+
+	if existing == nil {
+		product := row.Product
+		product.DistributorID = productImport.DistributorID
+		if err := cs.productService.CreateProduct(&product); err != nil {
+			return false, err
+		}
+		return true, cs.inventoryService.SetTotalStock(&product, row.Stock, productImport.DistributorID)
+	}
+
+	product := mergeProductRow(existing, row)
+	if err := cs.productService.UpdateProduct(product); err != nil {
+		return false, err
+	}
+	if row.Columns["stock"] {
+		if err := cs.inventoryService.SetTotalStock(existing, row.Stock, productImport.DistributorID); err != nil {
+			return false, err
+		}
+	}
+	return false, cs.orderService.ReleaseBackorders(existing.ID)
+}
+
+func (cs *CatalogService) saveProgress(productImport *models.ProductImport, rowErrors *[]models.ProductImportError) error {
+	if err := cs.importRepository.AddImportErrors(*rowErrors); err != nil {
+		return err
+	}
+	*rowErrors = nil
+	return cs.importRepository.UpdateImport(productImport)
+}
+
+func (cs *CatalogService) failImport(productImport *models.ProductImport, err error) {
+	now := time.Now()
+	productImport.Status = models.ImportStatusFailed
+	productImport.Error = err.Error()
+	productImport.FinishedAt = &now
+	_ = cs.importRepository.UpdateImport(productImport)
+}
+
+// mergeProductRow applies the columns present in the row to a copy of the
+// product.
+func mergeProductRow(existing *models.Product, row *models.ProductRow) *models.Product {
+	product := *existing
+	columns := map[string]func(){
+		"product_name":           func() { product.ProductName = row.Product.ProductName },
+		"product_description":    func() { product.ProductDescription = row.Product.ProductDescription },
+		"category":               func() { product.Category = row.Product.Category },
+		"tax_category":           func() { product.TaxCategory = row.Product.TaxCategory },
+		"city":                   func() { product.City = row.Product.City },
+		"price":                  func() { product.Price = row.Product.Price },
+		"minimum_quantity":       func() { product.MinimumQuantity = row.Product.MinimumQuantity },
+		"allow_backorder":        func() { product.AllowBackorder = row.Product.AllowBackorder },
+		"backorder_available_at": func() { product.BackorderAvailableAt = row.Product.BackorderAvailableAt },
+		"img_urls":               func() { product.ImgURLs = row.Product.ImgURLs },
+	}
+	for column, apply := range columns {
+		if row.Columns[column] {
+			apply()
+		}
+	}
+	return &product
+}
+
+func blankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"math"
+	"time"
+)
+
+var ErrCreditLimitExceeded = errors.New("order exceeds the credit limit")
+
+type CreditService struct {
+	creditRepository *repository.CreditRepository
+	storeRepository  *repository.StoreRepository
+}
+
+func NewCreditService(creditRepository *repository.CreditRepository, storeRepository *repository.StoreRepository) *CreditService {
+	return &CreditService{creditRepository: creditRepository, storeRepository: storeRepository}
+}
+
+// GetCreditAccounts returns the credit accounts of the distributor or the
+// store with their outstanding and available credit.
+func (cs *CreditService) GetCreditAccounts(userID int64, role string) ([]models.CreditAccount, error) {
+	accounts, err := cs.creditRepository.GetCreditAccounts(userID, role)
+	if err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		setAvailable(&accounts[i])
+	}
+	return accounts, nil
+}
+
+func (cs *CreditService) GetCreditAccount(distributorID, storeID int64) (*models.CreditAccount, error) {
+	account, err := cs.creditRepository.GetCreditAccount(distributorID, storeID)
+	if err != nil {
+		return nil, err
+	}
+	setAvailable(account)
+	return account, nil
+}
+
+// SaveCreditAccount grants the store credit or changes its terms. The new
+// limit applies to later checkouts; orders already placed are kept.
+func (cs *CreditService) SaveCreditAccount(account *models.CreditAccount) (*models.CreditAccount, error) {
+	if _, err := cs.storeRepository.GetStoreByID(account.StoreID); err != nil {
+		return nil, err
+	}
+	if err := cs.creditRepository.SaveCreditAccount(account); err != nil {
+		return nil, err
+	}
+	return cs.GetCreditAccount(account.DistributorID, account.StoreID)
+}
+
+func (cs *CreditService) DeleteCreditAccount(distributorID, storeID int64) error {
+	return cs.creditRepository.DeleteCreditAccount(distributorID, storeID)
+}
+
+// GetAgingReport returns the distributor's unpaid invoice balances by store
+// and days past due.
+func (cs *CreditService) GetAgingReport(distributorID int64, asOf time.Time) (*models.AgingReport, error) {
+	stores, err := cs.creditRepository.GetAging(distributorID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	report := &models.AgingReport{AsOf: asOf, Stores: stores}
+	for _, store := range stores {
+		report.Totals.Add(store.AgingBuckets)
+	}
+	return report, nil
+}
+
+// checkCredit checks a checkout of amount from the store against its credit
+// limit with the distributor and reports whether its orders go on credit
+// hold. Stores without a credit account are not limited. It must run inside
+// a transaction.
+func (cs *CreditService) checkCredit(tx *gorm.DB, distributorID, storeID int64, amount float64) (bool, error) {
+	creditRepository := cs.creditRepository.WithTx(tx)
+	account, err := creditRepository.LockCreditAccount(distributorID, storeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	outstanding, err := creditRepository.GetOutstanding(distributorID, storeID)
+	if err != nil {
+		return false, err
+	}
+	if !account.Exceeds(outstanding, amount) {
+		return false, nil
+	}
+	if account.OverLimitAction == models.CreditActionFlag {
+		return true, nil
+	}
+	return false, fmt.Errorf("%w with distributor %d: %.2f of %.2f available", ErrCreditLimitExceeded, distributorID, math.Max(account.CreditLimit-outstanding, 0), account.CreditLimit)
+}
+
+func setAvailable(account *models.CreditAccount) {
+	account.Outstanding = models.RoundMoney(account.Outstanding)
+	account.Available = models.RoundMoney(math.Max(account.CreditLimit-account.Outstanding, 0))
+}
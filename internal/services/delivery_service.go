@@ -0,0 +1,70 @@
+package services
+
+import (
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"time"
+)
+
+type DeliveryService struct {
+	deliveryRepository *repository.DeliveryRepository
+}
+
+func NewDeliveryService(deliveryRepository *repository.DeliveryRepository) *DeliveryService {
+	return &DeliveryService{deliveryRepository: deliveryRepository}
+}
+
+func (ds *DeliveryService) CreateSlot(slot *models.DeliverySlot) error {
+	return ds.deliveryRepository.CreateSlot(slot)
+}
+
+func (ds *DeliveryService) UpdateSlot(slot *models.DeliverySlot) error {
+	return ds.deliveryRepository.UpdateSlot(slot)
+}
+
+func (ds *DeliveryService) DeleteSlot(slotID int64) error {
+	return ds.deliveryRepository.DeleteSlot(slotID)
+}
+
+func (ds *DeliveryService) GetSlotByID(slotID int64) (*models.DeliverySlot, error) {
+	return ds.deliveryRepository.GetSlotByID(slotID)
+}
+
+func (ds *DeliveryService) GetSlots(distributorID int64, city string) ([]models.DeliverySlot, error) {
+	return ds.deliveryRepository.GetSlotsByDistributorID(distributorID, city)
+}
+
+// GetAvailableWindows lists bookable delivery windows of the distributor in the
+// city for the given number of days starting from `from`.
+func (ds *DeliveryService) GetAvailableWindows(distributorID int64, city string, from time.Time, days int) ([]models.DeliveryWindow, error) {
+	slots, err := ds.deliveryRepository.GetSlotsByDistributorID(distributorID, city)
+	if err != nil {
+		return nil, err
+	}
+	windows := []models.DeliveryWindow{}
+	for day := 0; day < days; day++ {
+		date := from.AddDate(0, 0, day)
+		for _, slot := range slots {
+			if !slot.Active || slot.Weekday != date.Weekday() {
+				continue
+			}
+			window, err := slot.Window(date)
+			if err != nil {
+				return nil, err
+			}
+			if from.After(slot.Cutoff(window)) {
+				continue
+			}
+			booked, err := ds.deliveryRepository.GetBooked(slot.ID, date)
+			if err != nil {
+				return nil, err
+			}
+			window.Remaining = slot.Capacity - booked
+			if window.Remaining <= 0 {
+				continue
+			}
+			windows = append(windows, *window)
+		}
+	}
+	return windows, nil
+}
@@ -10,18 +10,29 @@ import (
 type DistributorService struct {
 	distributorRepository *repository.DistributorRepository
 	userRepository        *repository.UserRepository
+	ratingRepository      *repository.RatingRepository
 }
 
-func NewDistributorService(distributorRepository *repository.DistributorRepository, userRepository *repository.UserRepository) *DistributorService {
-	return &DistributorService{distributorRepository: distributorRepository, userRepository: userRepository}
+func NewDistributorService(distributorRepository *repository.DistributorRepository, userRepository *repository.UserRepository, ratingRepository *repository.RatingRepository) *DistributorService {
+	return &DistributorService{distributorRepository: distributorRepository, userRepository: userRepository, ratingRepository: ratingRepository}
 }
 
 func (ds *DistributorService) CreateDistributor(distributor *models.Distributor) error {
 	return ds.distributorRepository.CreateDistributor(distributor)
 }
 
+// GetDistributorByID returns the public profile of the distributor with its
+// rating summary.
 func (ds *DistributorService) GetDistributorByID(id int64) (*models.Distributor, error) {
-	return ds.distributorRepository.GetDistributorByID(id)
+	distributor, err := ds.distributorRepository.GetDistributorByID(id)
+	if err != nil {
+		return nil, err
+	}
+	distributor.Rating, err = ds.ratingRepository.GetRatingSummary(models.RatingSubjectDistributor, distributor.ID)
+	if err != nil {
+		return nil, err
+	}
+	return distributor, nil
 }
 
 func (ds *DistributorService) GetStoreByID(id int64) (*models.Store, error) {
@@ -32,6 +43,12 @@ func (ds *DistributorService) UpdateDistributor(distributorID int64, updatedDist
 	return ds.distributorRepository.UpdateDistributor(distributorID, updatedDistributor)
 }
 
+// UpdateTaxSettings changes the VAT settings of the distributor. Orders placed
+// before keep the tax they were placed with.
+func (ds *DistributorService) UpdateTaxSettings(distributorID int64, settings models.TaxSettings) error {
+	return ds.distributorRepository.UpdateTaxSettings(distributorID, settings)
+}
+
 func (ds *DistributorService) DeleteDistributor(distributorID int64) error {
 	return ds.distributorRepository.DeleteDistributor(distributorID)
 }
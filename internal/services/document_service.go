@@ -0,0 +1,232 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"marketplace-api/internal/documents"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"marketplace-api/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrOrderNotInvoiced  = errors.New("order has not been invoiced yet")
+	ErrNothingToDocument = errors.New("all orders of the invoice were cancelled")
+)
+
+type DocumentService struct {
+	documentRepository    *repository.DocumentRepository
+	invoiceRepository     *repository.InvoiceRepository
+	orderRepository       *repository.OrderRepository
+	distributorRepository *repository.DistributorRepository
+	blobStore             storage.BlobStore
+	outbox                *events.Outbox
+}
+
+func NewDocumentService(documentRepository *repository.DocumentRepository, invoiceRepository *repository.InvoiceRepository, orderRepository *repository.OrderRepository, distributorRepository *repository.DistributorRepository, blobStore storage.BlobStore, outbox *events.Outbox) *DocumentService {
+	return &DocumentService{
+		documentRepository:    documentRepository,
+		invoiceRepository:     invoiceRepository,
+		orderRepository:       orderRepository,
+		distributorRepository: distributorRepository,
+		blobStore:             blobStore,
+		outbox:                outbox,
+	}
+}
+
+// GetOrderDocument returns the document of the kind for the invoice of the
+// order of the distributor or the store, and opens its PDF; the caller closes
+// it. The document is issued with the next number of the distributor the
+// first time it is requested; later requests get the same file, even if the
+// orders or the parties changed since.
+func (ds *DocumentService) GetOrderDocument(userID, orderID int64, role, kind string) (*models.Document, io.ReadCloser, error) {
+	document, err := ds.orderDocument(userID, orderID, role, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := ds.openDocument(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	return document, content, nil
+}
+
+func (ds *DocumentService) orderDocument(userID, orderID int64, role, kind string) (*models.Document, error) {
+	order, err := ds.orderRepository.GetOrderByID(userID, orderID, role)
+	if err != nil {
+		return nil, err
+	}
+	if order.InvoiceID == nil {
+		return nil, ErrOrderNotInvoiced
+	}
+	document, err := ds.documentRepository.GetDocument(*order.InvoiceID, kind)
+	if err == nil {
+		return document, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// The PDF is stored before the transaction commits; it is deleted again
+	// if the document is not saved.
+	var key string
+	err = ds.outbox.Transaction(func(tx *gorm.DB) error {
+		invoiceRepository := ds.invoiceRepository.WithTx(tx)
+		documentRepository := ds.documentRepository.WithTx(tx)
+		if _, err := invoiceRepository.LockInvoice(order.DistributorID, *order.InvoiceID); err != nil {
+			return err
+		}
+		document, err = documentRepository.GetDocument(*order.InvoiceID, kind)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		invoice, err := invoiceRepository.GetInvoice(order.DistributorID, *order.InvoiceID, "distributor")
+		if err != nil {
+			return err
+		}
+		document, err = ds.issue(documentRepository, invoice, kind, &key)
+		return err
+	})
+	if err != nil {
+		if key != "" {
+			_ = ds.blobStore.Delete(context.Background(), key)
+		}
+		return nil, err
+	}
+	return document, nil
+}
+
+// openDocument opens the PDF of the document. Documents issued while they were
+// kept in the database are moved to the blob store first.
+func (ds *DocumentService) openDocument(document *models.Document) (io.ReadCloser, error) {
+	if document.Key != "" {
+		return ds.blobStore.Get(context.Background(), document.Key)
+	}
+	content, err := ds.documentRepository.GetLegacyContent(document.ID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ds.storeDocument(document, content)
+	if err != nil {
+		return nil, err
+	}
+	moved, err := ds.documentRepository.MoveDocument(document.ID, key)
+	if err != nil || !moved {
+		_ = ds.blobStore.Delete(context.Background(), key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// storeDocument puts the PDF of the document in the blob store and returns
+// its key.
+func (ds *DocumentService) storeDocument(document *models.Document, content []byte) (string, error) {
+	key := models.DocumentKey(document, strings.Replace(uuid.New().String(), "-", "", -1))
+	if err := ds.blobStore.Put(context.Background(), key, bytes.NewReader(content), int64(len(content)), "application/pdf"); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// issue numbers, renders and stores the document of the invoice. The key the
+// PDF is stored under is set as soon as it is stored.
+func (ds *DocumentService) issue(documentRepository *repository.DocumentRepository, invoice *models.Invoice, kind string, key *string) (*models.Document, error) {
+	data, err := ds.documentData(invoice)
+	if err != nil {
+		return nil, err
+	}
+	number, err := documentRepository.NextNumber(invoice.DistributorID, kind)
+	if err != nil {
+		return nil, err
+	}
+	document := &models.Document{
+		DistributorID: invoice.DistributorID,
+		Kind:          kind,
+		Number:        number,
+		InvoiceID:     invoice.ID,
+		StoreID:       invoice.StoreID,
+	}
+	data.Number = document.DisplayNumber()
+	content, err := documents.Render(kind, data)
+	if err != nil {
+		return nil, err
+	}
+	checksum := sha256.Sum256(content)
+	document.FileName = data.Number + ".pdf"
+	document.Size = int64(len(content))
+	document.Checksum = hex.EncodeToString(checksum[:])
+	document.Key, err = ds.storeDocument(document, content)
+	if err != nil {
+		return nil, err
+	}
+	*key = document.Key
+	if err := documentRepository.CreateDocument(document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// documentData collects the parties and the lines of the invoice that were
+// not cancelled.
+func (ds *DocumentService) documentData(invoice *models.Invoice) (*documents.Data, error) {
+	distributor, err := ds.distributorRepository.GetDistributorByID(invoice.DistributorID)
+	if err != nil {
+		return nil, err
+	}
+	store, err := ds.distributorRepository.GetStoreByID(invoice.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	data := &documents.Data{
+		IssuedAt: time.Now(),
+		Seller:   documents.Party{CompanyName: distributor.CompanyName, BIN: distributor.BIN, Address: distributor.City, Phone: distributor.PhoneNumber},
+		Buyer:    documents.Party{CompanyName: store.CompanyName, BIN: store.BIN, Address: store.City, Phone: store.PhoneNumber},
+		DueAt:    invoice.DueAt,
+	}
+	for _, order := range invoice.Orders {
+		if order.Stage.Status == models.StageStatusError {
+			continue
+		}
+		if data.DeliveryAddress == "" && order.Address != "" {
+			data.DeliveryAddress = order.City + ", " + order.Address
+		}
+		if data.DeliveryDate == nil {
+			data.DeliveryDate = order.DeliveryStart
+		}
+		data.OrderNumbers = append(data.OrderNumbers, order.Number)
+		data.Lines = append(data.Lines, documents.Line{
+			Name:        order.Snapshot.ProductName,
+			SKU:         order.Snapshot.SKU,
+			Quantity:    order.Quantity,
+			UnitPrice:   order.Snapshot.UnitPrice,
+			Discount:    order.DiscountAmount,
+			NetAmount:   order.NetAmount,
+			TaxRate:     order.TaxRate,
+			TaxAmount:   order.TaxAmount,
+			GrossAmount: order.TotalPrice,
+		})
+		data.NetAmount += order.NetAmount
+		data.TaxAmount += order.TaxAmount
+		data.GrossAmount += order.TotalPrice
+		data.DiscountAmount += order.DiscountAmount
+	}
+	if len(data.Lines) == 0 {
+		return nil, ErrNothingToDocument
+	}
+	data.NetAmount = models.RoundMoney(data.NetAmount)
+	data.TaxAmount = models.RoundMoney(data.TaxAmount)
+	data.GrossAmount = models.RoundMoney(data.GrossAmount)
+	data.DiscountAmount = models.RoundMoney(data.DiscountAmount)
+	return data, nil
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrExchangeFileName = errors.New("invalid exchange file name")
+	ErrExchangeFileType = errors.New("unsupported exchange file")
+)
+
+// ExchangeResult summarises an imported 1C file.
+type ExchangeResult struct {
+	Created  int
+	Updated  int
+	Skipped  int
+	Problems []string
+}
+
+// ExchangeService implements the CommerceML 2 exchange with 1C. Uploaded files
+// are kept per distributor until the next exchange session starts.
+type ExchangeService struct {
+	productRepository     *repository.ProductRepository
+	productService        *ProductService
+	orderRepository       *repository.OrderRepository
+	distributorRepository *repository.DistributorRepository
+	inventoryService      *InventoryService
+	orderService          *OrderService
+	dir                   string
+}
+
+func NewExchangeService(productRepository *repository.ProductRepository, productService *ProductService, orderRepository *repository.OrderRepository, distributorRepository *repository.DistributorRepository, inventoryService *InventoryService, orderService *OrderService, dir string) *ExchangeService {
+	return &ExchangeService{productRepository: productRepository, productService: productService, orderRepository: orderRepository, distributorRepository: distributorRepository, inventoryService: inventoryService, orderService: orderService, dir: dir}
+}
+
+// Init starts an exchange session by removing files of the previous one.
+func (es *ExchangeService) Init(distributorID int64) error {
+	dir := es.distributorDir(distributorID)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+// SaveFile appends the uploaded chunk to the file, 1C sends large files in
+// several requests.
+func (es *ExchangeService) SaveFile(distributorID int64, fileName string, body io.Reader) error {
+	path, err := es.filePath(distributorID, fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ImportFile applies an uploaded import.xml or offers.xml to the catalog.
+func (es *ExchangeService) ImportFile(distributorID int64, fileName string) (*ExchangeResult, error) {
+	path, err := es.filePath(distributorID, fileName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	base := strings.ToLower(filepath.Base(fileName))
+	switch {
+	case strings.HasPrefix(base, "import") && strings.HasSuffix(base, ".xml"):
+		var document models.CommerceMLImport
+		if err := xml.NewDecoder(f).Decode(&document); err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		return es.importCatalog(distributorID, &document)
+	case strings.HasPrefix(base, "offers") && strings.HasSuffix(base, ".xml"):
+		var document models.CommerceMLOffers
+		if err := xml.NewDecoder(f).Decode(&document); err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		return es.importOffers(distributorID, &document)
+	}
+	return nil, ErrExchangeFileType
+}
+
+// QueryOrders returns the orders 1C has not received yet as orders.xml.
+func (es *ExchangeService) QueryOrders(distributorID int64) ([]byte, error) {
+	orders, err := es.orderRepository.GetOrdersForExchange(distributorID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	document := models.CommerceMLOrders{
+		Version:   models.CommerceMLVersion,
+		CreatedAt: now.Format("2006-01-02T15:04:05"),
+	}
+	orderIDs := make([]int64, 0, len(orders))
+	for i := range orders {
+		document.Documents = append(document.Documents, models.NewCommerceMLDocument(&orders[i]))
+		orderIDs = append(orderIDs, orders[i].ID)
+	}
+	content, err := xml.MarshalIndent(document, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := es.orderRepository.MarkOrdersSent(orderIDs, now); err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+// ConfirmOrders marks the orders of the last query as received by 1C.
+func (es *ExchangeService) ConfirmOrders(distributorID int64) error {
+	return es.orderRepository.ConfirmSentOrders(distributorID)
+}
+
+// ApplyOrderChanges reads an orders.xml sent by 1C and moves the orders to the
+// stages it contains through the same status changes the distributor uses.
+func (es *ExchangeService) ApplyOrderChanges(distributorID int64, fileName string) (*ExchangeResult, error) {
+	path, err := es.filePath(distributorID, fileName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var document models.CommerceMLOrders
+	if err := xml.NewDecoder(f).Decode(&document); err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	result := &ExchangeResult{}
+	for _, doc := range document.Documents {
+		orderID, err := doc.OrderID()
+		if err != nil {
+			result.Skipped++
+			continue
+		}
+		target, ok := doc.TargetStage()
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		order, err := es.orderService.GetOrderByID(distributorID, orderID, "distributor")
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				result.Problems = append(result.Problems, fmt.Sprintf("order %d: not found", orderID))
+				continue
+			}
+			return nil, err
+		}
+		changed, err := es.moveOrderToStage(order, target)
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("order %d: %s", orderID, err.Error()))
+			continue
+		}
+		if changed {
+			result.Updated++
+		} else {
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
+
+// moveOrderToStage passes the order through the stages up to the target, one
+// status change at a time. Orders are never moved back.
+func (es *ExchangeService) moveOrderToStage(order *models.Order, target string) (bool, error) {
+	if order.Status == models.OrderStatusClosed {
+		return false, nil
+	}
+	if target == models.CommerceMLCancelled {
+		return true, es.orderService.ChangeOrderStatus(*order, models.StageStatusError)
+	}
+
+	changed := false
+	for range models.OrderStages {
+		stage, err := es.orderRepository.GetStageByID(order.StageID)
+		if err != nil {
+			return changed, err
+		}
+		current := models.StageIndex(stage.Stage)
+		if current < 0 {
+			return changed, fmt.Errorf("order in stage %s cannot be changed from 1C", stage.Stage)
+		}
+		if current >= models.StageIndex(target) {
+			return changed, nil
+		}
+		if err := es.orderService.ChangeOrderStatus(*order, models.StageStatusSuccess); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func (es *ExchangeService) importCatalog(distributorID int64, document *models.CommerceMLImport) (*ExchangeResult, error) {
+	distributor, err := es.distributorRepository.GetDistributorByID(distributorID)
+	if err != nil {
+		return nil, err
+	}
+	groups := document.GroupNames()
+
+	result := &ExchangeResult{}
+	for _, item := range document.Products {
+		if item.ID == "" || strings.TrimSpace(item.Name) == "" || item.Status == "Удален" {
+			result.Skipped++
+			continue
+		}
+		existing, err := es.findProduct(distributorID, item.ID, item.SKU)
+		if err != nil {
+			return nil, err
+		}
+
+		category := ""
+		if len(item.Groups) > 0 {
+			category = groups[item.Groups[0]]
+		}
+		if existing == nil {
+			product := &models.Product{
+				ProductName:        strings.TrimSpace(item.Name),
+				SKU:                strings.TrimSpace(item.SKU),
+				ExternalID:         item.ID,
+				ProductDescription: strings.TrimSpace(item.Description),
+				DistributorID:      distributorID,
+				City:               distributor.City,
+				Category:           category,
+			}
+			if err := es.productService.CreateProduct(product); err != nil {
+				return nil, err
+			}
+			result.Created++
+			continue
+		}
+
+		product := *existing
+		product.ProductName = strings.TrimSpace(item.Name)
+		product.ExternalID = item.ID
+		if sku := strings.TrimSpace(item.SKU); sku != "" {
+			product.SKU = sku
+		}
+		if description := strings.TrimSpace(item.Description); description != "" {
+			product.ProductDescription = description
+		}
+		if category != "" {
+			product.Category = category
+		}
+		if err := es.productService.UpdateProduct(&product); err != nil {
+			return nil, err
+		}
+		result.Updated++
+	}
+	return result, nil
+}
+
+func (es *ExchangeService) importOffers(distributorID int64, document *models.CommerceMLOffers) (*ExchangeResult, error) {
+	result := &ExchangeResult{}
+	for _, offer := range document.Offers {
+		product, err := es.findProduct(distributorID, offer.ProductID(), offer.SKU)
+		if err != nil {
+			return nil, err
+		}
+		if product == nil {
+			result.Skipped++
+			continue
+		}
+
+		if price, ok := offer.Price(); ok {
+			if err := es.productService.UpdatePrice(product.ID, price); err != nil {
+				return nil, err
+			}
+		}
+		if stock, ok := offer.Stock(); ok {
+			err := es.inventoryService.SetTotalStock(product, max(stock, 0), distributorID)
+			if errors.Is(err, repository.ErrInsufficientStock) {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: stock %d is lower than the quantity reserved by orders", offer.ID, stock))
+			} else if err != nil {
+				return nil, err
+			}
+			if err := es.orderService.ReleaseBackorders(product.ID); err != nil {
+				return nil, err
+			}
+		}
+		result.Updated++
+	}
+	return result, nil
+}
+
+// findProduct looks a 1C product up by its id and falls back to the sku for
+// products created in the marketplace before the first exchange.
+func (es *ExchangeService) findProduct(distributorID int64, externalID, sku string) (*models.Product, error) {
+	product, err := es.productRepository.GetProductByExternalID(distributorID, externalID)
+	if err == nil {
+		return product, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if sku = strings.TrimSpace(sku); sku == "" {
+		return nil, nil
+	}
+	product, err = es.productRepository.GetProductBySKU(distributorID, sku)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if product.ExternalID != "" && product.ExternalID != externalID {
+		return nil, nil
+	}
+	return product, nil
+}
+
+func (es *ExchangeService) distributorDir(distributorID int64) string {
+	return filepath.Join(es.dir, strconv.FormatInt(distributorID, 10))
+}
+
+// filePath resolves a file name sent by 1C inside the distributor directory.
+// Names may contain sub-directories, e.g. import_files/ab/image.jpg.
+func (es *ExchangeService) filePath(distributorID int64, fileName string) (string, error) {
+	name := filepath.Clean(filepath.FromSlash(strings.TrimSpace(fileName)))
+	if name == "." || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", ErrExchangeFileName
+	}
+	return filepath.Join(es.distributorDir(distributorID), name), nil
+}
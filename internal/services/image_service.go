@@ -0,0 +1,144 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"marketplace-api/internal/imaging"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"marketplace-api/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrImageTooLarge    = errors.New("image is too large")
+	ErrImageUnsupported = errors.New("file is not a JPEG, PNG, GIF or WebP image")
+)
+
+type ImageService struct {
+	uploadRepository *repository.UploadRepository
+	blobStore        storage.BlobStore
+	maxSize          int64
+}
+
+func NewImageService(uploadRepository *repository.UploadRepository, blobStore storage.BlobStore, maxSize int64) *ImageService {
+	return &ImageService{uploadRepository: uploadRepository, blobStore: blobStore, maxSize: maxSize}
+}
+
+// Upload validates the image and stores its variants. The original file is
+// not kept, so none of its metadata is served.
+func (is *ImageService) Upload(ctx context.Context, uploaderID int64, file io.Reader) (*models.Upload, error) {
+	data, err := io.ReadAll(io.LimitReader(file, is.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > is.maxSize {
+		return nil, ErrImageTooLarge
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		if errors.Is(err, imaging.ErrTooManyPixels) {
+			return nil, ErrImageTooLarge
+		}
+		return nil, ErrImageUnsupported
+	}
+
+	upload := &models.Upload{
+		Key:        strings.Replace(uuid.New().String(), "-", "", -1),
+		UploaderID: uploaderID,
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+		Size:       int64(len(data)),
+	}
+	var stored []string
+	for size, pixels := range models.ImageSizes {
+		variant := imaging.Fit(img, pixels)
+		for format, contentType := range models.ImageFormats {
+			var encoded []byte
+			if format == models.ImageFormatWebP {
+				encoded, err = imaging.EncodeWebP(variant)
+			} else {
+				encoded, err = imaging.EncodeJPEG(variant)
+			}
+			if err == nil {
+				key := models.ImageVariantKey(upload.Key, size, format)
+				err = is.blobStore.Put(ctx, key, bytes.NewReader(encoded), int64(len(encoded)), contentType)
+				stored = append(stored, key)
+			}
+			if err != nil {
+				is.deleteKeys(stored)
+				return nil, err
+			}
+		}
+	}
+	upload.URL = is.blobStore.URL(models.ImageVariantKey(upload.Key, models.ImageLarge, models.ImageFormatJPEG))
+	if err := is.uploadRepository.CreateUpload(upload); err != nil {
+		is.deleteKeys(stored)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// DeleteImages removes the images from the blob store unless a product, an
+// order or a message still uses them. Failures leave the files in place for
+// the orphan collection; they do not affect the caller.
+func (is *ImageService) DeleteImages(urls []string) {
+	for _, url := range urls {
+		inUse, err := is.uploadRepository.ImageInUse(url)
+		if err != nil || inUse {
+			continue
+		}
+		upload, err := is.uploadRepository.GetUploadByURL(url)
+		if err == nil {
+			is.deleteUpload(upload)
+			continue
+		}
+		// Images uploaded before variants existed are a single file.
+		if key, ok := is.blobStore.Key(url); ok && errors.Is(err, gorm.ErrRecordNotFound) {
+			is.deleteKeys([]string{key})
+		}
+	}
+}
+
+// CollectOrphans deletes the uploads created before the time that nothing
+// refers to, such as images uploaded for a product that was never saved.
+func (is *ImageService) CollectOrphans(before time.Time) (int, error) {
+	collected := 0
+	for {
+		uploads, err := is.uploadRepository.GetOrphanedUploads(before, 100)
+		if err != nil {
+			return collected, err
+		}
+		for i := range uploads {
+			if err := is.deleteUpload(&uploads[i]); err != nil {
+				return collected, err
+			}
+			collected++
+		}
+		if len(uploads) < 100 {
+			return collected, nil
+		}
+	}
+}
+
+// deleteUpload deletes the variants and then the upload, so a failed delete
+// is retried by the next collection.
+func (is *ImageService) deleteUpload(upload *models.Upload) error {
+	for _, key := range models.ImageVariantKeys(upload.Key) {
+		if err := is.blobStore.Delete(context.Background(), key); err != nil {
+			return err
+		}
+	}
+	return is.uploadRepository.DeleteUpload(upload.ID)
+}
+
+func (is *ImageService) deleteKeys(keys []string) {
+	for _, key := range keys {
+		_ = is.blobStore.Delete(context.Background(), key)
+	}
+}
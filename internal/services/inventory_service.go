@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+)
+
+var ErrWarehouseInUse = errors.New("warehouse has stock movements and can only be deactivated")
+
+type InventoryService struct {
+	inventoryRepository   *repository.InventoryRepository
+	distributorRepository *repository.DistributorRepository
+	payoutService         *PayoutService
+	outbox                *events.Outbox
+}
+
+func NewInventoryService(inventoryRepository *repository.InventoryRepository, distributorRepository *repository.DistributorRepository, payoutService *PayoutService, outbox *events.Outbox) *InventoryService {
+	return &InventoryService{inventoryRepository: inventoryRepository, distributorRepository: distributorRepository, payoutService: payoutService, outbox: outbox}
+}
+
+func (is *InventoryService) CreateWarehouse(warehouse *models.Warehouse) error {
+	return is.inventoryRepository.CreateWarehouse(warehouse)
+}
+
+func (is *InventoryService) UpdateWarehouse(warehouse *models.Warehouse) error {
+	return is.inventoryRepository.UpdateWarehouse(warehouse)
+}
+
+func (is *InventoryService) DeleteWarehouse(warehouseID int64) error {
+	inUse, err := is.inventoryRepository.HasMovements(warehouseID)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrWarehouseInUse
+	}
+	return is.inventoryRepository.DeleteWarehouse(warehouseID)
+}
+
+func (is *InventoryService) GetWarehouseByID(warehouseID int64) (*models.Warehouse, error) {
+	return is.inventoryRepository.GetWarehouseByID(warehouseID)
+}
+
+func (is *InventoryService) GetWarehouses(distributorID int64) ([]models.Warehouse, error) {
+	return is.inventoryRepository.GetWarehouses(distributorID)
+}
+
+func (is *InventoryService) GetStockLevels(productID int64) ([]models.StockLevel, error) {
+	return is.inventoryRepository.GetStockLevels(productID)
+}
+
+func (is *InventoryService) GetWarehouseStockLevels(warehouseID int64) ([]models.StockLevel, error) {
+	return is.inventoryRepository.GetWarehouseStockLevels(warehouseID)
+}
+
+func (is *InventoryService) GetMovements(distributorID, productID, warehouseID int64, filters models.Filters) ([]models.StockMovement, models.Metadata, error) {
+	return is.inventoryRepository.GetMovements(distributorID, productID, warehouseID, filters)
+}
+
+// RecordMovement appends a receipt, return or manual adjustment to the ledger.
+// Returns of a delivered order are deducted from the distributor's payout.
+func (is *InventoryService) RecordMovement(input *models.StockMovementInput, userID int64) (*models.StockMovement, error) {
+	movement := &models.StockMovement{
+		WarehouseID: input.WarehouseID,
+		ProductID:   input.ProductID,
+		OrderID:     input.OrderID,
+		Type:        input.Type,
+		OnHandDelta: input.Quantity,
+		Note:        input.Note,
+		CreatedBy:   userID,
+	}
+	err := is.outbox.Transaction(func(tx *gorm.DB) error {
+		if err := is.appendMovementTx(tx, movement); err != nil {
+			return err
+		}
+		if movement.Type == models.MovementReturn && movement.OrderID != nil {
+			return is.payoutService.recordReturn(tx, userID, movement)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+// SetTotalStock records the difference between the requested and the current
+// total stock as an adjustment in the distributor's default warehouse. It keeps
+// the stock field of the product endpoints working on top of the ledger.
+func (is *InventoryService) SetTotalStock(product *models.Product, stock int64, userID int64) error {
+	delta := stock - product.Stock
+	if delta == 0 {
+		return nil
+	}
+	warehouse, err := is.defaultWarehouse(product.DistributorID)
+	if err != nil {
+		return err
+	}
+	movementType := models.MovementAdjustment
+	if product.Stock == 0 && delta > 0 {
+		movementType = models.MovementReceipt
+	}
+	return is.appendMovement(&models.StockMovement{
+		WarehouseID: warehouse.ID,
+		ProductID:   product.ID,
+		Type:        movementType,
+		OnHandDelta: delta,
+		Note:        "stock set from product",
+		CreatedBy:   userID,
+	})
+}
+
+func (is *InventoryService) appendMovement(movement *models.StockMovement) error {
+	return is.outbox.Transaction(func(tx *gorm.DB) error {
+		return is.appendMovementTx(tx, movement)
+	})
+}
+
+func (is *InventoryService) appendMovementTx(tx *gorm.DB, movement *models.StockMovement) error {
+	watch := watchStock(tx, is.outbox, movement.ProductID)
+	if err := is.inventoryRepository.WithTx(tx).AppendMovement(movement); err != nil {
+		return err
+	}
+	return watch.done()
+}
+
+// defaultWarehouse returns the first active warehouse of the distributor and
+// creates one in the distributor's city if there is none.
+func (is *InventoryService) defaultWarehouse(distributorID int64) (*models.Warehouse, error) {
+	warehouses, err := is.inventoryRepository.GetWarehouses(distributorID)
+	if err != nil {
+		return nil, err
+	}
+	for _, warehouse := range warehouses {
+		if warehouse.Active {
+			return &warehouse, nil
+		}
+	}
+	distributor, err := is.distributorRepository.GetDistributorByID(distributorID)
+	if err != nil {
+		return nil, err
+	}
+	warehouse := &models.Warehouse{
+		DistributorID: distributorID,
+		Name:          "Main warehouse",
+		City:          distributor.City,
+		Active:        true,
+	}
+	if err := is.inventoryRepository.CreateWarehouse(warehouse); err != nil {
+		return nil, err
+	}
+	return warehouse, nil
+}
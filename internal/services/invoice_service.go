@@ -0,0 +1,229 @@
+package services
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"time"
+)
+
+var (
+	ErrInvoiceOrders    = errors.New("orders must exist, belong to one store and not be invoiced yet")
+	ErrInvoiceCancelled = errors.New("cancelled orders cannot be invoiced")
+	ErrRefundTooLarge   = errors.New("refund exceeds the paid amount")
+)
+
+type InvoiceService struct {
+	invoiceRepository *repository.InvoiceRepository
+	creditRepository  *repository.CreditRepository
+	outbox            *events.Outbox
+	paymentTermsDays  int
+}
+
+func NewInvoiceService(invoiceRepository *repository.InvoiceRepository, creditRepository *repository.CreditRepository, outbox *events.Outbox, paymentTermsDays int) *InvoiceService {
+	return &InvoiceService{invoiceRepository: invoiceRepository, creditRepository: creditRepository, outbox: outbox, paymentTermsDays: paymentTermsDays}
+}
+
+// IssueInvoice bills orders of the distributor that were placed without an
+// invoice, e.g. to invoice several checkouts of a store together.
+func (ins *InvoiceService) IssueInvoice(distributorID int64, input *models.InvoiceInput) (*models.Invoice, error) {
+	var invoice *models.Invoice
+	err := ins.outbox.Transaction(func(tx *gorm.DB) error {
+		orders, err := ins.invoiceRepository.WithTx(tx).LockOrders(distributorID, input.OrderIDs)
+		if err != nil {
+			return err
+		}
+		if len(orders) != len(input.OrderIDs) {
+			return ErrInvoiceOrders
+		}
+		for _, order := range orders {
+			if order.InvoiceID != nil || order.StoreID != orders[0].StoreID {
+				return ErrInvoiceOrders
+			}
+			if order.Stage.Status == models.StageStatusError {
+				return ErrInvoiceCancelled
+			}
+		}
+		lines := make([]*models.Order, len(orders))
+		for i := range orders {
+			lines[i] = &orders[i]
+		}
+		invoice, err = ins.issue(tx, lines)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ins.GetInvoice(distributorID, invoice.ID, "distributor")
+}
+
+// issue creates the invoice of orders placed by one store with one distributor,
+// due after the store's payment terms, and sets the invoice of the orders. It
+// must run inside a transaction.
+func (ins *InvoiceService) issue(tx *gorm.DB, orders []*models.Order) (*models.Invoice, error) {
+	terms := ins.paymentTermsDays
+	account, err := ins.creditRepository.WithTx(tx).GetCreditAccount(orders[0].DistributorID, orders[0].StoreID)
+	if err == nil {
+		terms = account.PaymentTermsDays
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	now := time.Now()
+	invoice := &models.Invoice{
+		DistributorID: orders[0].DistributorID,
+		StoreID:       orders[0].StoreID,
+		IssuedAt:      now,
+		DueAt:         now.AddDate(0, 0, terms),
+	}
+	orderIDs := make([]int64, len(orders))
+	for i, order := range orders {
+		invoice.Amount = models.RoundMoney(invoice.Amount + order.TotalPrice)
+		invoice.NetAmount = models.RoundMoney(invoice.NetAmount + order.NetAmount)
+		invoice.TaxAmount = models.RoundMoney(invoice.TaxAmount + order.TaxAmount)
+		orderIDs[i] = order.ID
+	}
+
+	invoiceRepository := ins.invoiceRepository.WithTx(tx)
+	if err := invoiceRepository.CreateInvoice(invoice); err != nil {
+		return nil, err
+	}
+	attached, err := invoiceRepository.AttachOrders(invoice.ID, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	if attached != int64(len(orderIDs)) {
+		return nil, ErrInvoiceOrders
+	}
+	for _, order := range orders {
+		order.InvoiceID = &invoice.ID
+	}
+	invoice.Settle(now)
+	return invoice, ins.outbox.Record(tx, events.NewInvoiceEvent(events.InvoiceIssued, invoice, invoice))
+}
+
+// RecordPayment records a payment or a refund on the invoice of the
+// distributor. Payments may exceed the balance; the overpayment shows as a
+// negative balance until it is refunded.
+func (ins *InvoiceService) RecordPayment(distributorID, invoiceID, recordedBy int64, input *models.PaymentInput) (*models.Invoice, error) {
+	err := ins.outbox.Transaction(func(tx *gorm.DB) error {
+		invoiceRepository := ins.invoiceRepository.WithTx(tx)
+		invoice, err := invoiceRepository.LockInvoice(distributorID, invoiceID)
+		if err != nil {
+			return err
+		}
+		payment := &models.Payment{
+			InvoiceID:  invoice.ID,
+			Kind:       input.Kind,
+			Amount:     input.Amount,
+			Method:     input.Method,
+			Reference:  input.Reference,
+			PaidAt:     time.Now(),
+			RecordedBy: recordedBy,
+		}
+		if input.PaidAt != nil {
+			payment.PaidAt = *input.PaidAt
+		}
+		if payment.Kind == models.PaymentKindRefund {
+			if payment.Amount > invoice.PaidAmount {
+				return ErrRefundTooLarge
+			}
+			invoice.PaidAmount = models.RoundMoney(invoice.PaidAmount - payment.Amount)
+		} else {
+			invoice.PaidAmount = models.RoundMoney(invoice.PaidAmount + payment.Amount)
+		}
+		if err := invoiceRepository.CreatePayment(payment); err != nil {
+			return err
+		}
+		settlePaidAt(invoice, payment.PaidAt)
+		if err := invoiceRepository.UpdatePaidAmount(invoice); err != nil {
+			return err
+		}
+		event := events.NewInvoiceEvent(events.PaymentRecorded, invoice, payment)
+		event.ActorID = recordedBy
+		return ins.outbox.Record(tx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ins.GetInvoice(distributorID, invoiceID, "distributor")
+}
+
+// recalculate updates the amount of the invoice after one of its orders was
+// cancelled. It must run inside a transaction.
+func (ins *InvoiceService) recalculate(tx *gorm.DB, invoiceID int64) error {
+	invoiceRepository := ins.invoiceRepository.WithTx(tx)
+	invoice, err := invoiceRepository.RecalculateAmount(invoiceID)
+	if err != nil {
+		return err
+	}
+	paidAt := invoice.PaidAt
+	settlePaidAt(invoice, time.Now())
+	if paidAt == invoice.PaidAt {
+		return nil
+	}
+	return invoiceRepository.SetPaidAt(invoice.ID, invoice.PaidAt)
+}
+
+// settlePaidAt sets PaidAt when the invoice becomes paid and clears it when it
+// is no longer paid.
+func settlePaidAt(invoice *models.Invoice, at time.Time) {
+	invoice.Settle(time.Now())
+	switch {
+	case invoice.PaymentStatus == models.PaymentStatusPaid && invoice.PaidAt == nil:
+		invoice.PaidAt = &at
+	case invoice.PaymentStatus != models.PaymentStatusPaid:
+		invoice.PaidAt = nil
+	}
+}
+
+func (ins *InvoiceService) GetInvoice(userID, invoiceID int64, role string) (*models.Invoice, error) {
+	invoice, err := ins.invoiceRepository.GetInvoice(userID, invoiceID, role)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Settle(time.Now())
+	for i := range invoice.Orders {
+		invoice.Orders[i].PaymentStatus = invoice.PaymentStatus
+	}
+	return invoice, nil
+}
+
+func (ins *InvoiceService) GetInvoices(userID int64, role string, counterpartyID int64, paymentStatus string, filters models.Filters) ([]models.Invoice, models.Metadata, error) {
+	invoices, metadata, err := ins.invoiceRepository.GetInvoices(userID, role, counterpartyID, paymentStatus, filters)
+	if err != nil {
+		return nil, models.Metadata{}, err
+	}
+	now := time.Now()
+	for i := range invoices {
+		invoices[i].Settle(now)
+	}
+	return invoices, metadata, nil
+}
+
+// AttachPaymentStatus sets the payment status of each order from its invoice.
+func (ins *InvoiceService) AttachPaymentStatus(orders []models.Order) error {
+	var invoiceIDs []int64
+	for _, order := range orders {
+		if order.InvoiceID != nil {
+			invoiceIDs = append(invoiceIDs, *order.InvoiceID)
+		}
+	}
+	invoices, err := ins.invoiceRepository.GetInvoicesByID(invoiceIDs)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	statuses := make(map[int64]string, len(invoices))
+	for _, invoice := range invoices {
+		invoice.Settle(now)
+		statuses[invoice.ID] = invoice.PaymentStatus
+	}
+	for i, order := range orders {
+		if order.InvoiceID != nil {
+			orders[i].PaymentStatus = statuses[*order.InvoiceID]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"time"
+)
+
+var (
+	ErrThreadLocked      = errors.New("thread is locked by a moderator")
+	ErrThreadOrder       = errors.New("order does not belong to the participants of the thread")
+	ErrThreadProduct     = errors.New("product does not belong to the distributor of the thread")
+	ErrThreadParticipant = errors.New("store or distributor of the thread not found")
+)
+
+type MessageService struct {
+	messageRepository     *repository.MessageRepository
+	orderRepository       *repository.OrderRepository
+	productRepository     *repository.ProductRepository
+	distributorRepository *repository.DistributorRepository
+	outbox                *events.Outbox
+}
+
+func NewMessageService(messageRepository *repository.MessageRepository, orderRepository *repository.OrderRepository, productRepository *repository.ProductRepository, distributorRepository *repository.DistributorRepository, outbox *events.Outbox) *MessageService {
+	return &MessageService{messageRepository: messageRepository, orderRepository: orderRepository, productRepository: productRepository, distributorRepository: distributorRepository, outbox: outbox}
+}
+
+// NewThread builds the thread the user starts from the input. The other
+// participant is taken from the order when one is given.
+func (ms *MessageService) NewThread(role string, userID int64, input *models.ThreadInput) (*models.MessageThread, error) {
+	thread := &models.MessageThread{
+		StoreID:       input.StoreID,
+		DistributorID: input.DistributorID,
+		OrderID:       input.OrderID,
+		ProductID:     input.ProductID,
+		Subject:       input.Subject,
+	}
+	if role == models.RoleStore {
+		thread.StoreID = userID
+	} else {
+		thread.DistributorID = userID
+	}
+
+	if input.OrderID != nil {
+		order, err := ms.orderRepository.GetOrderByID(userID, *input.OrderID, role)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrThreadOrder
+			}
+			return nil, err
+		}
+		if (input.StoreID != 0 && input.StoreID != order.StoreID) || (input.DistributorID != 0 && input.DistributorID != order.DistributorID) {
+			return nil, ErrThreadOrder
+		}
+		thread.StoreID = order.StoreID
+		thread.DistributorID = order.DistributorID
+		if thread.Subject == "" {
+			thread.Subject = "Order " + order.Number
+		}
+	}
+	if input.ProductID != nil {
+		product, err := ms.productRepository.GetProductByID(*input.ProductID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrThreadProduct
+			}
+			return nil, err
+		}
+		if thread.DistributorID == 0 {
+			thread.DistributorID = product.DistributorID
+		}
+		if product.DistributorID != thread.DistributorID {
+			return nil, ErrThreadProduct
+		}
+		if thread.Subject == "" {
+			thread.Subject = product.ProductName
+		}
+	}
+
+	if _, err := ms.distributorRepository.GetDistributorByID(thread.DistributorID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrThreadParticipant
+		}
+		return nil, err
+	}
+	if _, err := ms.distributorRepository.GetStoreByID(thread.StoreID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrThreadParticipant
+		}
+		return nil, err
+	}
+	return thread, nil
+}
+
+// StartThread creates the thread with its first message.
+func (ms *MessageService) StartThread(thread *models.MessageThread, message *models.Message) error {
+	return ms.outbox.Transaction(func(tx *gorm.DB) error {
+		messageRepository := ms.messageRepository.WithTx(tx)
+		thread.LastMessageAt = time.Now()
+		if err := messageRepository.CreateThread(thread); err != nil {
+			return err
+		}
+		return ms.createMessage(tx, thread, message)
+	})
+}
+
+// PostMessage adds the message to the thread unless a moderator locked it.
+func (ms *MessageService) PostMessage(thread *models.MessageThread, message *models.Message) error {
+	if thread.Locked {
+		return ErrThreadLocked
+	}
+	return ms.outbox.Transaction(func(tx *gorm.DB) error {
+		return ms.createMessage(tx, thread, message)
+	})
+}
+
+func (ms *MessageService) createMessage(tx *gorm.DB, thread *models.MessageThread, message *models.Message) error {
+	message.ThreadID = thread.ID
+	message.CreatedAt = time.Now()
+	if err := ms.messageRepository.WithTx(tx).CreateMessage(message); err != nil {
+		return err
+	}
+	thread.LastMessageAt = message.CreatedAt
+	return ms.outbox.Record(tx, events.NewMessageEvent(events.MessageCreated, thread, message))
+}
+
+func (ms *MessageService) GetThreadByID(threadID, readerID int64) (*models.MessageThread, error) {
+	return ms.messageRepository.GetThreadByID(threadID, readerID)
+}
+
+func (ms *MessageService) GetThreads(query repository.ThreadQuery, filters models.Filters) ([]models.MessageThread, models.Metadata, error) {
+	return ms.messageRepository.GetThreads(query, filters)
+}
+
+func (ms *MessageService) GetMessages(threadID int64, includeHidden bool) ([]models.Message, error) {
+	return ms.messageRepository.GetMessages(threadID, includeHidden)
+}
+
+func (ms *MessageService) GetMessageByID(messageID int64) (*models.Message, error) {
+	return ms.messageRepository.GetMessageByID(messageID)
+}
+
+func (ms *MessageService) CountUnread(userID int64) (int64, error) {
+	return ms.messageRepository.CountUnread(userID)
+}
+
+// MarkRead sets the read receipts of the messages the reader received in the
+// thread.
+func (ms *MessageService) MarkRead(threadID, readerID int64) (int64, error) {
+	return ms.messageRepository.MarkRead(threadID, readerID, time.Now())
+}
+
+func (ms *MessageService) HideMessage(messageID, adminID int64, reason string) error {
+	return ms.messageRepository.HideMessage(messageID, adminID, reason, time.Now())
+}
+
+func (ms *MessageService) RestoreMessage(messageID int64) error {
+	return ms.messageRepository.RestoreMessage(messageID)
+}
+
+func (ms *MessageService) SetThreadLocked(threadID int64, locked bool) error {
+	return ms.messageRepository.SetThreadLocked(threadID, locked)
+}
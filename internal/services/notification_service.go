@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/notify"
+	"marketplace-api/internal/repository"
+	"slices"
+	"time"
+)
+
+// notificationSendTimeout bounds sending an email or an SMS, which runs in
+// the outbox relay and would otherwise hold back every other event.
+const notificationSendTimeout = 10 * time.Second
+
+type NotificationService struct {
+	notificationRepository *repository.NotificationRepository
+	userRepository         *repository.UserRepository
+	distributorRepository  *repository.DistributorRepository
+	email                  notify.EmailSender
+	sms                    notify.SMSSender
+	logger                 *logrus.Logger
+}
+
+func NewNotificationService(notificationRepository *repository.NotificationRepository, userRepository *repository.UserRepository, distributorRepository *repository.DistributorRepository, email notify.EmailSender, sms notify.SMSSender, logger *logrus.Logger) *NotificationService {
+	return &NotificationService{notificationRepository: notificationRepository, userRepository: userRepository, distributorRepository: distributorRepository, email: email, sms: sms, logger: logger}
+}
+
+func (ns *NotificationService) GetNotifications(userID int64, unreadOnly bool, filters models.Filters) ([]models.Notification, models.Metadata, error) {
+	return ns.notificationRepository.GetNotifications(userID, unreadOnly, filters)
+}
+
+func (ns *NotificationService) CountUnread(userID int64) (int64, error) {
+	return ns.notificationRepository.CountUnread(userID)
+}
+
+func (ns *NotificationService) MarkRead(userID, notificationID int64) error {
+	return ns.notificationRepository.MarkRead(userID, notificationID, time.Now())
+}
+
+func (ns *NotificationService) MarkAllRead(userID int64) (int64, error) {
+	return ns.notificationRepository.MarkAllRead(userID, time.Now())
+}
+
+// GetPreferences returns the preference of the user for every event of the
+// role, filling in the defaults.
+func (ns *NotificationService) GetPreferences(userID int64, role string) ([]models.NotificationPreference, error) {
+	stored, err := ns.notificationRepository.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	preferences := make([]models.NotificationPreference, 0, len(models.NotificationEvents[role]))
+	for _, event := range models.NotificationEvents[role] {
+		preference := models.DefaultNotificationPreference(userID, event)
+		for _, p := range stored {
+			if p.Event == event {
+				preference = p
+			}
+		}
+		preferences = append(preferences, preference)
+	}
+	return preferences, nil
+}
+
+func (ns *NotificationService) SavePreferences(userID int64, preferences []models.NotificationPreference) error {
+	for i := range preferences {
+		preferences[i].UserID = userID
+	}
+	return ns.notificationRepository.SavePreferences(preferences)
+}
+
+// HandleEvent notifies the store and the distributor of the event through the
+// channels they chose. A user is notified about an event only once, so events
+// relayed again are ignored.
+func (ns *NotificationService) HandleEvent(event events.Event) error {
+	if event.ID == 0 {
+		return nil
+	}
+	for _, role := range []string{models.RoleDistributor, models.RoleStore} {
+		if !slices.Contains(models.NotificationEvents[role], event.Name) {
+			continue
+		}
+		userID := event.StoreID
+		if role == models.RoleDistributor {
+			userID = event.DistributorID
+		}
+		if userID == 0 || userID == event.ActorID {
+			continue
+		}
+		if err := ns.notify(event, role, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ns *NotificationService) notify(event events.Event, role string, userID int64) error {
+	preference, err := ns.notificationRepository.GetPreference(userID, event.Name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		defaults := models.DefaultNotificationPreference(userID, event.Name)
+		preference, err = &defaults, nil
+	}
+	if err != nil {
+		return err
+	}
+	if !preference.Enabled() {
+		return nil
+	}
+	title, body, ok, err := notificationMessage(event)
+	if err != nil || !ok {
+		return err
+	}
+	notification := &models.Notification{
+		UserID:        userID,
+		EventID:       event.ID,
+		Event:         event.Name,
+		AggregateType: event.AggregateType,
+		AggregateID:   event.AggregateID,
+		Title:         title,
+		Body:          body,
+		InApp:         preference.InApp,
+	}
+	created, err := ns.notificationRepository.CreateNotification(notification)
+	if err != nil || !created {
+		return err
+	}
+
+	// Email and SMS failures are logged rather than retried: the notification
+	// is already stored, so the event would not be sent again anyway.
+	if preference.Email {
+		user, err := ns.userRepository.FindByID(userID)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), notificationSendTimeout)
+			err = ns.email.SendEmail(ctx, user.Email, title, body)
+			cancel()
+		}
+		if err != nil {
+			ns.logger.WithField("notification", notification.ID).Errorf("failed to send email: %s", err.Error())
+		}
+	}
+	if preference.SMS {
+		phone, err := ns.phoneNumber(role, userID)
+		if err == nil && phone != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), notificationSendTimeout)
+			err = ns.sms.SendSMS(ctx, phone, title+": "+body)
+			cancel()
+		}
+		if err != nil {
+			ns.logger.WithField("notification", notification.ID).Errorf("failed to send sms: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (ns *NotificationService) phoneNumber(role string, userID int64) (string, error) {
+	if role == models.RoleDistributor {
+		distributor, err := ns.distributorRepository.GetDistributorByID(userID)
+		if err != nil {
+			return "", err
+		}
+		return distributor.PhoneNumber, nil
+	}
+	store, err := ns.distributorRepository.GetStoreByID(userID)
+	if err != nil {
+		return "", err
+	}
+	return store.PhoneNumber, nil
+}
+
+// notificationMessage returns the title and body of the notification about the
+// event. Stage changes that cancel the order are left to the cancellation.
+func notificationMessage(event events.Event) (string, string, bool, error) {
+	switch event.Name {
+	case events.OrderCreated, events.OrderCancelled:
+		var order models.Order
+		if err := event.Decode(&order); err != nil {
+			return "", "", false, err
+		}
+		if event.Name == events.OrderCreated {
+			return "New order " + order.Number, fmt.Sprintf("%d × %s to %s", order.Quantity, order.Snapshot.ProductName, order.City), true, nil
+		}
+		return "Order " + order.Number + " cancelled", fmt.Sprintf("%d × %s was cancelled", order.Quantity, order.Snapshot.ProductName), true, nil
+	case events.OrderStageChanged:
+		var change events.OrderStageChange
+		if err := event.Decode(&change); err != nil {
+			return "", "", false, err
+		}
+		if change.Order == nil || change.To.Status == models.StageStatusError {
+			return "", "", false, nil
+		}
+		return "Order " + change.Order.Number + " updated", fmt.Sprintf("%s is now %s", change.Order.Snapshot.ProductName, change.To.Stage), true, nil
+	case events.ReviewCreated:
+		var review models.Review
+		if err := event.Decode(&review); err != nil {
+			return "", "", false, err
+		}
+		return "New review", fmt.Sprintf("%d/5: %s", review.Rating, review.Text), true, nil
+	case events.MessageCreated:
+		var message models.Message
+		if err := event.Decode(&message); err != nil {
+			return "", "", false, err
+		}
+		body := message.Body
+		if len([]rune(body)) > 140 {
+			body = string([]rune(body)[:140]) + "…"
+		}
+		if body == "" {
+			body = fmt.Sprintf("%d attachment(s)", len(message.Attachments))
+		}
+		return "New message", body, true, nil
+	case events.ProductLowStock:
+		var product models.Product
+		if err := event.Decode(&product); err != nil {
+			return "", "", false, err
+		}
+		return fmt.Sprintf("Low stock: %s", product.ProductName), fmt.Sprintf("%d left, threshold %d", product.Stock, product.LowStockThreshold), true, nil
+	}
+	return "", "", false, nil
+}
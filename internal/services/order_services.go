@@ -1,88 +1,406 @@
 package services
 
 import (
+	"cmp"
 	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
 	"marketplace-api/internal/models"
 	"marketplace-api/internal/repository"
 	"math"
+	"slices"
+	"strings"
 	"time"
 )
 
 type OrderService struct {
-	orderRepository   *repository.OrderRepository
-	productRepository *repository.ProductRepository
+	orderRepository       *repository.OrderRepository
+	productRepository     *repository.ProductRepository
+	distributorRepository *repository.DistributorRepository
+	deliveryRepository    *repository.DeliveryRepository
+	inventoryRepository   *repository.InventoryRepository
+	invoiceService        *InvoiceService
+	creditService         *CreditService
+	payoutService         *PayoutService
+	promotionService      *PromotionService
+	outbox                *events.Outbox
+	// tx is set on the copies of the service made by transaction.
+	tx *gorm.DB
 }
 
-func NewOrderService(orderRepository *repository.OrderRepository, productRepository *repository.ProductRepository) *OrderService {
-	return &OrderService{orderRepository: orderRepository, productRepository: productRepository}
+func NewOrderService(orderRepository *repository.OrderRepository, productRepository *repository.ProductRepository, distributorRepository *repository.DistributorRepository, deliveryRepository *repository.DeliveryRepository, inventoryRepository *repository.InventoryRepository, invoiceService *InvoiceService, creditService *CreditService, payoutService *PayoutService, promotionService *PromotionService, outbox *events.Outbox) *OrderService {
+	return &OrderService{orderRepository: orderRepository, productRepository: productRepository, distributorRepository: distributorRepository, deliveryRepository: deliveryRepository, inventoryRepository: inventoryRepository, invoiceService: invoiceService, creditService: creditService, payoutService: payoutService, promotionService: promotionService, outbox: outbox}
 }
 
-func (os *OrderService) CreatOrder(cart *models.Cart, city, address string) error {
+// transaction runs fn with a copy of the service whose repositories share one
+// database transaction, so the changes and the events they record are
+// committed together.
+func (os *OrderService) transaction(fn func(txs *OrderService) error) error {
+	if os.tx != nil {
+		return fn(os)
+	}
+	return os.outbox.Transaction(func(tx *gorm.DB) error {
+		txs := *os
+		txs.orderRepository = os.orderRepository.WithTx(tx)
+		txs.productRepository = os.productRepository.WithTx(tx)
+		txs.deliveryRepository = os.deliveryRepository.WithTx(tx)
+		txs.inventoryRepository = os.inventoryRepository.WithTx(tx)
+		txs.tx = tx
+		return fn(&txs)
+	})
+}
+
+// record stores events in the outbox of the current transaction.
+func (os *OrderService) record(evts ...events.Event) error {
+	return os.outbox.Record(os.tx, evts...)
+}
+
+func (os *OrderService) CreatOrder(cart *models.Cart, address *models.StoreAddress, deliverySlots []models.DeliverySlotChoice) error {
+	warehouses := make(map[int64][]models.Warehouse)
 	for _, cartItem := range cart.Items {
 		product, err := os.productRepository.GetProductByID(cartItem.ProductID)
 		if err != nil {
 			return err
 		}
-		if product.Stock < cartItem.Quantity {
+		serving, ok := warehouses[product.DistributorID]
+		if !ok {
+			serving, err = os.servingWarehouses(product.DistributorID, address.City)
+			if err != nil {
+				return err
+			}
+			warehouses[product.DistributorID] = serving
+		}
+		available, err := os.inventoryRepository.GetAvailable(product.ID, serving)
+		if err != nil {
+			return err
+		}
+		if available < cartItem.Quantity && !product.AllowBackorder {
 			return errors.New("not enough quantity in stock for product " + product.ProductName)
 		}
 	}
-	for _, cartItem := range cart.Items {
-		product, err := os.productRepository.GetProductByID(cartItem.ProductID)
+	storeEmail, err := os.productRepository.GetEmail(cart.StoreID)
+	if err != nil {
+		return err
+	}
+	return os.transaction(func(txs *OrderService) error {
+		windows, err := txs.reserveDeliveryWindows(cart, address.City, deliverySlots)
 		if err != nil {
 			return err
 		}
-		product.Stock = product.Stock - cartItem.Quantity
-		err = os.productRepository.UpdateProduct(product)
+		// Promotions are applied again for the delivery city; the ones used
+		// up since the cart was shown no longer apply.
+		if err := txs.promotionService.applyPromotionsTx(txs.tx, cart, address.City, time.Now()); err != nil {
+			return err
+		}
+		// Each distributor bills its lines of the checkout on one invoice,
+		// checked against the store's credit limit with the distributor.
+		var distributorIDs []int64
+		totals := make(map[int64]float64)
+		for _, cartItem := range cart.Items {
+			if _, ok := totals[cartItem.Product.DistributorID]; !ok {
+				distributorIDs = append(distributorIDs, cartItem.Product.DistributorID)
+			}
+			totals[cartItem.Product.DistributorID] += cartItem.GrossAmount
+		}
+		creditHolds := make(map[int64]bool)
+		for _, distributorID := range distributorIDs {
+			hold, err := txs.creditService.checkCredit(txs.tx, distributorID, cart.StoreID, totals[distributorID])
+			if err != nil {
+				return err
+			}
+			creditHolds[distributorID] = hold
+		}
+		now := time.Now()
+		numbers, err := txs.reserveOrderNumbers(cart, now.Year())
 		if err != nil {
 			return err
 		}
+		var orders []*models.Order
+		lines := make(map[int64][]*models.Order)
+		for _, cartItem := range cart.Items {
+			distributorID := cartItem.Product.DistributorID
+			number := models.OrderNumber(distributorID, now.Year(), numbers[distributorID])
+			numbers[distributorID]++
+			order, err := txs.createOrderLine(cart, cartItem, number, now, address, storeEmail, windows, warehouses[distributorID], creditHolds[distributorID])
+			if err != nil {
+				return err
+			}
+			lines[distributorID] = append(lines[distributorID], order)
+			orders = append(orders, order)
+		}
+		for _, distributorID := range distributorIDs {
+			if _, err := txs.invoiceService.issue(txs.tx, lines[distributorID]); err != nil {
+				return err
+			}
+		}
+		for _, order := range orders {
+			if err := txs.record(events.NewOrderEvent(events.OrderCreated, order, order)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// reserveOrderNumbers takes the numbers of the checkout's lines in the year and
+// returns the first number of each distributor. Sequences are locked in
+// distributor order, so concurrent checkouts with the same distributors do not
+// deadlock. It must run inside a transaction.
+func (os *OrderService) reserveOrderNumbers(cart *models.Cart, year int) (map[int64]int64, error) {
+	counts := make(map[int64]int64)
+	var distributorIDs []int64
+	for _, cartItem := range cart.Items {
+		if counts[cartItem.Product.DistributorID] == 0 {
+			distributorIDs = append(distributorIDs, cartItem.Product.DistributorID)
+		}
+		counts[cartItem.Product.DistributorID]++
+	}
+	slices.Sort(distributorIDs)
+	numbers := make(map[int64]int64, len(distributorIDs))
+	for _, distributorID := range distributorIDs {
+		first, err := os.orderRepository.NextOrderNumbers(distributorID, year, counts[distributorID])
+		if err != nil {
+			return nil, err
+		}
+		numbers[distributorID] = first
 	}
+	return numbers, nil
+}
 
-	storeEmail, err := os.productRepository.GetEmail(cart.StoreID)
+// createOrderLine creates the order for a cart item and reserves its stock. A
+// line that cannot be reserved waits in the backordered stage if the product
+// allows backorders. The promotion and tax of the item must be applied. It must
+// run inside a transaction.
+func (os *OrderService) createOrderLine(cart *models.Cart, cartItem models.CartItem, number string, now time.Time, address *models.StoreAddress, storeEmail string, windows map[int64]*models.DeliveryWindow, warehouses []models.Warehouse, creditHold bool) (*models.Order, error) {
+	distributorEmail, err := os.productRepository.GetEmail(cartItem.Product.DistributorID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	productID := cartItem.ProductID
+	order := &models.Order{
+		Number:           number,
+		StoreID:          cart.StoreID,
+		ProductID:        &productID,
+		Product:          cartItem.Product,
+		Snapshot:         models.NewProductSnapshot(&cartItem.Product),
+		Quantity:         cartItem.Quantity,
+		TotalPrice:       cartItem.GrossAmount,
+		TaxRate:          cartItem.TaxRate,
+		NetAmount:        cartItem.NetAmount,
+		TaxAmount:        cartItem.TaxAmount,
+		LineDiscount:     cartItem.LineDiscount,
+		Timestamp:        now,
+		Distributor:      cartItem.Product.Distributor,
+		DistributorID:    cartItem.Product.DistributorID,
+		Status:           models.OrderStatusActive,
+		AddressID:        &address.ID,
+		AddressLabel:     address.Label,
+		City:             address.City,
+		Address:          address.Address,
+		ContactName:      address.ContactName,
+		ContactPhone:     address.ContactPhone,
+		StoreEmail:       storeEmail,
+		DistributorEmail: distributorEmail,
+		CreditHold:       creditHold,
+	}
+	stage := &models.Stage{
+		Stage:  models.StageNew,
+		Status: models.StageSuccess,
+	}
+	if window, ok := windows[cartItem.Product.DistributorID]; ok {
+		order.DeliverySlotID = &window.SlotID
+		order.DeliveryStart = &window.Start
+		order.DeliveryEnd = &window.End
+	}
+	err = os.orderRepository.CreateOrderStage(stage)
+	if err != nil {
+		return nil, err
+	}
+	order.Stage = *stage
+	order.StageID = stage.ID
+	err = os.orderRepository.CreateOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	watch := watchStock(os.tx, os.outbox, productID)
+	err = os.inventoryRepository.Reserve(order, productID, warehouses)
+	if err == nil {
+		return order, watch.done()
+	}
+	if !errors.Is(err, repository.ErrInsufficientStock) {
+		return nil, err
+	}
+	if !cartItem.Product.AllowBackorder {
+		return nil, errors.New("not enough quantity in stock for product " + cartItem.Product.ProductName)
+	}
+	stage.Stage = models.StageBackordered
+	err = os.orderRepository.UpdateOrderStage(stage)
+	if err != nil {
+		return nil, err
+	}
+	order.Stage = *stage
+	order.ExpectedAt = cartItem.Product.BackorderAvailableAt
+	err = os.orderRepository.UpdateOrderStatus(order)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// servingWarehouses returns the distributor's warehouses that deliver to the
+// city in allocation order.
+func (os *OrderService) servingWarehouses(distributorID int64, city string) ([]models.Warehouse, error) {
+	warehouses, err := os.inventoryRepository.GetWarehouses(distributorID)
+	if err != nil {
+		return nil, err
+	}
+	return models.ServingWarehouses(warehouses, city), nil
+}
+
+// reserveDeliveryWindows books the delivery slots chosen at checkout, at most
+// one per distributor in the cart, and returns the windows by distributor ID.
+// Slots are booked in ID order, so concurrent checkouts do not deadlock. It
+// must run inside the transaction of the checkout.
+func (os *OrderService) reserveDeliveryWindows(cart *models.Cart, city string, choices []models.DeliverySlotChoice) (map[int64]*models.DeliveryWindow, error) {
+	distributors := make(map[int64]bool)
 	for _, cartItem := range cart.Items {
-		distributorEmail, err := os.productRepository.GetEmail(cartItem.Product.DistributorID)
+		distributors[cartItem.Product.DistributorID] = true
+	}
+	choices = slices.Clone(choices)
+	slices.SortStableFunc(choices, func(a, b models.DeliverySlotChoice) int {
+		return cmp.Compare(a.SlotID, b.SlotID)
+	})
+	windows := make(map[int64]*models.DeliveryWindow)
+	for _, choice := range choices {
+		window, err := os.reserveDeliveryWindow(choice, city, distributors, windows)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		order := &models.Order{
-			StoreID:          cart.StoreID,
-			ProductID:        cartItem.ProductID,
-			Product:          cartItem.Product,
-			Quantity:         cartItem.Quantity,
-			TotalPrice:       float64(cartItem.Quantity) * cartItem.Product.Price,
-			Timestamp:        time.Now(),
-			Distributor:      cartItem.Product.Distributor,
-			DistributorID:    cartItem.Product.DistributorID,
-			Status:           models.OrderStatusActive,
-			City:             city,
-			Address:          address,
-			StoreEmail:       storeEmail,
-			DistributorEmail: distributorEmail,
-		}
-		stage := &models.Stage{
-			Stage:  models.StageNew,
-			Status: models.StageSuccess,
-		}
-		err = os.orderRepository.CreateOrderStage(stage)
-		order.Stage = *stage
-		order.StageID = stage.ID
-		err = os.orderRepository.CreateOrder(order)
-		if err != nil {
-			return err
+		windows[window.DistributorID] = window
+	}
+	return windows, nil
+}
+
+func (os *OrderService) reserveDeliveryWindow(choice models.DeliverySlotChoice, city string, distributors map[int64]bool, windows map[int64]*models.DeliveryWindow) (*models.DeliveryWindow, error) {
+	slot, err := os.deliveryRepository.GetSlotByID(choice.SlotID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("delivery slot not found")
 		}
+		return nil, err
 	}
-	return nil
+	if !slot.Active || !distributors[slot.DistributorID] {
+		return nil, errors.New("delivery slot is not available for this order")
+	}
+	if _, ok := windows[slot.DistributorID]; ok {
+		return nil, errors.New("only one delivery slot can be chosen per distributor")
+	}
+	if !strings.EqualFold(slot.City, city) {
+		return nil, errors.New("delivery slot does not serve city " + city)
+	}
+	date, err := time.ParseInLocation(models.DeliveryDateLayout, choice.Date, time.Local)
+	if err != nil {
+		return nil, errors.New("invalid delivery date")
+	}
+	if date.Weekday() != slot.Weekday {
+		return nil, errors.New("delivery slot is not available on " + choice.Date)
+	}
+	window, err := slot.Window(date)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(slot.Cutoff(window)) {
+		return nil, errors.New("delivery slot cut-off time has passed")
+	}
+	if err := os.deliveryRepository.ReserveSlot(slot, date); err != nil {
+		return nil, err
+	}
+	return window, nil
 }
 
+// ChangeOrderStatus moves the order to its next stage. Once the order is
+// delivered its reserved stock is sold and it is added to the distributor's
+// payout; once it fails or is cancelled the reservation and the delivery
+// window are released.
 func (os *OrderService) ChangeOrderStatus(order models.Order, stageStatus string) error {
+	return os.transaction(func(txs *OrderService) error {
+		return txs.changeOrderStatus(order, stageStatus)
+	})
+}
+
+func (os *OrderService) changeOrderStatus(order models.Order, stageStatus string) error {
+	from, err := os.orderRepository.GetStageByID(order.StageID)
+	if err != nil {
+		return err
+	}
+	err = os.changeOrderStage(order, stageStatus)
+	if err != nil {
+		return err
+	}
 	stage, err := os.orderRepository.GetStageByID(order.StageID)
 	if err != nil {
 		return err
 	}
+	switch {
+	case stage.Status == models.StageStatusError:
+		if order.DeliverySlotID != nil && order.DeliveryStart != nil {
+			err = os.releaseOrderDeliveryWindow(order)
+			if err != nil {
+				return err
+			}
+		}
+		if order.InvoiceID != nil {
+			err = os.invoiceService.recalculate(os.tx, *order.InvoiceID)
+			if err != nil {
+				return err
+			}
+		}
+		if order.ProductID != nil {
+			err = os.inventoryRepository.Settle(&order, *order.ProductID, models.MovementCancellation)
+		}
+	case stage.Stage == models.StageSuccess:
+		if order.ProductID != nil {
+			err = os.inventoryRepository.Settle(&order, *order.ProductID, models.MovementSale)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.payoutService.recordSale(os.tx, order)
+	}
+	if err != nil {
+		return err
+	}
+	return os.recordStageChange(order, *from, *stage)
+}
+
+// recordStageChange records the stage change of the order, and its
+// cancellation when the order was closed with an error.
+func (os *OrderService) recordStageChange(order models.Order, from, to models.Stage) error {
+	if from.Stage == to.Stage && from.Status == to.Status {
+		return nil
+	}
+	order.Stage = to
+	if to.Status == models.StageStatusError {
+		order.Status = models.OrderStatusClosed
+	}
+	evts := []events.Event{
+		events.NewOrderEvent(events.OrderStageChanged, &order, events.OrderStageChange{Order: &order, From: from, To: to}),
+	}
+	if to.Status == models.StageStatusError {
+		evts = append(evts, events.NewOrderEvent(events.OrderCancelled, &order, &order))
+	}
+	return os.record(evts...)
+}
+
+func (os *OrderService) changeOrderStage(order models.Order, stageStatus string) error {
+	stage, err := os.orderRepository.GetStageByID(order.StageID)
+	if err != nil {
+		return err
+	}
+	if stage.Stage == models.StageBackordered && stageStatus != models.StageStatusError {
+		return errors.New("backordered order is released automatically when stock is replenished")
+	}
 	switch stage.Status {
 	case models.StageStatusSuccess:
 		if stage.Stage == models.StageProcessing || stage.Stage == models.StageShipped {
@@ -148,6 +466,116 @@ func (os *OrderService) ChangeOrderStatus(order models.Order, stageStatus string
 	return nil
 }
 
+// CancelOrder closes the order and returns its reserved stock. Backordered
+// orders never reserved stock, so nothing is returned for them.
+func (os *OrderService) CancelOrder(order models.Order) error {
+	return os.ChangeOrderStatus(order, models.StageStatusError)
+}
+
+// releaseOrderDeliveryWindow frees the delivery booking once no active order of
+// the same checkout uses it anymore. A checkout books one window per
+// distributor, and the distributor's lines of the checkout share its invoice;
+// orders placed before invoices existed are matched by store and window.
+func (os *OrderService) releaseOrderDeliveryWindow(order models.Order) error {
+	var count int64
+	var err error
+	if order.InvoiceID != nil {
+		count, err = os.orderRepository.CountActiveOrdersInDeliveryBooking(*order.InvoiceID, *order.DeliverySlotID, *order.DeliveryStart)
+	} else {
+		count, err = os.orderRepository.CountActiveOrdersInDeliveryWindow(order.StoreID, *order.DeliverySlotID, *order.DeliveryStart)
+	}
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return os.deliveryRepository.ReleaseSlot(*order.DeliverySlotID, order.DeliveryStart.In(time.Local))
+}
+
+// ExpireUnconfirmedOrders cancels orders that have been new for longer than
+// their distributor's confirmation SLA and returns the orders it cancelled.
+// Backorders count from when their stock arrived, not from checkout.
+func (os *OrderService) ExpireUnconfirmedOrders(defaultSLA time.Duration, now time.Time) ([]models.Order, error) {
+	orders, err := os.orderRepository.GetActiveOrdersByStage(models.StageNew)
+	if err != nil {
+		return nil, err
+	}
+	slas := make(map[int64]time.Duration)
+	var expired []models.Order
+	for _, order := range orders {
+		sla, ok := slas[order.DistributorID]
+		if !ok {
+			sla = defaultSLA
+			distributor, err := os.distributorRepository.GetDistributorByID(order.DistributorID)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return expired, err
+			}
+			if distributor != nil && distributor.ConfirmationSLAHours > 0 {
+				sla = time.Duration(distributor.ConfirmationSLAHours) * time.Hour
+			}
+			slas[order.DistributorID] = sla
+		}
+		if sla <= 0 || now.Sub(order.Stage.ChangedAt) < sla {
+			continue
+		}
+		if err := os.CancelOrder(order); err != nil {
+			return expired, err
+		}
+		order.Status = models.OrderStatusClosed
+		expired = append(expired, order)
+	}
+	return expired, nil
+}
+
+// ReleaseBackorders reserves stock for backordered orders of the product,
+// oldest first, and moves the ones it could reserve to the new stage.
+func (os *OrderService) ReleaseBackorders(productID int64) error {
+	orders, err := os.orderRepository.GetBackorderedOrders(productID)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		err = os.transaction(func(txs *OrderService) error {
+			return txs.releaseBackorder(order, productID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (os *OrderService) releaseBackorder(order models.Order, productID int64) error {
+	warehouses, err := os.servingWarehouses(order.DistributorID, order.City)
+	if err != nil {
+		return err
+	}
+	watch := watchStock(os.tx, os.outbox, productID)
+	err = os.inventoryRepository.Reserve(&order, productID, warehouses)
+	if errors.Is(err, repository.ErrInsufficientStock) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := watch.done(); err != nil {
+		return err
+	}
+	stage, err := os.orderRepository.GetStageByID(order.StageID)
+	if err != nil {
+		return err
+	}
+	from := *stage
+	stage.Stage = models.StageNew
+	stage.Status = models.StageStatusSuccess
+	err = os.orderRepository.UpdateOrderStage(stage)
+	if err != nil {
+		return err
+	}
+	return os.recordStageChange(order, from, *stage)
+}
+
 func (os *OrderService) GetOrderByID(userID, orderID int64, role string) (*models.Order, error) {
 	order, err := os.orderRepository.GetOrderByID(userID, orderID, role)
 	if err != nil {
@@ -159,11 +587,17 @@ func (os *OrderService) GetOrderByID(userID, orderID int64, role string) (*model
 	}
 	order.Stage = *stage
 	order.TotalPrice = math.Round(order.TotalPrice*100) / 100
-	return order, nil
+	orders := []models.Order{*order}
+	if err := os.invoiceService.AttachPaymentStatus(orders); err != nil {
+		return nil, err
+	}
+	return &orders[0], nil
 }
 
-func (os *OrderService) GetOrders(storeID int64, role string) ([]models.Order, error) {
-	orders, err := os.orderRepository.GetOrders(storeID, role)
+// GetOrders returns the orders of the store or the distributor, only those
+// with the payment status and a number containing number if they are given.
+func (os *OrderService) GetOrders(storeID int64, role string, paymentStatus string, number string) ([]models.Order, error) {
+	orders, err := os.orderRepository.GetOrders(storeID, role, paymentStatus, number)
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +608,7 @@ func (os *OrderService) GetOrders(storeID int64, role string) ([]models.Order, e
 		}
 		orders[i].Stage = *stage
 	}
-	return orders, nil
+	return orders, os.invoiceService.AttachPaymentStatus(orders)
 }
 
 func (os *OrderService) GetSuccessOrders(storeID int64, role string) ([]models.Order, error) {
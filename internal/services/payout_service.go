@@ -0,0 +1,279 @@
+package services
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrCommissionRuleExists = errors.New("an active rule for this distributor and category already exists")
+	ErrReturnOrder          = errors.New("order must be a delivered order of the product")
+	ErrStatementPaid        = errors.New("statement is already paid")
+)
+
+type PayoutService struct {
+	payoutRepository *repository.PayoutRepository
+	orderRepository  *repository.OrderRepository
+	outbox           *events.Outbox
+	period           string
+}
+
+func NewPayoutService(payoutRepository *repository.PayoutRepository, orderRepository *repository.OrderRepository, outbox *events.Outbox, period string) *PayoutService {
+	return &PayoutService{payoutRepository: payoutRepository, orderRepository: orderRepository, outbox: outbox, period: period}
+}
+
+func (ps *PayoutService) GetCommissionRules() ([]models.CommissionRule, error) {
+	return ps.payoutRepository.GetCommissionRules()
+}
+
+func (ps *PayoutService) GetCommissionRuleByID(ruleID int64) (*models.CommissionRule, error) {
+	return ps.payoutRepository.GetCommissionRuleByID(ruleID)
+}
+
+// SaveCommissionRule creates or updates the rule. Changed rules apply to
+// orders completed afterwards; lines already recorded keep their fee.
+func (ps *PayoutService) SaveCommissionRule(rule *models.CommissionRule) error {
+	if rule.Active {
+		exists, err := ps.payoutRepository.HasCommissionRule(rule)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrCommissionRuleExists
+		}
+	}
+	if rule.ID == 0 {
+		return ps.payoutRepository.CreateCommissionRule(rule)
+	}
+	return ps.payoutRepository.UpdateCommissionRule(rule)
+}
+
+func (ps *PayoutService) DeleteCommissionRule(ruleID int64) error {
+	return ps.payoutRepository.DeleteCommissionRule(ruleID)
+}
+
+// recordSale adds the completed order to the distributor's payout less the
+// commission of the matching rule. It must run inside a transaction.
+func (ps *PayoutService) recordSale(tx *gorm.DB, order models.Order) error {
+	payoutRepository := ps.payoutRepository.WithTx(tx)
+	rules, err := payoutRepository.GetCommissionRules()
+	if err != nil {
+		return err
+	}
+	amount := models.RoundMoney(order.TotalPrice)
+	line := &models.PayoutLine{
+		DistributorID: order.DistributorID,
+		Kind:          models.PayoutLineSale,
+		OrderID:       &order.ID,
+		Quantity:      order.Quantity,
+		Amount:        amount,
+		Note:          order.Snapshot.ProductName,
+	}
+	if rule := models.MatchCommissionRule(rules, order.DistributorID, order.Snapshot.Category); rule != nil {
+		line.RuleID = &rule.ID
+		line.Percent = rule.Percent
+		line.FixedFee = rule.FixedFee
+		line.Commission = rule.Fee(amount)
+	}
+	line.Net = models.RoundMoney(line.Amount - line.Commission)
+	_, err = payoutRepository.CreatePayoutLine(line)
+	return err
+}
+
+// recordReturn deducts returned units of a completed order from the
+// distributor's payout and refunds the percentage commission on them. The
+// fixed fee is kept, as the order was still handled. Units beyond the ones
+// ordered are not deducted again. It must run inside a transaction.
+func (ps *PayoutService) recordReturn(tx *gorm.DB, distributorID int64, movement *models.StockMovement) error {
+	payoutRepository := ps.payoutRepository.WithTx(tx)
+	order, err := ps.orderRepository.WithTx(tx).GetOrderByID(distributorID, *movement.OrderID, "distributor")
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReturnOrder
+		}
+		return err
+	}
+	if order.ProductID == nil || *order.ProductID != movement.ProductID {
+		return ErrReturnOrder
+	}
+	sale, err := payoutRepository.LockSaleLine(order.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReturnOrder
+		}
+		return err
+	}
+	returned, err := payoutRepository.GetReturnedQuantity(order.ID)
+	if err != nil {
+		return err
+	}
+	quantity := min(movement.OnHandDelta, sale.Quantity-returned)
+	if quantity <= 0 {
+		return nil
+	}
+	amount := models.RoundMoney(sale.Amount * float64(quantity) / float64(sale.Quantity))
+	line := &models.PayoutLine{
+		DistributorID: sale.DistributorID,
+		Kind:          models.PayoutLineReturn,
+		OrderID:       &order.ID,
+		Quantity:      quantity,
+		Amount:        -amount,
+		Commission:    -models.RoundMoney(min(amount*sale.Percent/100, sale.Commission)),
+		RuleID:        sale.RuleID,
+		Percent:       sale.Percent,
+		Note:          movement.Note,
+		CreatedBy:     movement.CreatedBy,
+	}
+	line.Net = models.RoundMoney(line.Amount - line.Commission)
+	_, err = payoutRepository.CreatePayoutLine(line)
+	return err
+}
+
+// AddAdjustment credits, or with a negative amount debits, the distributor's
+// next statement.
+func (ps *PayoutService) AddAdjustment(input *models.PayoutAdjustmentInput, adminID int64) (*models.PayoutLine, error) {
+	line := &models.PayoutLine{
+		DistributorID: input.DistributorID,
+		Kind:          models.PayoutLineAdjustment,
+		Amount:        input.Amount,
+		Net:           input.Amount,
+		Note:          input.Note,
+		CreatedBy:     adminID,
+	}
+	if _, err := ps.payoutRepository.CreatePayoutLine(line); err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+func (ps *PayoutService) GetPendingLines(distributorID int64) ([]models.PayoutLine, *models.Statement, error) {
+	lines, err := ps.payoutRepository.GetPendingLines(distributorID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The totals preview the next statement.
+	next := &models.Statement{DistributorID: distributorID}
+	for _, line := range lines {
+		next.Total(line)
+	}
+	return lines, next, nil
+}
+
+// IssueStatements closes the last payout period: every distributor with lines
+// from before the current period gets a statement of them. It returns the
+// number of statements issued.
+func (ps *PayoutService) IssueStatements(now time.Time) (int, error) {
+	periodEnd := models.PayoutPeriodStart(ps.period, now)
+	periodStart := models.PayoutPeriodStart(ps.period, periodEnd.Add(-time.Nanosecond))
+	distributorIDs, err := ps.payoutRepository.GetDistributorsWithPendingLines(periodEnd)
+	if err != nil {
+		return 0, err
+	}
+	issued := 0
+	for _, distributorID := range distributorIDs {
+		err := ps.outbox.Transaction(func(tx *gorm.DB) error {
+			payoutRepository := ps.payoutRepository.WithTx(tx)
+			lines, err := payoutRepository.LockPendingLines(distributorID, periodEnd)
+			if err != nil || len(lines) == 0 {
+				return err
+			}
+			statement := &models.Statement{
+				DistributorID: distributorID,
+				PeriodStart:   periodStart,
+				PeriodEnd:     periodEnd,
+				Status:        models.StatementStatusIssued,
+			}
+			// Lines left over from earlier periods widen the statement.
+			if lines[0].CreatedAt.Before(periodStart) {
+				statement.PeriodStart = lines[0].CreatedAt
+			}
+			lineIDs := make([]int64, len(lines))
+			for i, line := range lines {
+				statement.Total(line)
+				lineIDs[i] = line.ID
+			}
+			if err := payoutRepository.CreateStatement(statement); err != nil {
+				return err
+			}
+			return payoutRepository.AssignLines(statement.ID, lineIDs)
+		})
+		if err != nil {
+			return issued, err
+		}
+		issued++
+	}
+	return issued, nil
+}
+
+func (ps *PayoutService) GetStatements(distributorID int64, status string, filters models.Filters) ([]models.Statement, models.Metadata, error) {
+	return ps.payoutRepository.GetStatements(distributorID, status, filters)
+}
+
+func (ps *PayoutService) GetStatement(distributorID, statementID int64) (*models.Statement, error) {
+	return ps.payoutRepository.GetStatement(distributorID, statementID)
+}
+
+// MarkStatementPaid records that the net payout of the statement was
+// transferred to the distributor.
+func (ps *PayoutService) MarkStatementPaid(statementID, adminID int64, reference string) (*models.Statement, error) {
+	err := ps.outbox.Transaction(func(tx *gorm.DB) error {
+		payoutRepository := ps.payoutRepository.WithTx(tx)
+		statement, err := payoutRepository.LockStatement(statementID)
+		if err != nil {
+			return err
+		}
+		if statement.Status == models.StatementStatusPaid {
+			return ErrStatementPaid
+		}
+		now := time.Now()
+		statement.Status = models.StatementStatusPaid
+		statement.PaidAt = &now
+		statement.PaidBy = &adminID
+		statement.Reference = reference
+		return payoutRepository.MarkStatementPaid(statement)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ps.GetStatement(0, statementID)
+}
+
+// ExportStatement returns the lines of the statement as spreadsheet records
+// under models.StatementColumns, followed by a row of totals.
+func (ps *PayoutService) ExportStatement(statement *models.Statement) [][]string {
+	money := func(amount float64) string {
+		return strconv.FormatFloat(amount, 'f', 2, 64)
+	}
+	records := [][]string{models.StatementColumns}
+	for _, line := range statement.Lines {
+		orderID := ""
+		if line.OrderID != nil {
+			orderID = strconv.FormatInt(*line.OrderID, 10)
+		}
+		records = append(records, []string{
+			strconv.FormatInt(statement.ID, 10),
+			strconv.FormatInt(line.ID, 10),
+			line.CreatedAt.Format(time.RFC3339),
+			line.Kind,
+			orderID,
+			strconv.FormatInt(line.Quantity, 10),
+			money(line.Amount),
+			strconv.FormatFloat(line.Percent, 'f', -1, 64),
+			money(line.FixedFee),
+			money(line.Commission),
+			money(line.Net),
+			line.Note,
+		})
+	}
+	records = append(records, []string{
+		strconv.FormatInt(statement.ID, 10), "", statement.PeriodEnd.Format(time.RFC3339), "total", "", "",
+		money(statement.GrossSales + statement.Returns + statement.Adjustments), "", "",
+		money(statement.Commission), money(statement.NetPayout), statement.Status,
+	})
+	return records
+}
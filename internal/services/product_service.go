@@ -3,29 +3,117 @@
 package services
 
 import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
 	"marketplace-api/internal/models"
+	"marketplace-api/internal/moderation"
 	"marketplace-api/internal/repository"
+	"slices"
+	"time"
+)
+
+var (
+	ErrReviewOrder       = errors.New("order not found")
+	ErrReviewNotReceived = errors.New("only delivered orders can be reviewed")
+	ErrReviewExists      = errors.New("order line has already been reviewed")
+	ErrReviewProduct     = errors.New("product does not match the order")
+	ErrReviewDistributor = errors.New("distributor does not match the product")
+	ErrReviewLocked      = errors.New("review can no longer be edited")
+	ErrReviewUnpublished = errors.New("review is not published")
+	ErrReviewReported    = errors.New("review has already been reported")
+	ErrReplyRejected     = errors.New("reply contains abusive language or looks like spam")
 )
 
 type ProductService struct {
 	productRepository     *repository.ProductRepository
 	distributorRepository *repository.DistributorRepository
+	orderRepository       *repository.OrderRepository
+	ratingRepository      *repository.RatingRepository
+	outbox                *events.Outbox
+	imageService          *ImageService
+	reviewEditWindow      time.Duration
+	reviewFilter          *moderation.Filter
 }
 
-func NewProductService(productRepository *repository.ProductRepository, distributorRepository *repository.DistributorRepository) *ProductService {
-	return &ProductService{productRepository: productRepository, distributorRepository: distributorRepository}
+func NewProductService(productRepository *repository.ProductRepository, distributorRepository *repository.DistributorRepository, orderRepository *repository.OrderRepository, ratingRepository *repository.RatingRepository, outbox *events.Outbox, imageService *ImageService, reviewEditWindow time.Duration, reviewFilter *moderation.Filter) *ProductService {
+	return &ProductService{productRepository: productRepository, distributorRepository: distributorRepository, orderRepository: orderRepository, ratingRepository: ratingRepository, outbox: outbox, imageService: imageService, reviewEditWindow: reviewEditWindow, reviewFilter: reviewFilter}
 }
 
 func (ps *ProductService) CreateProduct(product *models.Product) error {
-	return ps.productRepository.CreateProduct(product)
+	return ps.outbox.Transaction(func(tx *gorm.DB) error {
+		if err := ps.productRepository.WithTx(tx).CreateProduct(product); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewProductEvent(events.ProductCreated, product))
+	})
 }
 
+// UpdateProduct saves the product and deletes the images it no longer uses.
 func (ps *ProductService) UpdateProduct(product *models.Product) error {
-	return ps.productRepository.UpdateProduct(product)
+	var dropped []string
+	err := ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		current, err := productRepository.GetProductByID(product.ID)
+		if err != nil {
+			return err
+		}
+		if err := productRepository.UpdateProduct(product); err != nil {
+			return err
+		}
+		updated, err := productRepository.GetProductByID(product.ID)
+		if err != nil {
+			return err
+		}
+		for _, url := range current.ImgURLs {
+			if !slices.Contains(updated.ImgURLs, url) {
+				dropped = append(dropped, url)
+			}
+		}
+		return ps.outbox.Record(tx, events.NewProductEvent(events.ProductUpdated, updated))
+	})
+	if err != nil {
+		return err
+	}
+	ps.imageService.DeleteImages(dropped)
+	return nil
 }
 
+// UpdatePrice sets the price of the product.
+func (ps *ProductService) UpdatePrice(productID int64, price float64) error {
+	return ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		if err := productRepository.UpdatePrice(productID, price); err != nil {
+			return err
+		}
+		product, err := productRepository.GetProductByID(productID)
+		if err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewProductEvent(events.ProductUpdated, product))
+	})
+}
+
+// DeleteProduct deletes the product and its images.
 func (ps *ProductService) DeleteProduct(productID int64) error {
-	return ps.productRepository.DeleteProduct(productID)
+	var product *models.Product
+	err := ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		var err error
+		product, err = productRepository.GetProductByID(productID)
+		if err != nil {
+			return err
+		}
+		if err := productRepository.DeleteProduct(productID); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewProductEvent(events.ProductDeleted, product))
+	})
+	if err != nil {
+		return err
+	}
+	ps.imageService.DeleteImages(product.ImgURLs)
+	return nil
 }
 
 func (ps *ProductService) GetProductByID(productID int64) (*models.Product, error) {
@@ -38,9 +126,30 @@ func (ps *ProductService) GetProductByID(productID int64) (*models.Product, erro
 		return nil, err
 	}
 	product.Distributor = *distributor
+	product.Rating, err = ps.ratingRepository.GetRatingSummary(models.RatingSubjectProduct, product.ID)
+	if err != nil {
+		return nil, err
+	}
 	return product, nil
 }
 
+// AttachOrderProduct loads the current product of the order, if it still exists.
+// Orders of deleted products keep only their snapshot.
+func (ps *ProductService) AttachOrderProduct(order *models.Order) error {
+	if order.ProductID == nil {
+		return nil
+	}
+	product, err := ps.GetProductByID(*order.ProductID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	order.Product = *product
+	return nil
+}
+
 func (ps *ProductService) GetProductsByDistributorID(productName string, filters models.Filters, distributorID int64) ([]*models.Product, models.Metadata, error) {
 	return ps.productRepository.GetProductsByDistributorID(productName, filters, distributorID)
 }
@@ -48,8 +157,130 @@ func (ps *ProductService) GetProducts(productName string, filters models.Filters
 	return ps.productRepository.GetProducts(productName, filters)
 }
 
+// NewReview builds the review of an order line the store received. The
+// product and distributor come from the order; IDs given in the input must
+// match them.
+func (ps *ProductService) NewReview(storeID int64, input *models.ReviewInput) (*models.Review, error) {
+	order, err := ps.orderRepository.GetOrderByID(storeID, input.OrderID, "store")
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReviewOrder
+		}
+		return nil, err
+	}
+	stage, err := ps.orderRepository.GetStageByID(order.StageID)
+	if err != nil {
+		return nil, err
+	}
+	if stage.Stage != models.StageSuccess {
+		return nil, ErrReviewNotReceived
+	}
+	if order.ProductID == nil || (input.ProductId != 0 && input.ProductId != *order.ProductID) {
+		return nil, ErrReviewProduct
+	}
+	product, err := ps.productRepository.GetProductByID(*order.ProductID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReviewProduct
+		}
+		return nil, err
+	}
+	if input.DistributorId != 0 && input.DistributorId != product.DistributorID {
+		return nil, ErrReviewDistributor
+	}
+	return &models.Review{
+		DistributorId:    product.DistributorID,
+		ProductId:        product.ID,
+		StoreId:          storeID,
+		OrderID:          &order.ID,
+		VerifiedPurchase: true,
+		Rating:           input.Rating,
+		Text:             input.Text,
+		Status:           models.ReviewStatusPublished,
+	}, nil
+}
+
+// CreatReview stores the review. Reviews the pre-filter catches are held for
+// moderation and announced once a moderator publishes them.
 func (ps *ProductService) CreatReview(review *models.Review) error {
-	return ps.productRepository.CreatReview(review)
+	if review.Status == "" {
+		review.Status = models.ReviewStatusPublished
+	}
+	ps.screenReview(review)
+	if review.Status == models.ReviewStatusPublished {
+		now := time.Now()
+		review.PublishedAt = &now
+	}
+	return ps.outbox.Transaction(func(tx *gorm.DB) error {
+		created, err := ps.productRepository.WithTx(tx).CreatReview(review)
+		if err != nil {
+			return err
+		}
+		if !created {
+			return ErrReviewExists
+		}
+		if review.Status != models.ReviewStatusPublished {
+			return nil
+		}
+		if err := addRating(ps.ratingRepository.WithTx(tx), review, 1); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewReviewEvent(events.ReviewCreated, review))
+	})
+}
+
+// screenReview holds a published review whose text the pre-filter catches.
+func (ps *ProductService) screenReview(review *models.Review) {
+	if review.Status != models.ReviewStatusPublished {
+		return
+	}
+	if reason, held := ps.reviewFilter.Check(review.Text); held {
+		review.Status = models.ReviewStatusHeld
+		review.ModerationReason = reason
+	}
+}
+
+// UpdateReview changes the rating and text of the store's review while it is
+// within the edit window.
+func (ps *ProductService) UpdateReview(storeID, reviewID int64, input *models.ReviewUpdateInput) (*models.Review, error) {
+	var review *models.Review
+	err := ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		var err error
+		review, err = productRepository.LockReview(reviewID)
+		if err != nil {
+			return err
+		}
+		if review.StoreId != storeID {
+			return gorm.ErrRecordNotFound
+		}
+		if time.Now().After(review.EditableUntil(ps.reviewEditWindow)) {
+			return ErrReviewLocked
+		}
+		ratingRepository := ps.ratingRepository.WithTx(tx)
+		if err := addRating(ratingRepository, review, -1); err != nil {
+			return err
+		}
+		review.Rating = input.Rating
+		review.Text = input.Text
+		if err := productRepository.UpdateReview(review); err != nil {
+			return err
+		}
+		// Edits can hold a published review, but never release a held one.
+		if ps.screenReview(review); review.Status == models.ReviewStatusHeld {
+			if err := productRepository.UpdateReviewStatus(review); err != nil {
+				return err
+			}
+		}
+		if err := addRating(ratingRepository, review, 1); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewReviewEvent(events.ReviewUpdated, review))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
 }
 func (ps *ProductService) GetReviewByStoreId(storeId int64) ([]models.Review, error) {
 	return ps.productRepository.GetReviews(storeId, "store")
@@ -62,8 +293,173 @@ func (ps *ProductService) GetReviewsByDistributorId(distributorId int64) ([]mode
 func (ps *ProductService) GetReviewsByProductId(productID int64) ([]models.Review, error) {
 	return ps.productRepository.GetReviews(productID, "product")
 }
-func (ps *ProductService) DeleteByReviewId(reviewId int64) error {
-	return ps.productRepository.DeleteByReviewId(reviewId)
+func (ps *ProductService) DeleteByReviewId(storeID, reviewId int64) error {
+	return ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		review, err := productRepository.LockReview(reviewId)
+		if err != nil {
+			return err
+		}
+		if review.StoreId != storeID {
+			return gorm.ErrRecordNotFound
+		}
+		if err := productRepository.DeleteByReviewId(storeID, reviewId); err != nil {
+			return err
+		}
+		if err := addRating(ps.ratingRepository.WithTx(tx), review, -1); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewReviewEvent(events.ReviewDeleted, review))
+	})
+}
+
+func (ps *ProductService) GetReviewByID(reviewID int64) (*models.Review, error) {
+	return ps.productRepository.GetReviewByID(reviewID)
+}
+
+func (ps *ProductService) GetReviewQueue(queue string, filters models.Filters) ([]models.Review, models.Metadata, error) {
+	return ps.productRepository.GetReviewQueue(queue, filters)
+}
+
+// ReplyToReview sets the distributor's public reply to a published review of
+// one of their products, replacing an earlier reply. An empty text removes
+// the reply.
+func (ps *ProductService) ReplyToReview(distributorID, reviewID int64, text string) (*models.Review, error) {
+	if _, rejected := ps.reviewFilter.Check(text); rejected {
+		return nil, ErrReplyRejected
+	}
+	var review *models.Review
+	err := ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		var err error
+		review, err = productRepository.LockReview(reviewID)
+		if err != nil {
+			return err
+		}
+		if review.DistributorId != distributorID {
+			return gorm.ErrRecordNotFound
+		}
+		if review.Status != models.ReviewStatusPublished {
+			return ErrReviewUnpublished
+		}
+		review.Reply = text
+		review.RepliedAt = nil
+		if text != "" {
+			now := time.Now()
+			review.RepliedAt = &now
+		}
+		if err := productRepository.UpdateReviewReply(review); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewReviewEvent(events.ReviewUpdated, review))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// ReportReview records a report of a published review. Stores can report any
+// review and distributors the reviews of their products. Enough open reports
+// hold the review for moderation.
+func (ps *ProductService) ReportReview(user models.User, reviewID int64, input *models.ReviewFlagInput) error {
+	return ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		review, err := productRepository.LockReview(reviewID)
+		if err != nil {
+			return err
+		}
+		if user.Role == models.RoleDistributor && review.DistributorId != user.ID {
+			return gorm.ErrRecordNotFound
+		}
+		if review.Status != models.ReviewStatusPublished {
+			return ErrReviewUnpublished
+		}
+		created, err := productRepository.CreateReviewFlag(&models.ReviewFlag{
+			ReviewID:   review.ID,
+			ReporterID: user.ID,
+			Reason:     input.Reason,
+			Comment:    input.Comment,
+		})
+		if err != nil {
+			return err
+		}
+		if !created {
+			return ErrReviewReported
+		}
+		flags, err := productRepository.CountOpenReviewFlags(review.ID)
+		if err != nil || flags < models.ReviewFlagThreshold {
+			return err
+		}
+		if err := addRating(ps.ratingRepository.WithTx(tx), review, -1); err != nil {
+			return err
+		}
+		review.Status = models.ReviewStatusHeld
+		review.ModerationReason = input.Reason
+		if err := productRepository.UpdateReviewStatus(review); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewReviewEvent(events.ReviewUpdated, review))
+	})
+}
+
+// HideReview hides the review from everyone but its store and resolves its
+// reports.
+func (ps *ProductService) HideReview(reviewID, adminID int64, reason string) error {
+	return ps.moderateReview(reviewID, adminID, models.ReviewStatusHidden, reason)
+}
+
+// RestoreReview publishes a held or hidden review and resolves its reports.
+func (ps *ProductService) RestoreReview(reviewID, adminID int64) error {
+	return ps.moderateReview(reviewID, adminID, models.ReviewStatusPublished, "")
+}
+
+func (ps *ProductService) moderateReview(reviewID, adminID int64, status, reason string) error {
+	return ps.outbox.Transaction(func(tx *gorm.DB) error {
+		productRepository := ps.productRepository.WithTx(tx)
+		review, err := productRepository.LockReview(reviewID)
+		if err != nil {
+			return err
+		}
+		ratingRepository := ps.ratingRepository.WithTx(tx)
+		if err := addRating(ratingRepository, review, -1); err != nil {
+			return err
+		}
+		now := time.Now()
+		// A review is announced the first time it is published.
+		event := events.ReviewUpdated
+		if status == models.ReviewStatusPublished && review.PublishedAt == nil {
+			event = events.ReviewCreated
+			review.PublishedAt = &now
+		}
+		review.Status = status
+		review.ModerationReason = reason
+		review.ModeratedBy = &adminID
+		review.ModeratedAt = &now
+		if err := productRepository.UpdateReviewStatus(review); err != nil {
+			return err
+		}
+		if err := productRepository.ResolveReviewFlags(review.ID, now); err != nil {
+			return err
+		}
+		if err := addRating(ratingRepository, review, 1); err != nil {
+			return err
+		}
+		return ps.outbox.Record(tx, events.NewReviewEvent(event, review))
+	})
+}
+
+// addRating adds a published review to the rating summaries of its product
+// and distributor, or removes it with a negative delta. Held and hidden
+// reviews are not counted.
+func addRating(ratingRepository *repository.RatingRepository, review *models.Review, delta int64) error {
+	if review.Status != models.ReviewStatusPublished {
+		return nil
+	}
+	if err := ratingRepository.AddRating(models.RatingSubjectProduct, review.ProductId, review.Rating, delta); err != nil {
+		return err
+	}
+	return ratingRepository.AddRating(models.RatingSubjectDistributor, review.DistributorId, review.Rating, delta)
 }
 
 // Implement filtering, sorting, and metadata functions as needed
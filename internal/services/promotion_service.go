@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"time"
+)
+
+var (
+	ErrPromotionProducts = errors.New("products must belong to the distributor")
+	ErrCouponNotFound    = errors.New("coupon code is not valid")
+)
+
+type PromotionService struct {
+	promotionRepository *repository.PromotionRepository
+	productRepository   *repository.ProductRepository
+}
+
+func NewPromotionService(promotionRepository *repository.PromotionRepository, productRepository *repository.ProductRepository) *PromotionService {
+	return &PromotionService{promotionRepository: promotionRepository, productRepository: productRepository}
+}
+
+func (ps *PromotionService) GetPromotions(distributorID int64, filters models.Filters) ([]models.Promotion, models.Metadata, error) {
+	return ps.promotionRepository.GetPromotions(distributorID, filters)
+}
+
+// GetPromotion returns the promotion of the distributor with a report of the
+// orders it was applied to.
+func (ps *PromotionService) GetPromotion(distributorID, promotionID int64) (*models.Promotion, *models.PromotionReport, error) {
+	promotion, err := ps.promotionRepository.GetPromotion(distributorID, promotionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	report, err := ps.promotionRepository.GetPromotionReport(promotion.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return promotion, report, nil
+}
+
+// SavePromotion creates or updates the promotion. Changes apply to checkouts
+// from then on; orders keep the discount they were placed with.
+func (ps *PromotionService) SavePromotion(promotion *models.Promotion) error {
+	for _, productID := range promotion.ProductIDs {
+		product, err := ps.productRepository.GetProductByID(productID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if product == nil || product.DistributorID != promotion.DistributorID {
+			return ErrPromotionProducts
+		}
+	}
+	if promotion.ID == 0 {
+		return ps.promotionRepository.CreatePromotion(promotion)
+	}
+	return ps.promotionRepository.UpdatePromotion(promotion)
+}
+
+func (ps *PromotionService) DeletePromotion(distributorID, promotionID int64) error {
+	return ps.promotionRepository.DeletePromotion(distributorID, promotionID)
+}
+
+// CheckCoupon returns ErrCouponNotFound unless a promotion the store has not
+// used up runs with the coupon code.
+func (ps *PromotionService) CheckCoupon(code string, storeID int64) error {
+	ok, err := ps.promotionRepository.CouponAvailable(code, storeID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCouponNotFound
+	}
+	return nil
+}
+
+// ApplyPromotions sets the best promotion of each item of the cart for
+// delivery to the city and the tax and totals after the discounts. The
+// products and distributors of the items must be loaded.
+func (ps *PromotionService) ApplyPromotions(cart *models.Cart, city string, now time.Time) error {
+	return applyPromotions(ps.promotionRepository, cart, city, now)
+}
+
+// applyPromotionsTx applies the promotions at checkout. Promotions with usage
+// limits stay locked until the transaction ends, so the orders it creates
+// count towards them before another checkout can use them.
+func (ps *PromotionService) applyPromotionsTx(tx *gorm.DB, cart *models.Cart, city string, now time.Time) error {
+	promotionRepository := ps.promotionRepository.WithTx(tx)
+	if err := promotionRepository.LockLimitedPromotions(cartDistributors(cart)); err != nil {
+		return err
+	}
+	return applyPromotions(promotionRepository, cart, city, now)
+}
+
+func applyPromotions(promotionRepository *repository.PromotionRepository, cart *models.Cart, city string, now time.Time) error {
+	distributorIDs := cartDistributors(cart)
+	var promotions []models.Promotion
+	if len(distributorIDs) > 0 {
+		var err error
+		promotions, err = promotionRepository.GetAvailablePromotions(distributorIDs, cart.StoreID, now)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		item.LineDiscount = models.LineDiscount{}
+		promotion, discount := models.BestPromotion(promotions, &item.Product, item.Quantity, cart.StoreID, city, cart.CouponCode, now)
+		if promotion != nil {
+			item.LineDiscount = models.LineDiscount{PromotionID: &promotion.ID, PromotionName: promotion.Name, DiscountAmount: discount}
+		}
+	}
+	cart.CalculateTax()
+	return nil
+}
+
+func cartDistributors(cart *models.Cart) []int64 {
+	var distributorIDs []int64
+	seen := make(map[int64]bool)
+	for _, item := range cart.Items {
+		if !seen[item.Product.DistributorID] {
+			seen[item.Product.DistributorID] = true
+			distributorIDs = append(distributorIDs, item.Product.DistributorID)
+		}
+	}
+	return distributorIDs
+}
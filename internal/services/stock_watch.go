@@ -0,0 +1,46 @@
+package services
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+)
+
+// stockWatch records events.ProductLowStock when a stock change takes a
+// product from above its low stock threshold to at or below it. A threshold of
+// zero turns the alert off, and deleted products are not watched. It must be
+// used inside the transaction of the change.
+type stockWatch struct {
+	productRepository *repository.ProductRepository
+	outbox            *events.Outbox
+	tx                *gorm.DB
+	before            *models.Product
+}
+
+func watchStock(tx *gorm.DB, outbox *events.Outbox, productID int64) *stockWatch {
+	productRepository := repository.NewProductRepository(tx)
+	before, err := productRepository.GetProductByID(productID)
+	if err != nil {
+		before = nil
+	}
+	return &stockWatch{productRepository: productRepository, outbox: outbox, tx: tx, before: before}
+}
+
+func (sw *stockWatch) done() error {
+	if sw.before == nil || sw.before.LowStockThreshold <= 0 || sw.before.Stock <= sw.before.LowStockThreshold {
+		return nil
+	}
+	product, err := sw.productRepository.GetProductByID(sw.before.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if product.Stock > product.LowStockThreshold {
+		return nil
+	}
+	return sw.outbox.Record(sw.tx, events.NewProductEvent(events.ProductLowStock, product))
+}
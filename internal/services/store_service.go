@@ -1,18 +1,24 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"gorm.io/gorm"
 	"marketplace-api/internal/models"
 	"marketplace-api/internal/repository"
+	"strings"
 )
 
+var ErrCityNotServed = errors.New("city is not served by any distributor")
+
 type StoreService struct {
-	storeRepository *repository.StoreRepository
-	userRepository  *repository.UserRepository
+	storeRepository       *repository.StoreRepository
+	userRepository        *repository.UserRepository
+	distributorRepository *repository.DistributorRepository
 }
 
-func NewStoreService(storeRepository *repository.StoreRepository, userRepository *repository.UserRepository) *StoreService {
-	return &StoreService{storeRepository: storeRepository, userRepository: userRepository}
+func NewStoreService(storeRepository *repository.StoreRepository, userRepository *repository.UserRepository, distributorRepository *repository.DistributorRepository) *StoreService {
+	return &StoreService{storeRepository: storeRepository, userRepository: userRepository, distributorRepository: distributorRepository}
 }
 
 func (ss *StoreService) CreateStore(store *models.Store) error {
@@ -44,3 +50,111 @@ func (ss *StoreService) GetStoreByUserID(userID int64) (*models.Store, error) {
 	store.User = *user
 	return store, nil
 }
+
+func (ss *StoreService) GetServedCities() ([]string, error) {
+	return ss.distributorRepository.GetServedCities()
+}
+
+// NormaliseCity matches the city against the cities distributors serve and
+// returns it in their spelling.
+func (ss *StoreService) NormaliseCity(city string) (string, error) {
+	cities, err := ss.distributorRepository.GetServedCities()
+	if err != nil {
+		return "", err
+	}
+	city = strings.Join(strings.Fields(city), " ")
+	for _, served := range cities {
+		if strings.EqualFold(strings.TrimSpace(served), city) {
+			return strings.TrimSpace(served), nil
+		}
+	}
+	return "", ErrCityNotServed
+}
+
+func (ss *StoreService) CreateAddress(address *models.StoreAddress) error {
+	city, err := ss.NormaliseCity(address.City)
+	if err != nil {
+		return err
+	}
+	address.City = city
+
+	_, err = ss.storeRepository.GetDefaultAddress(address.StoreID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		address.IsDefault = true
+	}
+	isDefault := address.IsDefault
+	address.IsDefault = false
+	if err := ss.storeRepository.CreateAddress(address); err != nil {
+		return err
+	}
+	if isDefault {
+		address.IsDefault = true
+		return ss.storeRepository.SetDefaultAddress(address.StoreID, address.ID)
+	}
+	return nil
+}
+
+func (ss *StoreService) UpdateAddress(address *models.StoreAddress) error {
+	city, err := ss.NormaliseCity(address.City)
+	if err != nil {
+		return err
+	}
+	address.City = city
+	if err := ss.storeRepository.UpdateAddress(address); err != nil {
+		return err
+	}
+	if address.IsDefault {
+		return ss.storeRepository.SetDefaultAddress(address.StoreID, address.ID)
+	}
+	return nil
+}
+
+// DeleteAddress removes the address; if it was the default one, the oldest
+// remaining address becomes the default.
+func (ss *StoreService) DeleteAddress(storeID, addressID int64) error {
+	address, err := ss.storeRepository.GetAddressByID(storeID, addressID)
+	if err != nil {
+		return err
+	}
+	if err := ss.storeRepository.DeleteAddress(storeID, addressID); err != nil {
+		return err
+	}
+	if !address.IsDefault {
+		return nil
+	}
+	addresses, err := ss.storeRepository.GetAddresses(storeID)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	return ss.storeRepository.SetDefaultAddress(storeID, addresses[0].ID)
+}
+
+func (ss *StoreService) SetDefaultAddress(storeID, addressID int64) error {
+	if _, err := ss.storeRepository.GetAddressByID(storeID, addressID); err != nil {
+		return err
+	}
+	return ss.storeRepository.SetDefaultAddress(storeID, addressID)
+}
+
+func (ss *StoreService) GetAddressByID(storeID, addressID int64) (*models.StoreAddress, error) {
+	return ss.storeRepository.GetAddressByID(storeID, addressID)
+}
+
+func (ss *StoreService) GetAddresses(storeID int64) ([]models.StoreAddress, error) {
+	return ss.storeRepository.GetAddresses(storeID)
+}
+
+// GetCheckoutAddress returns the saved address chosen for checkout, or the
+// store's default address when none is chosen.
+func (ss *StoreService) GetCheckoutAddress(storeID int64, addressID *int64) (*models.StoreAddress, error) {
+	if addressID != nil {
+		return ss.storeRepository.GetAddressByID(storeID, *addressID)
+	}
+	return ss.storeRepository.GetDefaultAddress(storeID)
+}
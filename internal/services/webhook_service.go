@@ -0,0 +1,350 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"marketplace-api/internal/repository"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it fails.
+	webhookMaxAttempts = 10
+	// webhookDisableAfter consecutive failed attempts disable the webhook.
+	webhookDisableAfter = 20
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = 6 * time.Hour
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 50
+	// webhookResponseLimit caps the response body kept in the delivery log.
+	webhookResponseLimit = 256
+
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// WebhookPayload is the body posted to webhook endpoints.
+type WebhookPayload struct {
+	ID         string      `json:"id"`
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// ErrWebhookURL is returned for webhook URLs whose host does not resolve to
+// public addresses.
+var ErrWebhookURL = errors.New("must resolve to a public address")
+
+// blockedPrefixes are address ranges webhooks may not call besides loopback,
+// private, link-local, multicast and unspecified addresses.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+type WebhookService struct {
+	webhookRepository *repository.WebhookRepository
+	client            *http.Client
+}
+
+func NewWebhookService(webhookRepository *repository.WebhookRepository) *WebhookService {
+	return &WebhookService{webhookRepository: webhookRepository, client: newWebhookClient()}
+}
+
+// newWebhookClient returns the client webhooks are sent with. It refuses to
+// connect to addresses that are not public, whatever the host resolves to when
+// the call is made, and neither follows redirects nor uses proxies.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicAddr reports whether webhooks may call the address.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookURL returns ErrWebhookURL unless every address the host of the
+// URL resolves to is public. The client checks the address again when it
+// connects, as the host may resolve differently by then.
+func checkWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ErrWebhookURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrWebhookURL
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return ErrWebhookURL
+		}
+	}
+	return nil
+}
+
+func (ws *WebhookService) CreateWebhook(webhook *models.Webhook) error {
+	if err := checkWebhookURL(webhook.URL); err != nil {
+		return err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return err
+	}
+	webhook.Secret = secret
+	return ws.webhookRepository.CreateWebhook(webhook)
+}
+
+// UpdateWebhook saves the webhook. Turning a disabled webhook back on clears
+// its failure count.
+func (ws *WebhookService) UpdateWebhook(webhook *models.Webhook) error {
+	if err := checkWebhookURL(webhook.URL); err != nil {
+		return err
+	}
+	if webhook.Active {
+		webhook.ConsecutiveFailures = 0
+		webhook.DisabledAt = nil
+	}
+	return ws.webhookRepository.UpdateWebhook(webhook)
+}
+
+func (ws *WebhookService) RotateSecret(webhook *models.Webhook) error {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return err
+	}
+	webhook.Secret = secret
+	return ws.webhookRepository.UpdateWebhook(webhook)
+}
+
+func (ws *WebhookService) DeleteWebhook(webhookID int64) error {
+	return ws.webhookRepository.DeleteWebhook(webhookID)
+}
+
+func (ws *WebhookService) GetWebhookByID(webhookID int64) (*models.Webhook, error) {
+	return ws.webhookRepository.GetWebhookByID(webhookID)
+}
+
+func (ws *WebhookService) GetWebhooks(distributorID int64) ([]models.Webhook, error) {
+	return ws.webhookRepository.GetWebhooksByDistributorID(distributorID)
+}
+
+func (ws *WebhookService) GetDeliveryByID(deliveryID int64) (*models.WebhookDelivery, error) {
+	return ws.webhookRepository.GetDeliveryByID(deliveryID)
+}
+
+func (ws *WebhookService) GetDeliveries(webhookID int64, status string, filters models.Filters) ([]models.WebhookDelivery, models.Metadata, error) {
+	return ws.webhookRepository.GetDeliveries(webhookID, status, filters)
+}
+
+// HandleEvent writes a delivery for every webhook subscribed to the event.
+// The deliveries are sent by DeliverDue.
+// Events relayed again from the outbox do not queue a second delivery.
+func (ws *WebhookService) HandleEvent(event events.Event) error {
+	if event.DistributorID == 0 {
+		return nil
+	}
+	webhooks, err := ws.webhookRepository.GetSubscribedWebhooks(event.DistributorID, event.Name)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	eventID := uuid.NewString()
+	if event.ID != 0 {
+		eventID = "evt_" + strconv.FormatInt(event.ID, 10)
+		queued, err := ws.webhookRepository.GetQueuedWebhookIDs(eventID)
+		if err != nil {
+			return err
+		}
+		webhooks = slices.DeleteFunc(webhooks, func(webhook models.Webhook) bool {
+			return slices.Contains(queued, webhook.ID)
+		})
+	}
+	body, err := json.Marshal(WebhookPayload{
+		ID:         eventID,
+		Event:      event.Name,
+		OccurredAt: event.OccurredAt,
+		Data:       event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       eventID,
+			Event:         event.Name,
+			Payload:       body,
+			Status:        models.DeliveryStatusPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+	return ws.webhookRepository.CreateDeliveries(deliveries)
+}
+
+// Replay queues the payload of a delivery again as a new delivery.
+func (ws *WebhookService) Replay(delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	replay := models.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		EventID:       delivery.EventID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        models.DeliveryStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	deliveries := []models.WebhookDelivery{replay}
+	if err := ws.webhookRepository.CreateDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+// DeliverDue sends up to a batch of the deliveries that are due and returns
+// how many it tried. Deliveries are claimed one at a time, so the lease of each
+// only has to cover its own call.
+func (ws *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	for i := 0; i < webhookBatchSize; i++ {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		delivery, err := ws.webhookRepository.ClaimDueDelivery(time.Now(), 2*webhookTimeout)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return i, nil
+		}
+		if err != nil {
+			return i, err
+		}
+		if err := ws.deliver(ctx, delivery); err != nil {
+			return i, err
+		}
+	}
+	return webhookBatchSize, nil
+}
+
+func (ws *WebhookService) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	webhook := &delivery.Webhook
+	attempt := &models.WebhookAttempt{DeliveryID: delivery.ID}
+	started := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err == nil {
+		timestamp := strconv.FormatInt(started.Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "marketplace-webhooks/1.0")
+		req.Header.Set(WebhookEventHeader, delivery.Event)
+		req.Header.Set(WebhookDeliveryHeader, delivery.EventID)
+		req.Header.Set(WebhookSignatureHeader, fmt.Sprintf("t=%s,v1=%s", timestamp, SignWebhook(webhook.Secret, timestamp, delivery.Payload)))
+
+		var resp *http.Response
+		resp, err = ws.client.Do(req)
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+			_ = resp.Body.Close()
+			attempt.StatusCode = resp.StatusCode
+			attempt.ResponseBody = strings.ToValidUTF8(string(body), "")
+		}
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	attempt.DurationMs = time.Since(started).Milliseconds()
+
+	delivery.Attempts++
+	now := time.Now()
+	delivered := err == nil && attempt.StatusCode >= 200 && attempt.StatusCode < 300
+	if delivered {
+		delivery.Status = models.DeliveryStatusDelivered
+		delivery.DeliveredAt = &now
+	} else if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = models.DeliveryStatusFailed
+	} else {
+		delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+	}
+	return ws.webhookRepository.RecordAttempt(delivery, attempt, delivered, webhookDisableAfter, now)
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.body" with the webhook
+// secret. Receivers recompute it to verify the X-Webhook-Signature header.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff doubles the wait after every failed attempt.
+func webhookBackoff(attempts int64) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := int64(1); i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"marketplace-api/internal/config"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid object key")
+
+// BlobStore keeps uploaded files. Objects are addressed by keys and served
+// from URLs under a public base URL, so every API replica and any deployment
+// hands out the same URL for a file.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens the object for reading; the caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// URL returns the URL clients download the object from.
+	URL(key string) string
+	// Key returns the key of the object served from url, and false for URLs
+	// that are not served by the store.
+	Key(url string) (string, bool)
+}
+
+// Signer is implemented by stores that can keep objects private. The API
+// serves the public URLs of private objects by redirecting to a short-lived
+// signed URL.
+type Signer interface {
+	Private() bool
+	SignedURL(key string) (string, error)
+}
+
+// NewBlobStore returns the store selected by STORAGE_DRIVER.
+func NewBlobStore(cfg *config.Config) (BlobStore, error) {
+	switch cfg.StorageDriver {
+	case "", "local":
+		return NewLocalStore(cfg.StorageLocalDir, cfg.StoragePublicURL), nil
+	case "s3":
+		return NewS3Store(S3Options{
+			Endpoint:     cfg.S3Endpoint,
+			Region:       cfg.S3Region,
+			Bucket:       cfg.S3Bucket,
+			AccessKey:    cfg.S3AccessKey,
+			SecretKey:    cfg.S3SecretKey,
+			PathStyle:    cfg.S3PathStyle,
+			PublicURL:    cfg.StoragePublicURL,
+			SignedURLTTL: cfg.S3SignedURLTTL,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+}
+
+// validKey rejects keys that could escape the store's root.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// publicURL joins the base URL and the key.
+func publicURL(base, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+// publicKey is the inverse of publicURL.
+func publicKey(base, url string) (string, bool) {
+	prefix := strings.TrimSuffix(base, "/") + "/"
+	if !strings.HasPrefix(url, prefix) {
+		return "", false
+	}
+	key := strings.TrimPrefix(url, prefix)
+	return key, validKey(key)
+}
@@ -0,0 +1,95 @@
+package stream
+
+import (
+	"marketplace-api/internal/events"
+	"marketplace-api/internal/models"
+	"slices"
+	"sync"
+)
+
+// clientBuffer is how many events a client may fall behind before it is
+// disconnected. Disconnected clients resume with Last-Event-ID.
+const clientBuffer = 64
+
+// Events are the events pushed to each role.
+var Events = map[string][]string{
+	models.RoleDistributor: {events.OrderCreated, events.OrderStageChanged, events.OrderCancelled, events.ReviewCreated, events.MessageCreated},
+	models.RoleStore:       {events.OrderCreated, events.OrderStageChanged, events.OrderCancelled, events.MessageCreated},
+}
+
+// Visible reports whether the event is pushed to the user.
+func Visible(event events.Event, role string, userID int64) bool {
+	if !slices.Contains(Events[role], event.Name) {
+		return false
+	}
+	switch role {
+	case models.RoleDistributor:
+		return event.DistributorID == userID
+	case models.RoleStore:
+		return event.StoreID == userID
+	}
+	return false
+}
+
+// Client is a connected stream. Events is closed when the client falls too far
+// behind or is unsubscribed.
+type Client struct {
+	Role   string
+	UserID int64
+	Events chan events.Event
+}
+
+// Hub fans events out to the clients connected to this API instance.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*Client]struct{})}
+}
+
+func (h *Hub) Subscribe(role string, userID int64) *Client {
+	client := &Client{Role: role, UserID: userID, Events: make(chan events.Event, clientBuffer)}
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+	return client
+}
+
+func (h *Hub) Unsubscribe(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.Events)
+	}
+}
+
+// DisconnectAll closes the streams of all clients.
+func (h *Hub) DisconnectAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		delete(h.clients, client)
+		close(client.Events)
+	}
+}
+
+// Broadcast sends the event to the clients it is visible to without waiting
+// for slow ones.
+func (h *Hub) Broadcast(event events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		if !Visible(event, client.Role, client.UserID) {
+			continue
+		}
+		select {
+		case client.Events <- event:
+		default:
+			delete(h.clients, client)
+			close(client.Events)
+		}
+	}
+}
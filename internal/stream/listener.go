@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"marketplace-api/internal/events"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Channel is the Postgres channel relayed events are announced on. Every API
+// instance listens on it, so clients receive events whichever instance relayed
+// them.
+const Channel = "marketplace_events"
+
+// Notify returns a bus handler that announces streamed events on Channel as
+// "<event ID>:<sequence>". The sequence is sent along because the relay has not
+// stored it yet.
+func Notify(db *gorm.DB) events.Handler {
+	return func(event events.Event) error {
+		if event.ID == 0 || !streamed(event.Name) {
+			return nil
+		}
+		payload := strconv.FormatInt(event.ID, 10) + ":" + strconv.FormatInt(event.Sequence, 10)
+		return db.Exec("SELECT pg_notify(?, ?)", Channel, payload).Error
+	}
+}
+
+func streamed(name string) bool {
+	for _, names := range Events {
+		if slices.Contains(names, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listener receives the announced events and broadcasts them to the hub.
+type Listener struct {
+	dsn    string
+	outbox *events.Outbox
+	hub    *Hub
+	logger *logrus.Logger
+}
+
+func NewListener(dsn string, outbox *events.Outbox, hub *Hub, logger *logrus.Logger) *Listener {
+	return &Listener{dsn: dsn, outbox: outbox, hub: hub, logger: logger}
+}
+
+// Run listens until ctx is cancelled. The connection is re-established by
+// the driver when it drops; the clients are then disconnected so that they
+// reconnect with Last-Event-ID and receive the events announced meanwhile.
+// On shutdown the clients are disconnected so that the server can stop.
+func (l *Listener) Run(ctx context.Context) error {
+	listener := pq.NewListener(l.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			l.logger.Errorf("stream listener: %s", err.Error())
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(Channel); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			l.hub.DisconnectAll()
+			return nil
+		case notification := <-listener.Notify:
+			if notification == nil {
+				l.hub.DisconnectAll()
+				continue
+			}
+			id, seq, _ := strings.Cut(notification.Extra, ":")
+			eventID, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				continue
+			}
+			sequence, err := strconv.ParseInt(seq, 10, 64)
+			if err != nil {
+				continue
+			}
+			event, err := l.outbox.GetEventByID(eventID)
+			if err != nil {
+				l.logger.Errorf("stream listener: failed to load event %d: %s", eventID, err.Error())
+				continue
+			}
+			event.Sequence = sequence
+			l.hub.Broadcast(*event)
+		case <-time.After(90 * time.Second):
+			go func() {
+				_ = listener.Ping()
+			}()
+		}
+	}
+}
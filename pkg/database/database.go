@@ -9,12 +9,15 @@ import (
 	"marketplace-api/internal/models"
 )
 
-// InitDB initializes the database connection
-func InitDB(cfg *config.Config) (*gorm.DB, error) {
-	dbURI := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable",
+// DSN returns the connection string of the database
+func DSN(cfg *config.Config) string {
+	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBName, cfg.DBPassword)
+}
 
-	db, err := gorm.Open(postgres.Open(dbURI), &gorm.Config{})
+// InitDB initializes the database connection
+func InitDB(cfg *config.Config) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(DSN(cfg)), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
@@ -31,11 +34,88 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 		&models.Stage{},
 		&models.StatusUser{},
 		&models.Review{},
+		&models.DeliverySlot{},
+		&models.DeliverySlotUsage{},
+		&models.StoreAddress{},
+		&models.Warehouse{},
+		&models.StockMovement{},
+		&models.ProductImport{},
+		&models.ProductImportError{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.WebhookAttempt{},
+		&models.OutboxEvent{},
+		&models.Notification{},
+		&models.NotificationPreference{},
+		&models.MessageThread{},
+		&models.Message{},
+		&models.Upload{},
+		&models.ReviewFlag{},
+		&models.RatingSummary{},
+		&models.Invoice{},
+		&models.Payment{},
+		&models.CreditAccount{},
+		&models.CommissionRule{},
+		&models.PayoutLine{},
+		&models.Statement{},
+		&models.Promotion{},
+		&models.Document{},
+		&models.Sequence{},
 	)
 	if err != nil {
 		return nil, errors.New("failed to start database " + err.Error())
 	}
 
+	err = migrateOrderSnapshots(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate orders " + err.Error())
+	}
+
+	err = migrateStockLedger(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate stock " + err.Error())
+	}
+
+	err = migrateMovementProducts(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate stock " + err.Error())
+	}
+
+	err = migrateReviews(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate reviews " + err.Error())
+	}
+
+	err = migrateRatingSummaries(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate ratings " + err.Error())
+	}
+
+	err = migrateTaxAmounts(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate tax amounts " + err.Error())
+	}
+
+	err = migrateStageTimes(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate stages " + err.Error())
+	}
+
+	err = migrateCartTimes(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate carts " + err.Error())
+	}
+
+	err = migrateOrderNumbers(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate order numbers " + err.Error())
+	}
+
+	err = migrateDispatchSequence(db)
+	if err != nil {
+		return nil, errors.New("failed to migrate outbox " + err.Error())
+	}
+
 	err = creatAdmin(cfg.AdminEmail, cfg.AdminPassword, db)
 	if err != nil {
 		return nil, errors.New("failed to create admin user " + err.Error())
@@ -45,6 +125,225 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 	return db, nil
 }
 
+// migrateOrderSnapshots stops product deletes from cascading into orders and
+// fills snapshots of orders created before snapshots existed.
+func migrateOrderSnapshots(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`ALTER TABLE orders ALTER COLUMN product_id DROP NOT NULL`,
+			`ALTER TABLE orders DROP CONSTRAINT IF EXISTS fk_orders_product`,
+			`ALTER TABLE orders ADD CONSTRAINT fk_orders_product FOREIGN KEY (product_id)
+				REFERENCES products(id) ON UPDATE CASCADE ON DELETE SET NULL`,
+			`UPDATE orders SET
+				snapshot_product_name = p.product_name,
+				snapshot_sku = p.sku,
+				snapshot_unit_price = CASE WHEN orders.quantity > 0 THEN orders.total_price / orders.quantity ELSE p.price END,
+				snapshot_img_urls = p.img_urls,
+				snapshot_category = p.category,
+				snapshot_distributor_name = d.name,
+				snapshot_distributor_company_name = d.company_name,
+				snapshot_distributor_bin = d.bin,
+				snapshot_distributor_phone = d.phone_number,
+				snapshot_distributor_city = d.city
+			FROM products p
+			LEFT JOIN distributors d ON d.id = p.distributor_id
+			WHERE orders.product_id = p.id AND COALESCE(orders.snapshot_product_name, '') = ''`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateMovementProducts stops product deletes from cascading into the stock
+// ledger; products with movements are soft deleted instead.
+func migrateMovementProducts(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`ALTER TABLE stock_movements DROP CONSTRAINT IF EXISTS fk_stock_movements_product`,
+			`ALTER TABLE stock_movements ADD CONSTRAINT fk_stock_movements_product FOREIGN KEY (product_id)
+				REFERENCES products(id) ON UPDATE CASCADE ON DELETE RESTRICT`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateReviews marks reviews written before moderation as published when
+// they were created.
+func migrateReviews(db *gorm.DB) error {
+	return db.Exec(`UPDATE reviews SET published_at = COALESCE(created_at, NOW())
+		WHERE status = 'published' AND published_at IS NULL`).Error
+}
+
+// migrateRatingSummaries builds the rating summaries of products and
+// distributors reviewed before summaries existed. Later reviews update them
+// incrementally.
+func migrateRatingSummaries(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for subjectType, column := range map[string]string{
+			models.RatingSubjectProduct:     "product_id",
+			models.RatingSubjectDistributor: "distributor_id",
+		} {
+			statement := `INSERT INTO rating_summaries (subject_type, subject_id, count, sum, average, distribution)
+			SELECT ?, r.` + column + `, COUNT(*), SUM(r.rating), AVG(r.rating),
+				ARRAY[COUNT(*) FILTER (WHERE r.rating = 1), COUNT(*) FILTER (WHERE r.rating = 2),
+					COUNT(*) FILTER (WHERE r.rating = 3), COUNT(*) FILTER (WHERE r.rating = 4),
+					COUNT(*) FILTER (WHERE r.rating = 5)]
+			FROM reviews r
+			WHERE r.rating BETWEEN 1 AND 5 AND r.status = 'published'
+			GROUP BY r.` + column + `
+			ON CONFLICT DO NOTHING`
+			if err := tx.Exec(statement, subjectType).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateTaxAmounts fills the net amounts of orders and invoices created
+// before tax was calculated, which had no tax on them.
+func migrateTaxAmounts(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`UPDATE orders SET net_amount = total_price
+			WHERE net_amount = 0 AND tax_amount = 0 AND total_price <> 0`,
+			`UPDATE invoices SET net_amount = amount
+			WHERE net_amount = 0 AND tax_amount = 0 AND amount <> 0`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateStageTimes sets when orders entered their stage for stages created
+// before it was recorded, which is at best the checkout time.
+func migrateStageTimes(db *gorm.DB) error {
+	return db.Exec(`UPDATE stages SET changed_at = orders."timestamp"
+		FROM orders WHERE orders.stage_id = stages.id AND stages.changed_at IS NULL`).Error
+}
+
+// migrateCartTimes starts the idle time of carts created before it was
+// recorded now, so they are purged once they stay idle from here on.
+func migrateCartTimes(db *gorm.DB) error {
+	return db.Exec(`UPDATE carts SET updated_at = NOW() WHERE updated_at IS NULL`).Error
+}
+
+// migrateOrderNumbers numbers the orders placed before order numbers existed
+// in the order they were placed, continues the distributors' sequences after
+// them and makes numbers unique.
+func migrateOrderNumbers(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`UPDATE orders SET number = 'DST' || n.distributor_id || '-' || n.year || '-' ||
+				LPAD(n.sequence::text, GREATEST(6, LENGTH(n.sequence::text)), '0')
+			FROM (SELECT o.id, o.distributor_id, o.year, o.position + COALESCE(s.last_value, 0) AS sequence
+				FROM (SELECT id, distributor_id, EXTRACT(YEAR FROM "timestamp")::int AS year,
+						ROW_NUMBER() OVER (PARTITION BY distributor_id, EXTRACT(YEAR FROM "timestamp") ORDER BY "timestamp", id) AS position
+					FROM orders WHERE number = '') o
+				LEFT JOIN sequences s ON s.distributor_id = o.distributor_id AND s.name = 'order-' || o.year) n
+			WHERE orders.id = n.id`)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			err := tx.Exec(`INSERT INTO sequences (distributor_id, name, last_value)
+			SELECT distributor_id, 'order-' || SPLIT_PART(number, '-', 2), MAX(SPLIT_PART(number, '-', 3)::bigint)
+			FROM orders WHERE number <> ''
+			GROUP BY 1, 2
+			ON CONFLICT (distributor_id, name) DO UPDATE SET last_value = GREATEST(sequences.last_value, EXCLUDED.last_value)`).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_number ON orders (number) WHERE number <> ''`).Error
+	})
+}
+
+// migrateDispatchSequence creates the sequence dispatched outbox events are
+// numbered from and numbers the events dispatched before it, in the order they
+// were dispatched. It starts after the highest event ID, so stream clients
+// resuming with an event ID from before get the events again rather than miss
+// any.
+func migrateDispatchSequence(db *gorm.DB) error {
+	var exists bool
+	if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_class WHERE relkind = 'S' AND relname = 'outbox_dispatch_seq')`).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var start int64
+		if err := tx.Raw(`SELECT COALESCE(MAX(id), 0) + 1 FROM outbox_events`).Scan(&start).Error; err != nil {
+			return err
+		}
+		statements := []string{
+			fmt.Sprintf(`CREATE SEQUENCE outbox_dispatch_seq START WITH %d`, start),
+			`UPDATE outbox_events SET dispatch_seq = n.seq
+			FROM (SELECT id, nextval('outbox_dispatch_seq') AS seq
+				FROM (SELECT id FROM outbox_events WHERE dispatched_at IS NOT NULL ORDER BY dispatched_at, id) d) n
+			WHERE outbox_events.id = n.id`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateStockLedger moves stock kept on products into the movement ledger:
+// every distributor with products gets a main warehouse, products get an
+// opening balance and active orders placed before the ledger get their
+// reservation, so available stock stays the same.
+func migrateStockLedger(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`INSERT INTO warehouses (distributor_id, name, city, address, active)
+			SELECT d.id, 'Main warehouse', d.city, '', true
+			FROM distributors d
+			WHERE EXISTS (SELECT 1 FROM products p WHERE p.distributor_id = d.id)
+				AND NOT EXISTS (SELECT 1 FROM warehouses w WHERE w.distributor_id = d.id)`,
+			`INSERT INTO stock_movements (warehouse_id, product_id, type, on_hand_delta, reserved_delta, note, created_by, created_at)
+			SELECT (SELECT MIN(w.id) FROM warehouses w WHERE w.distributor_id = p.distributor_id),
+				p.id, 'receipt', p.stock, 0, 'opening balance', p.distributor_id, NOW()
+			FROM products p
+			WHERE p.stock > 0
+				AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.product_id = p.id)`,
+			`INSERT INTO stock_movements (warehouse_id, product_id, order_id, type, on_hand_delta, reserved_delta, note, created_by, created_at)
+			SELECT (SELECT MIN(w.id) FROM warehouses w WHERE w.distributor_id = o.distributor_id),
+				o.product_id, o.id, m.type, m.on_hand_delta, m.reserved_delta, 'opening balance', o.distributor_id, NOW()
+			FROM orders o
+			JOIN stages s ON s.id = o.stage_id
+			CROSS JOIN LATERAL (VALUES ('receipt', o.quantity, 0), ('reservation', 0, o.quantity))
+				AS m(type, on_hand_delta, reserved_delta)
+			WHERE o.product_id IS NOT NULL AND o.status = 'active'
+				AND s.stage NOT IN ('backordered', 'success') AND s.status <> 'error'
+				AND EXISTS (SELECT 1 FROM warehouses w WHERE w.distributor_id = o.distributor_id)
+				AND NOT EXISTS (SELECT 1 FROM stock_movements sm WHERE sm.order_id = o.id)`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func creatAdmin(adminEmail, adminPassword string, db *gorm.DB) error {
 	var admin models.User
 	result := db.Where("email = ?", adminEmail).First(&admin)